@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"os"
 	"os/signal"
@@ -25,7 +26,10 @@ func main() {
 	pool := accounts.NewPool(cfg, httpClient)
 	solver := pow.NewSolver()
 	cache := pow.NewCache()
-	if err := solver.Warmup(); err != nil {
+	if err := solver.Warmup(); errors.Is(err, pow.ErrNoWorkingSolver) {
+		logger.Errorf("PoW solver warmup failed: %v", err)
+		os.Exit(1)
+	} else if err != nil {
 		logger.Warnf("PoW solver warmup failed: %v", err)
 	}
 	ds := clients.NewDeepSeekClient(httpClient, cfg.URLSession(), cfg.URLCreatePow(), cfg.URLCompletion())
@@ -52,6 +56,40 @@ func main() {
 	if sm, ok := st.Sync.(*cloudsync.SyncManager); ok {
 		go sm.Run(syncCtx)
 	}
+	sessionCtx, sessionCancel := context.WithCancel(context.Background())
+	if st.Sessions.Enabled() {
+		go st.Sessions.Run(sessionCtx)
+	}
+	if st.WarmSessions.Enabled() {
+		go st.WarmSessions.Run(sessionCtx)
+	}
+	if st.PowPrefetch.Enabled() {
+		go st.PowPrefetch.Run(sessionCtx)
+	}
+	go st.Pool.Run(sessionCtx)
+	go st.RunTokenPersistence(sessionCtx)
+	if st.TokenCheck.Enabled() {
+		go st.TokenCheck.Run(sessionCtx)
+	}
+
+	// SIGHUP re-reads the config and applies the account strategy, and the
+	// accounts themselves unless cloud sync owns them.
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			next := config.Load()
+			if err := st.SetAccountStrategy(next.AccountStrategy); err != nil {
+				logger.Warnf("config reload: %v", err)
+			}
+			if st.Sync == nil {
+				st.UpdateSyncRuntime(next.Refresh, next.MaxActiveAccounts, next.ClaudeModelMapping)
+				st.SetAccounts(next.Accounts)
+				st.Pool.Reload(next.Accounts, next.Refresh, next.MaxActiveAccounts)
+			}
+			logger.Infof("config reloaded, account strategy %s", st.Pool.StrategyName())
+		}
+	}()
 
 	go func() {
 		logger.Infof("server listening on :%s", cfg.Port)
@@ -65,6 +103,7 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	syncCancel()
+	sessionCancel()
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	_ = srv.Shutdown(ctx)
@@ -2,4 +2,4 @@ module deepseek2api-go
 
 go 1.22
 
-require github.com/tetratelabs/wazero v1.8.2 // indirect
+require github.com/tetratelabs/wazero v1.8.2
@@ -0,0 +1,223 @@
+package accounts
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Failure kinds reported against an account.
+const (
+	FailureLogin   = "login"
+	FailureSession = "session"
+	FailurePoW     = "pow"
+)
+
+const (
+	HealthHealthy  = "healthy"
+	HealthCooldown = "cooldown"
+	HealthHalfOpen = "half_open"
+	HealthDisabled = "disabled"
+	// HealthOff marks accounts switched off by configuration.
+	HealthOff = "off"
+)
+
+// ErrCredentialsRejected is returned by logins that DeepSeek answered without
+// a token; the account is disabled until a probe login succeeds.
+var ErrCredentialsRejected = errors.New("credentials rejected")
+
+// ErrNoPassword is returned when a token-only account is asked to log in.
+var ErrNoPassword = errors.New("account has no password to log in with")
+
+type healthPolicy struct {
+	cooldown    time.Duration
+	maxCooldown time.Duration
+	probe       time.Duration
+}
+
+// health is the circuit breaker state of one account. After a failure the
+// account cools down with exponential backoff, then lets a single request
+// through (half-open); that request's outcome closes or reopens the circuit.
+type health struct {
+	failures      int
+	lastKind      string
+	lastError     string
+	lastFailure   time.Time
+	cooldownUntil time.Time
+	probing       bool
+	disabled      bool
+	nextProbe     time.Time
+}
+
+func (h *health) state(now time.Time) string {
+	switch {
+	case h == nil:
+		return HealthHealthy
+	case h.disabled:
+		return HealthDisabled
+	case now.Before(h.cooldownUntil):
+		return HealthCooldown
+	case h.failures > 0:
+		return HealthHalfOpen
+	}
+	return HealthHealthy
+}
+
+// usableLocked reports whether id may be handed out right now.
+func (p *Pool) usableLocked(id string) bool {
+	switch p.health[id].state(time.Now()) {
+	case HealthHealthy:
+		return true
+	case HealthHalfOpen:
+		return !p.health[id].probing
+	}
+	return false
+}
+
+func (p *Pool) anyEnabledLocked() bool {
+	for i := range p.accounts {
+		if p.enabledAtLocked(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// enabledLocked reports whether id is in the pool and neither switched off
+// nor disabled by its health.
+func (p *Pool) enabledLocked(id string) bool {
+	for i := range p.accounts {
+		if p.AccountID(p.accounts[i]) == id {
+			return p.enabledAtLocked(i)
+		}
+	}
+	return false
+}
+
+func (p *Pool) enabledAtLocked(i int) bool {
+	h := p.health[p.AccountID(p.accounts[i])]
+	return !p.accounts[i].Disabled && (h == nil || !h.disabled)
+}
+
+// ReportFailure records a failed login, session or PoW attempt with id and
+// opens its circuit. Rejected credentials disable the account outright.
+func (p *Pool) ReportFailure(id, kind string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h := p.health[id]
+	if h == nil {
+		h = &health{}
+		p.health[id] = h
+	}
+	now := time.Now()
+	h.failures++
+	h.lastKind, h.lastFailure, h.probing = kind, now, false
+	if err != nil {
+		h.lastError = err.Error()
+	}
+	if errors.Is(err, ErrCredentialsRejected) {
+		h.disabled = true
+	}
+	if h.disabled {
+		h.nextProbe = now.Add(p.policy.probe)
+		return
+	}
+	backoff := p.policy.cooldown
+	for i := 1; i < h.failures && backoff < p.policy.maxCooldown; i++ {
+		backoff *= 2
+	}
+	h.cooldownUntil = now.Add(min(backoff, p.policy.maxCooldown))
+}
+
+// ReportSuccess closes id's circuit.
+func (p *Pool) ReportSuccess(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.health[id]; !ok {
+		return
+	}
+	delete(p.health, id)
+	p.dispatchLocked()
+}
+
+// Run hands accounts whose cooldown has ended to queued callers and probes
+// disabled accounts by logging in again.
+func (p *Pool) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		var probes []Account
+		p.mu.Lock()
+		p.dispatchLocked()
+		for i := range p.accounts {
+			h := p.health[p.AccountID(p.accounts[i])]
+			// A token-only account cannot log in, so only a config change
+			// or an admin enable brings it back.
+			if h != nil && h.disabled && !p.accounts[i].Disabled && strings.TrimSpace(p.accounts[i].Password) != "" && !now.Before(h.nextProbe) {
+				h.nextProbe = now.Add(p.policy.probe)
+				probes = append(probes, p.accounts[i])
+			}
+		}
+		p.mu.Unlock()
+		for _, a := range probes {
+			if err := p.refreshToken(&a); err != nil {
+				p.ReportFailure(p.AccountID(a), FailureLogin, err)
+				continue
+			}
+			p.ReportSuccess(p.AccountID(a))
+		}
+	}
+}
+
+func (p *Pool) healthStatusLocked() []map[string]any {
+	now := time.Now()
+	out := make([]map[string]any, 0, len(p.accounts))
+	for _, a := range p.accounts {
+		id := p.AccountID(a)
+		h := p.health[id]
+		state := h.state(now)
+		if a.Disabled {
+			state = HealthOff
+		}
+		entry := map[string]any{
+			"id":       id,
+			"state":    state,
+			"active":   p.active[id],
+			"weight":   a.Weight,
+			"password": redact(a.Password),
+			"token":    redact(a.Token),
+		}
+		if a.TokenIssuedAt > 0 {
+			entry["token_age_seconds"] = int64(now.Sub(time.Unix(a.TokenIssuedAt, 0)).Seconds())
+		}
+		if c, ok := p.tokenChecks[id]; ok {
+			entry["token_checked_at"] = c.at.Unix()
+			entry["token_check"] = c.result
+		}
+		if h != nil {
+			entry["consecutive_failures"] = h.failures
+			entry["last_failure_kind"] = h.lastKind
+			entry["last_error"] = h.lastError
+			entry["last_failure_at"] = h.lastFailure.Unix()
+			if now.Before(h.cooldownUntil) {
+				entry["cooldown_remaining_seconds"] = int(h.cooldownUntil.Sub(now).Seconds() + 0.5)
+			}
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "***"
+}
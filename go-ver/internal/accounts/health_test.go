@@ -0,0 +1,131 @@
+package accounts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"deepseek2api-go/internal/config"
+)
+
+func newHealthPool(emails ...string) *Pool {
+	cfg := config.Config{DeepSeekHost: "chat.deepseek.com"}
+	for _, e := range emails {
+		cfg.Accounts = append(cfg.Accounts, config.AccountConfig{Email: e, Password: "secret-pw", Token: "secret-token"})
+	}
+	p := NewPool(cfg, http.DefaultClient)
+	p.policy = healthPolicy{cooldown: 20 * time.Millisecond, maxCooldown: 50 * time.Millisecond, probe: time.Millisecond}
+	return p
+}
+
+func TestCooldownBackoff(t *testing.T) {
+	p := newHealthPool("a@example.com")
+	cases := []time.Duration{20 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond, 50 * time.Millisecond}
+	for i, want := range cases {
+		p.ReportFailure("a@example.com", FailureSession, errors.New("boom"))
+		p.mu.Lock()
+		got := time.Until(p.health["a@example.com"].cooldownUntil)
+		p.mu.Unlock()
+		if got > want || got < want-10*time.Millisecond {
+			t.Fatalf("failure %d: cooldown %v, want %v", i+1, got, want)
+		}
+	}
+	if _, ok := p.Acquire(nil); ok {
+		t.Fatal("acquired an account that is cooling down")
+	}
+}
+
+func TestHalfOpenLetsOneProbeThrough(t *testing.T) {
+	p := newHealthPool("a@example.com")
+	p.ReportFailure("a@example.com", FailurePoW, nil)
+	time.Sleep(25 * time.Millisecond)
+
+	probe, ok := p.Acquire(nil)
+	if !ok {
+		t.Fatal("half-open account was not handed out")
+	}
+	if _, ok := p.Acquire(nil); ok {
+		t.Fatal("half-open account handed out twice")
+	}
+	p.ReportSuccess(p.AccountID(*probe))
+	if _, ok := p.Acquire(nil); !ok {
+		t.Fatal("account still blocked after a successful probe")
+	}
+}
+
+func TestRejectedCredentialsDisableUntilProbe(t *testing.T) {
+	accept := make(chan bool, 1)
+	accept <- false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok := <-accept
+		accept <- ok
+		if !ok {
+			_, _ = w.Write([]byte(`{"data":{"biz_code":1,"biz_msg":"wrong password"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"biz_data":{"user":{"token":"fresh"}}}}`))
+	}))
+	defer srv.Close()
+	p := newHealthPool("a@example.com")
+	p.loginURL = srv.URL
+	p.refresh = true
+
+	ac, _ := p.Acquire(nil)
+	if err := p.EnsureToken(ac); !errors.Is(err, ErrCredentialsRejected) {
+		t.Fatalf("EnsureToken err = %v", err)
+	}
+	p.Release(ac)
+	if _, err := p.AcquireWait(context.Background(), nil); !errors.Is(err, ErrNoAccounts) {
+		t.Fatalf("AcquireWait on a disabled pool: err = %v", err)
+	}
+
+	<-accept
+	accept <- true
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Run(ctx)
+	wctx, wcancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer wcancel()
+	for {
+		if _, ok := p.Acquire(nil); ok {
+			break
+		}
+		if wctx.Err() != nil {
+			t.Fatal("account was not re-enabled by the probe")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRefreshTokenOnlyAccount(t *testing.T) {
+	cfg := config.Config{DeepSeekHost: "chat.deepseek.com", Accounts: []config.AccountConfig{{Email: "a@example.com", Token: "t"}}}
+	p := NewPool(cfg, http.DefaultClient)
+	if err := p.RefreshToken("a@example.com"); !errors.Is(err, ErrNoPassword) {
+		t.Fatalf("err = %v", err)
+	}
+	if st := p.GetStatus()["accounts"].([]map[string]any)[0]; st["state"] != HealthHealthy {
+		t.Fatalf("refresh without a password counted against the account: %v", st)
+	}
+}
+
+func TestStatusRedactsSecrets(t *testing.T) {
+	p := newHealthPool("a@example.com", "b@example.com")
+	p.ReportFailure("b@example.com", FailureLogin, ErrCredentialsRejected)
+	b, _ := json.Marshal(p.GetStatus())
+	out := string(b)
+	if strings.Contains(out, "secret-pw") || strings.Contains(out, "secret-token") {
+		t.Fatalf("status leaks secrets: %s", out)
+	}
+	states := map[string]string{}
+	for _, a := range p.GetStatus()["accounts"].([]map[string]any) {
+		states[a["id"].(string)] = a["state"].(string)
+	}
+	if states["a@example.com"] != HealthHealthy || states["b@example.com"] != HealthDisabled {
+		t.Fatalf("states = %v", states)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"math/rand"
 	"net/http"
 	"strings"
@@ -18,26 +19,84 @@ type Account struct {
 	Mobile   string `json:"mobile"`
 	Password string `json:"password"`
 	Token    string `json:"token"`
+	Weight   int    `json:"weight,omitempty"`
+	// TokenIssuedAt is the unix time Token was obtained, 0 if unknown.
+	TokenIssuedAt int64 `json:"token_issued_at,omitempty"`
+	Disabled      bool  `json:"disabled,omitempty"`
 }
 
 type Pool struct {
-	mu           sync.Mutex
-	accounts     []Account
-	active       map[string]int
-	refresh      bool
-	maxAccounts  int
-	httpClient   *http.Client
-	loginURL     string
-	baseHeaders  map[string]string
-	lastWarnUnix int64
+	mu             sync.Mutex
+	accounts       []Account
+	active         map[string]int
+	strategy       Strategy
+	strategyName   string
+	lastUsed       map[string]int64
+	useSeq         int64
+	maxPerAcct     int
+	queue          waitQueue
+	health         map[string]*health
+	policy         healthPolicy
+	tokenChecks    map[string]tokenCheck
+	refresh        bool
+	tokenTTL       time.Duration
+	onToken        func()
+	maxAccounts    int
+	httpClient     *http.Client
+	loginURL       string
+	currentUserURL string
+	baseHeaders    map[string]string
+	lastWarnUnix   int64
 }
 
 func NewPool(cfg config.Config, httpClient *http.Client) *Pool {
-	p := &Pool{active: map[string]int{}, httpClient: httpClient, loginURL: cfg.URLLogin(), baseHeaders: cfg.BaseHeaders()}
+	p := &Pool{active: map[string]int{}, lastUsed: map[string]int64{}, maxPerAcct: cfg.MaxSessionsPerAcct, health: map[string]*health{}, tokenChecks: map[string]tokenCheck{}, httpClient: httpClient, loginURL: cfg.URLLogin(), currentUserURL: cfg.URLCurrentUser(), baseHeaders: cfg.BaseHeaders()}
+	p.tokenTTL = time.Duration(cfg.TokenTTLHours) * time.Hour
+	p.policy = healthPolicy{
+		cooldown:    time.Duration(max(cfg.AccountHealth.CooldownSeconds, 1)) * time.Second,
+		maxCooldown: time.Duration(max(cfg.AccountHealth.MaxCooldownSeconds, cfg.AccountHealth.CooldownSeconds, 1)) * time.Second,
+		probe:       time.Duration(max(cfg.AccountHealth.ProbeSeconds, 1)) * time.Second,
+	}
+	if err := p.SetStrategy(cfg.AccountStrategy); err != nil {
+		_ = p.SetStrategy(StrategyRandom)
+	}
 	p.reloadLocked(cfg.Accounts, cfg.Refresh, cfg.MaxActiveAccounts)
 	return p
 }
 
+// SetStrategy swaps the account selection strategy; unknown names leave the
+// current one in place.
+func (p *Pool) SetStrategy(name string) error {
+	if name == "" {
+		name = StrategyRandom
+	}
+	s, err := NewStrategy(name)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if name == p.strategyName {
+		return nil
+	}
+	p.strategy, p.strategyName = s, name
+	return nil
+}
+
+// OnTokenRefresh registers fn to be called after a login stored a new token
+// in the pool. fn runs on the request path and must not block.
+func (p *Pool) OnTokenRefresh(fn func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onToken = fn
+}
+
+func (p *Pool) StrategyName() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.strategyName
+}
+
 func (p *Pool) AccountID(a Account) string {
 	if strings.TrimSpace(a.Email) != "" {
 		return strings.TrimSpace(a.Email)
@@ -45,6 +104,8 @@ func (p *Pool) AccountID(a Account) string {
 	return strings.TrimSpace(a.Mobile)
 }
 
+// Acquire picks a healthy account with spare capacity without waiting.
+// Excluded accounts are only used when nothing else is available.
 func (p *Pool) Acquire(exclude map[string]bool) (*Account, bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -55,38 +116,95 @@ func (p *Pool) Acquire(exclude map[string]bool) (*Account, bool) {
 		}
 		return nil, false
 	}
-	cands := make([]int, 0, len(p.accounts))
+	return p.pickLocked(exclude)
+}
+
+func (p *Pool) pickLocked(exclude map[string]bool) (*Account, bool) {
+	cands := make([]candidate, 0, len(p.accounts))
+	var excluded []candidate
 	for i := range p.accounts {
 		id := p.AccountID(p.accounts[i])
+		if p.accounts[i].Disabled || !p.hasCapacityLocked(id) || !p.usableLocked(id) {
+			continue
+		}
 		if exclude != nil && exclude[id] {
+			excluded = append(excluded, p.candidateLocked(i, id))
 			continue
 		}
-		cands = append(cands, i)
+		cands = append(cands, p.candidateLocked(i, id))
 	}
 	if len(cands) == 0 {
-		for i := range p.accounts {
-			cands = append(cands, i)
-		}
+		cands = excluded
 	}
-	idx := cands[rand.Intn(len(cands))]
-	id := p.AccountID(p.accounts[idx])
-	p.active[id]++
-	ac := p.accounts[idx]
+	if len(cands) == 0 {
+		return nil, false
+	}
+	c := cands[p.strategy.Pick(cands)]
+	p.markUsedLocked(c.id)
+	if h := p.health[c.id]; h != nil {
+		h.probing = true
+	}
+	ac := p.accounts[c.index]
 	return &ac, true
 }
 
+func (p *Pool) hasCapacityLocked(id string) bool {
+	return p.maxPerAcct <= 0 || p.active[id] < p.maxPerAcct
+}
+
+func (p *Pool) candidateLocked(i int, id string) candidate {
+	return candidate{index: i, id: id, active: p.active[id], weight: p.accounts[i].Weight, lastUsed: p.lastUsed[id]}
+}
+
+func (p *Pool) markUsedLocked(id string) {
+	p.active[id]++
+	p.useSeq++
+	p.lastUsed[id] = p.useSeq
+}
+
+func (p *Pool) AcquireID(id string) (*Account, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.acquireIDLocked(id)
+}
+
+func (p *Pool) acquireIDLocked(id string) (*Account, bool) {
+	for i := range p.accounts {
+		if p.AccountID(p.accounts[i]) != id {
+			continue
+		}
+		if p.accounts[i].Disabled || !p.hasCapacityLocked(id) || !p.usableLocked(id) {
+			return nil, false
+		}
+		p.markUsedLocked(id)
+		if h := p.health[id]; h != nil {
+			h.probing = true
+		}
+		ac := p.accounts[i]
+		return &ac, true
+	}
+	return nil, false
+}
+
 func (p *Pool) Release(a *Account) {
 	if a == nil {
 		return
 	}
-	id := p.AccountID(*a)
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	p.releaseLocked(p.AccountID(*a))
+}
+
+func (p *Pool) releaseLocked(id string) {
+	if h := p.health[id]; h != nil {
+		h.probing = false
+	}
 	if p.active[id] > 1 {
 		p.active[id]--
 	} else {
 		delete(p.active, id)
 	}
+	p.dispatchLocked()
 }
 
 func (p *Pool) GetStatus() map[string]any {
@@ -98,7 +216,17 @@ func (p *Pool) GetStatus() map[string]any {
 	for _, v := range p.active {
 		activeSessions += v
 	}
-	return map[string]any{"total": total, "available": total - inUse, "in_use": inUse, "active_sessions": activeSessions, "max_accounts": p.maxAccounts}
+	return map[string]any{
+		"total":                    total,
+		"available":                total - inUse,
+		"in_use":                   inUse,
+		"active_sessions":          activeSessions,
+		"max_accounts":             p.maxAccounts,
+		"strategy":                 p.strategyName,
+		"max_sessions_per_account": p.maxPerAcct,
+		"queue":                    p.queue.statusLocked(),
+		"accounts":                 p.healthStatusLocked(),
+	}
 }
 
 func (p *Pool) Reload(accounts []config.AccountConfig, refresh bool, maxAccounts int) {
@@ -120,18 +248,88 @@ func (p *Pool) SnapshotConfigAccounts() []config.AccountConfig {
 	return p.snapshotConfigLocked()
 }
 
+// EnsureToken logs a in when it has no token, or in refresh mode when its
+// token is older than the token TTL. New tokens are stored back into the pool
+// and failed logins are reported against the account's health.
 func (p *Pool) EnsureToken(a *Account) error {
 	if a == nil {
 		return errors.New("nil account")
 	}
-	if strings.TrimSpace(a.Token) != "" && !p.refresh {
+	if strings.TrimSpace(a.Token) != "" && !p.tokenStale(*a) {
 		return nil
 	}
-	if strings.TrimSpace(a.Password) == "" || (strings.TrimSpace(a.Email) == "" && strings.TrimSpace(a.Mobile) == "") {
-		if strings.TrimSpace(a.Token) != "" {
-			return nil
+	if strings.TrimSpace(a.Token) != "" && (strings.TrimSpace(a.Password) == "" || p.AccountID(*a) == "") {
+		return nil
+	}
+	if err := p.refreshToken(a); err != nil {
+		p.ReportFailure(p.AccountID(*a), FailureLogin, err)
+		return err
+	}
+	return nil
+}
+
+// RefreshToken logs the pooled account id in right away, whatever the age of
+// its token.
+func (p *Pool) RefreshToken(id string) error {
+	var target *Account
+	p.mu.Lock()
+	for i := range p.accounts {
+		if p.AccountID(p.accounts[i]) == id {
+			a := p.accounts[i]
+			target = &a
 		}
-		return errors.New("missing credentials")
+	}
+	p.mu.Unlock()
+	if target == nil {
+		return fmt.Errorf("account %q is not in the pool", id)
+	}
+	if strings.TrimSpace(target.Password) == "" {
+		return ErrNoPassword
+	}
+	if err := p.refreshToken(target); err != nil {
+		p.ReportFailure(id, FailureLogin, err)
+		return err
+	}
+	p.ReportSuccess(id)
+	return nil
+}
+
+func (p *Pool) tokenStale(a Account) bool {
+	p.mu.Lock()
+	refresh, ttl := p.refresh, p.tokenTTL
+	p.mu.Unlock()
+	if !refresh {
+		return false
+	}
+	return a.TokenIssuedAt == 0 || time.Since(time.Unix(a.TokenIssuedAt, 0)) > ttl
+}
+
+// refreshToken logs a in and stores the new token in the pool.
+func (p *Pool) refreshToken(a *Account) error {
+	if err := p.login(a); err != nil {
+		return err
+	}
+	a.TokenIssuedAt = time.Now().Unix()
+	id := p.AccountID(*a)
+	p.mu.Lock()
+	stored := false
+	for i := range p.accounts {
+		if p.AccountID(p.accounts[i]) == id {
+			p.accounts[i].Token, p.accounts[i].TokenIssuedAt = a.Token, a.TokenIssuedAt
+			stored = true
+		}
+	}
+	onToken := p.onToken
+	p.mu.Unlock()
+	if stored && onToken != nil {
+		onToken()
+	}
+	return nil
+}
+
+func (p *Pool) login(a *Account) error {
+	if strings.TrimSpace(a.Password) == "" || (strings.TrimSpace(a.Email) == "" && strings.TrimSpace(a.Mobile) == "") {
+		return fmt.Errorf("%w: missing credentials", ErrCredentialsRejected)
 	}
 	payload := map[string]any{"password": a.Password, "device_id": "deepseek_to_api", "os": "android"}
 	if strings.TrimSpace(a.Email) != "" {
@@ -151,6 +349,9 @@ func (p *Pool) EnsureToken(a *Account) error {
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("login: HTTP %d", resp.StatusCode)
+	}
 	var body map[string]any
 	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
 		return err
@@ -160,7 +361,11 @@ func (p *Pool) EnsureToken(a *Account) error {
 	user, _ := biz["user"].(map[string]any)
 	tok, _ := user["token"].(string)
 	if strings.TrimSpace(tok) == "" {
-		return errors.New("missing token")
+		msg, _ := biz["msg"].(string)
+		if msg == "" {
+			msg, _ = data["biz_msg"].(string)
+		}
+		return fmt.Errorf("%w: %s", ErrCredentialsRejected, strings.TrimSpace("missing token "+msg))
 	}
 	a.Token = tok
 	return nil
@@ -168,9 +373,22 @@ func (p *Pool) EnsureToken(a *Account) error {
 
 func (p *Pool) reloadLocked(accounts []config.AccountConfig, refresh bool, maxAccounts int) {
 	p.refresh = refresh
+	prev := make(map[string]Account, len(p.accounts))
+	for _, a := range p.accounts {
+		prev[p.AccountID(a)] = a
+	}
 	p.accounts = make([]Account, 0, len(accounts))
 	for _, a := range accounts {
-		p.accounts = append(p.accounts, Account{Email: a.Email, Mobile: a.Mobile, Password: a.Password, Token: a.Token})
+		ac := Account{Email: a.Email, Mobile: a.Mobile, Password: a.Password, Token: a.Token, Weight: a.Weight, TokenIssuedAt: a.TokenIssuedAt, Disabled: a.Disabled}
+		if old, ok := prev[p.AccountID(ac)]; ok {
+			keepNewerToken(&ac, old)
+			if ac.Token != old.Token || ac.Password != old.Password || ac.Disabled != old.Disabled {
+				// New credentials deserve a fresh start.
+				delete(p.health, p.AccountID(ac))
+				delete(p.tokenChecks, p.AccountID(ac))
+			}
+		}
+		p.accounts = append(p.accounts, ac)
 	}
 	p.maxAccounts = maxAccounts
 	if p.maxAccounts <= 0 || p.maxAccounts > len(p.accounts) {
@@ -189,12 +407,39 @@ func (p *Pool) reloadLocked(accounts []config.AccountConfig, refresh bool, maxAc
 			delete(p.active, id)
 		}
 	}
+	for id := range p.lastUsed {
+		if _, ok := valid[id]; !ok {
+			delete(p.lastUsed, id)
+		}
+	}
+	for id := range p.health {
+		if _, ok := valid[id]; !ok {
+			delete(p.health, id)
+		}
+	}
+	for id := range p.tokenChecks {
+		if _, ok := valid[id]; !ok {
+			delete(p.tokenChecks, id)
+		}
+	}
+	p.dispatchLocked()
 }
 
 func (p *Pool) snapshotConfigLocked() []config.AccountConfig {
 	out := make([]config.AccountConfig, 0, len(p.accounts))
 	for _, a := range p.accounts {
-		out = append(out, config.AccountConfig{Email: a.Email, Mobile: a.Mobile, Password: a.Password, Token: a.Token})
+		out = append(out, config.AccountConfig{Email: a.Email, Mobile: a.Mobile, Password: a.Password, Token: a.Token, Weight: a.Weight, TokenIssuedAt: a.TokenIssuedAt, Disabled: a.Disabled})
 	}
 	return out
 }
+
+// keepNewerToken keeps a token the pool logged in for over an incoming one
+// that is missing or older, so reloads from disk or cloud sync do not throw
+// fresh tokens away.
+func keepNewerToken(ac *Account, old Account) {
+	switch {
+	case old.Token == "" || old.TokenIssuedAt == 0:
+	case ac.Token == "", ac.Token == old.Token, ac.TokenIssuedAt != 0 && ac.TokenIssuedAt < old.TokenIssuedAt:
+		ac.Token, ac.TokenIssuedAt = old.Token, old.TokenIssuedAt
+	}
+}
@@ -1,7 +1,11 @@
 package accounts
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"deepseek2api-go/internal/config"
 )
@@ -79,3 +83,63 @@ func TestReloadAppliesMaxActiveAccountLimit(t *testing.T) {
 		t.Fatalf("expected available=1, got %v", got)
 	}
 }
+
+func TestEnsureTokenStoresLoginInPool(t *testing.T) {
+	logins := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logins++
+		fmt.Fprintf(w, `{"data":{"biz_data":{"user":{"token":"tok-%d"}}}}`, logins)
+	}))
+	defer srv.Close()
+	cases := []struct {
+		name       string
+		refresh    bool
+		ttl        time.Duration
+		wantLogins int
+	}{
+		{"stored token reused", false, time.Hour, 1},
+		{"refresh within ttl", true, time.Hour, 1},
+		{"refresh past ttl", true, 0, 3},
+	}
+	for _, tc := range cases {
+		logins = 0
+		p := NewPool(config.Config{Accounts: []config.AccountConfig{{Email: "a@example.com", Password: "pw"}}, Refresh: tc.refresh}, srv.Client())
+		p.loginURL, p.tokenTTL = srv.URL, tc.ttl
+		refreshed := 0
+		p.OnTokenRefresh(func() { refreshed++ })
+		for i := 0; i < 3; i++ {
+			ac, _ := p.Acquire(nil)
+			if err := p.EnsureToken(ac); err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+			p.Release(ac)
+		}
+		snap := p.SnapshotConfigAccounts()[0]
+		if logins != tc.wantLogins || refreshed != tc.wantLogins {
+			t.Fatalf("%s: logins=%d refreshed=%d, want %d", tc.name, logins, refreshed, tc.wantLogins)
+		}
+		if snap.Token != fmt.Sprintf("tok-%d", logins) || snap.TokenIssuedAt == 0 {
+			t.Fatalf("%s: stored account = %+v", tc.name, snap)
+		}
+	}
+}
+
+func TestReloadKeepsNewerToken(t *testing.T) {
+	p := NewPool(config.Config{Accounts: []config.AccountConfig{{Email: "a@example.com", Token: "fresh", TokenIssuedAt: 200}}}, nil)
+	cases := []struct {
+		incoming config.AccountConfig
+		want     string
+	}{
+		{config.AccountConfig{Email: "a@example.com"}, "fresh"},
+		{config.AccountConfig{Email: "a@example.com", Token: "stale", TokenIssuedAt: 100}, "fresh"},
+		{config.AccountConfig{Email: "a@example.com", Token: "manual"}, "manual"},
+		{config.AccountConfig{Email: "a@example.com", Token: "newer", TokenIssuedAt: 300}, "newer"},
+	}
+	for _, tc := range cases {
+		p.Reload([]config.AccountConfig{{Email: "a@example.com", Token: "fresh", TokenIssuedAt: 200}}, false, 0)
+		p.Reload([]config.AccountConfig{tc.incoming}, false, 0)
+		if got := p.SnapshotConfigAccounts()[0].Token; got != tc.want {
+			t.Fatalf("incoming %+v: token %q, want %q", tc.incoming, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package accounts
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrNoAccounts = errors.New("no enabled accounts in pool")
+
+type waiter struct {
+	exclude map[string]bool
+	// id is set for callers waiting on one particular account.
+	id    string
+	ready chan *Account
+	since time.Time
+}
+
+// waitQueue holds callers waiting for capacity in arrival order, with the
+// wait-time metrics reported by GetStatus. It is guarded by the pool lock.
+type waitQueue struct {
+	waiters  list.List
+	served   int64
+	timeouts int64
+	waitSum  time.Duration
+	waitMax  time.Duration
+}
+
+func (q *waitQueue) record(wait time.Duration, served bool) {
+	if served {
+		q.served++
+	} else {
+		q.timeouts++
+	}
+	q.waitSum += wait
+	if wait > q.waitMax {
+		q.waitMax = wait
+	}
+}
+
+func (q *waitQueue) statusLocked() map[string]any {
+	var avg float64
+	if n := q.served + q.timeouts; n > 0 {
+		avg = float64(q.waitSum.Milliseconds()) / float64(n)
+	}
+	return map[string]any{
+		"depth":       q.waiters.Len(),
+		"served":      q.served,
+		"timeouts":    q.timeouts,
+		"avg_wait_ms": avg,
+		"max_wait_ms": q.waitMax.Milliseconds(),
+	}
+}
+
+// AcquireWait is Acquire that, when every account is at its session limit or
+// cooling down, waits in a FIFO queue until one frees up or ctx is done. Callers that
+// give up leave the queue without holding an account.
+func (p *Pool) AcquireWait(ctx context.Context, exclude map[string]bool) (*Account, error) {
+	return p.acquireWait(ctx, &waiter{exclude: exclude})
+}
+
+// AcquireIDWait is AcquireID that waits like AcquireWait for the account id to
+// free up. Waiting for one account does not hold up the callers queued
+// behind it.
+func (p *Pool) AcquireIDWait(ctx context.Context, id string) (*Account, error) {
+	return p.acquireWait(ctx, &waiter{id: id})
+}
+
+func (p *Pool) acquireWait(ctx context.Context, w *waiter) (*Account, error) {
+	p.mu.Lock()
+	if !p.anyEnabledLocked() || (w.id != "" && !p.enabledLocked(w.id)) {
+		p.mu.Unlock()
+		return nil, ErrNoAccounts
+	}
+	if p.queue.waiters.Len() == 0 || w.id != "" {
+		if ac, ok := p.takeLocked(w); ok {
+			p.mu.Unlock()
+			return ac, nil
+		}
+	}
+	w.ready, w.since = make(chan *Account, 1), time.Now()
+	el := p.queue.waiters.PushBack(w)
+	p.mu.Unlock()
+
+	select {
+	case ac := <-w.ready:
+		return ac, nil
+	case <-ctx.Done():
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case ac := <-w.ready:
+		// Handed an account just as ctx ended: give it to the next waiter.
+		p.releaseLocked(p.AccountID(*ac))
+	default:
+		p.queue.waiters.Remove(el)
+		p.queue.record(time.Since(w.since), false)
+	}
+	return nil, ctx.Err()
+}
+
+func (p *Pool) takeLocked(w *waiter) (*Account, bool) {
+	if w.id != "" {
+		return p.acquireIDLocked(w.id)
+	}
+	return p.pickLocked(w.exclude)
+}
+
+// dispatchLocked hands freed capacity to waiters in arrival order.
+func (p *Pool) dispatchLocked() {
+	for el := p.queue.waiters.Front(); el != nil; {
+		next := el.Next()
+		w := el.Value.(*waiter)
+		ac, ok := p.takeLocked(w)
+		if !ok {
+			if w.id == "" {
+				return
+			}
+			el = next
+			continue
+		}
+		p.queue.waiters.Remove(el)
+		p.queue.record(time.Since(w.since), true)
+		w.ready <- ac
+		el = next
+	}
+}
@@ -0,0 +1,146 @@
+package accounts
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"deepseek2api-go/internal/config"
+)
+
+func newCappedPool(max int, emails ...string) *Pool {
+	cfg := config.Config{MaxSessionsPerAcct: max, DeepSeekHost: "chat.deepseek.com"}
+	for _, e := range emails {
+		cfg.Accounts = append(cfg.Accounts, config.AccountConfig{Email: e, Token: "t"})
+	}
+	return NewPool(cfg, nil)
+}
+
+func TestAcquireRespectsSessionLimit(t *testing.T) {
+	p := newCappedPool(2, "a@example.com")
+	for i := 0; i < 2; i++ {
+		if _, ok := p.Acquire(nil); !ok {
+			t.Fatalf("acquire %d failed under the limit", i)
+		}
+	}
+	if _, ok := p.Acquire(nil); ok {
+		t.Fatal("acquire succeeded over the limit")
+	}
+	if _, ok := p.AcquireID("a@example.com"); ok {
+		t.Fatal("AcquireID succeeded over the limit")
+	}
+	if _, err := newCappedPool(1).AcquireWait(context.Background(), nil); !errors.Is(err, ErrNoAccounts) {
+		t.Fatalf("empty pool: err = %v", err)
+	}
+}
+
+func TestAcquireWaitFIFO(t *testing.T) {
+	p := newCappedPool(1, "a@example.com")
+	held, _ := p.Acquire(nil)
+
+	order := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			ac, err := p.AcquireWait(context.Background(), nil)
+			if err != nil {
+				t.Errorf("waiter %d: %v", i, err)
+				return
+			}
+			order <- i
+			p.Release(ac)
+		}(i)
+		waitDepth(t, p, i+1)
+	}
+	p.Release(held)
+	for want := 0; want < 3; want++ {
+		if got := <-order; got != want {
+			t.Fatalf("served waiter %d, want %d", got, want)
+		}
+	}
+	q := waitForIdle(t, p)
+	if q["served"] != int64(3) || q["timeouts"] != int64(0) {
+		t.Fatalf("queue status = %v", q)
+	}
+}
+
+func TestAcquireWaitTimeoutLeavesNoSession(t *testing.T) {
+	p := newCappedPool(1, "a@example.com")
+	held, _ := p.Acquire(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := p.AcquireWait(ctx, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v", err)
+	}
+	st := p.GetStatus()
+	q := st["queue"].(map[string]any)
+	if st["active_sessions"] != 1 || q["depth"] != 0 || q["timeouts"] != int64(1) {
+		t.Fatalf("status after timeout = %v", st)
+	}
+	if q["max_wait_ms"].(int64) < 20 {
+		t.Fatalf("max_wait_ms = %v", q["max_wait_ms"])
+	}
+
+	p.Release(held)
+	if st := p.GetStatus(); st["active_sessions"] != 0 {
+		t.Fatalf("active_sessions after release = %v", st["active_sessions"])
+	}
+}
+
+func TestAcquireIDWaitDoesNotBlockQueue(t *testing.T) {
+	p := newCappedPool(1, "a@example.com", "b@example.com")
+	a, _ := p.AcquireID("a@example.com")
+	b, _ := p.AcquireID("b@example.com")
+
+	pinned := make(chan *Account, 1)
+	go func() {
+		ac, _ := p.AcquireIDWait(context.Background(), "a@example.com")
+		pinned <- ac
+	}()
+	waitDepth(t, p, 1)
+	queued := make(chan *Account, 1)
+	go func() {
+		ac, _ := p.AcquireWait(context.Background(), nil)
+		queued <- ac
+	}()
+	waitDepth(t, p, 2)
+
+	p.Release(b)
+	if ac := <-queued; ac.Email != "b@example.com" {
+		t.Fatalf("queued caller got %s", ac.Email)
+	}
+	p.Release(a)
+	if ac := <-pinned; ac.Email != "a@example.com" {
+		t.Fatalf("pinned caller got %s", ac.Email)
+	}
+	if _, err := p.AcquireIDWait(context.Background(), "missing@example.com"); !errors.Is(err, ErrNoAccounts) {
+		t.Fatalf("unknown account: err = %v", err)
+	}
+}
+
+func waitDepth(t *testing.T, p *Pool, depth int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for p.GetStatus()["queue"].(map[string]any)["depth"] != depth {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue never reached depth %d", depth)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func waitForIdle(t *testing.T, p *Pool) map[string]any {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		st := p.GetStatus()
+		if st["active_sessions"] == 0 {
+			return st["queue"].(map[string]any)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sessions still active: %v", st)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
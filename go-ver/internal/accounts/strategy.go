@@ -0,0 +1,114 @@
+package accounts
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+const (
+	StrategyRandom      = "random"
+	StrategyLeastActive = "least_active"
+	StrategyRoundRobin  = "round_robin"
+	StrategyWeighted    = "weighted"
+	StrategyLRU         = "lru"
+)
+
+type candidate struct {
+	index    int
+	id       string
+	active   int
+	weight   int
+	lastUsed int64
+}
+
+// Strategy picks one of the candidates and returns its position in cands.
+// It is always called with the pool lock held and at least one candidate.
+type Strategy interface {
+	Pick(cands []candidate) int
+}
+
+func NewStrategy(name string) (Strategy, error) {
+	switch name {
+	case "", StrategyRandom:
+		return randomStrategy{}, nil
+	case StrategyLeastActive:
+		return leastActiveStrategy{}, nil
+	case StrategyRoundRobin:
+		return &roundRobinStrategy{}, nil
+	case StrategyWeighted:
+		return &weightedStrategy{current: map[string]int{}}, nil
+	case StrategyLRU:
+		return lruStrategy{}, nil
+	}
+	return nil, fmt.Errorf("unknown account strategy %q", name)
+}
+
+type randomStrategy struct{}
+
+func (randomStrategy) Pick(cands []candidate) int { return rand.Intn(len(cands)) }
+
+// leastActiveStrategy picks the account with the fewest requests in flight,
+// preferring the least recently used one on ties.
+type leastActiveStrategy struct{}
+
+func (leastActiveStrategy) Pick(cands []candidate) int {
+	best := 0
+	for i, c := range cands[1:] {
+		b := cands[best]
+		if c.active < b.active || (c.active == b.active && c.lastUsed < b.lastUsed) {
+			best = i + 1
+		}
+	}
+	return best
+}
+
+type roundRobinStrategy struct {
+	next int
+}
+
+func (s *roundRobinStrategy) Pick(cands []candidate) int {
+	pick := 0
+	for i, c := range cands {
+		if c.index >= s.next {
+			pick = i
+			break
+		}
+	}
+	s.next = cands[pick].index + 1
+	return pick
+}
+
+// weightedStrategy is smooth weighted round-robin: over any window each
+// account is picked in proportion to its weight, without bursts.
+type weightedStrategy struct {
+	current map[string]int
+}
+
+func (s *weightedStrategy) Pick(cands []candidate) int {
+	total, best := 0, 0
+	for i, c := range cands {
+		w := c.weight
+		if w <= 0 {
+			w = 1
+		}
+		total += w
+		s.current[c.id] += w
+		if s.current[c.id] > s.current[cands[best].id] {
+			best = i
+		}
+	}
+	s.current[cands[best].id] -= total
+	return best
+}
+
+type lruStrategy struct{}
+
+func (lruStrategy) Pick(cands []candidate) int {
+	best := 0
+	for i, c := range cands[1:] {
+		if c.lastUsed < cands[best].lastUsed {
+			best = i + 1
+		}
+	}
+	return best
+}
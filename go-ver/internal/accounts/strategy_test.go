@@ -0,0 +1,144 @@
+package accounts
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"deepseek2api-go/internal/config"
+)
+
+func newStrategyPool(t *testing.T, strategy string, weights ...int) *Pool {
+	t.Helper()
+	cfg := config.Config{AccountStrategy: strategy, DeepSeekHost: "chat.deepseek.com"}
+	for i, w := range weights {
+		cfg.Accounts = append(cfg.Accounts, config.AccountConfig{Email: fmt.Sprintf("%c@example.com", 'a'+i), Token: "t", Weight: w})
+	}
+	p := NewPool(cfg, nil)
+	if got := p.StrategyName(); got != strategy {
+		t.Fatalf("strategy = %q want %q", got, strategy)
+	}
+	return p
+}
+
+// acquireConcurrently runs n goroutines that each acquire once and hold the
+// account until all of them have acquired, and returns the picks per account.
+func acquireConcurrently(p *Pool, n int) map[string]int {
+	var mu sync.Mutex
+	counts := map[string]int{}
+	var acquired, hold sync.WaitGroup
+	acquired.Add(n)
+	hold.Add(1)
+	var done sync.WaitGroup
+	for i := 0; i < n; i++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			ac, _ := p.Acquire(nil)
+			mu.Lock()
+			counts[p.AccountID(*ac)]++
+			mu.Unlock()
+			acquired.Done()
+			hold.Wait()
+			p.Release(ac)
+		}()
+	}
+	acquired.Wait()
+	hold.Done()
+	done.Wait()
+	return counts
+}
+
+func TestStrategyFairnessUnderConcurrency(t *testing.T) {
+	cases := []struct {
+		strategy string
+		weights  []int
+		n        int
+		want     []int
+	}{
+		{StrategyLeastActive, []int{0, 0, 0, 0}, 20, []int{5, 5, 5, 5}},
+		{StrategyRoundRobin, []int{0, 0, 0}, 30, []int{10, 10, 10}},
+		{StrategyWeighted, []int{1, 2, 3}, 60, []int{10, 20, 30}},
+		{StrategyLRU, []int{0, 0, 0, 0, 0}, 25, []int{5, 5, 5, 5, 5}},
+	}
+	for _, tc := range cases {
+		p := newStrategyPool(t, tc.strategy, tc.weights...)
+		counts := acquireConcurrently(p, tc.n)
+		for i, want := range tc.want {
+			id := fmt.Sprintf("%c@example.com", 'a'+i)
+			if counts[id] != want {
+				t.Fatalf("%s: picks = %v, want %d for %s", tc.strategy, counts, want, id)
+			}
+		}
+		if got := p.GetStatus()["active_sessions"]; got != 0 {
+			t.Fatalf("%s: active_sessions = %v after release", tc.strategy, got)
+		}
+	}
+}
+
+func TestLeastActiveSpreadsLongRequests(t *testing.T) {
+	p := newStrategyPool(t, StrategyLeastActive, 0, 0, 0)
+	var held []*Account
+	for i := 0; i < 6; i++ {
+		ac, _ := p.Acquire(nil)
+		held = append(held, ac)
+	}
+	p.Release(held[0])
+	p.Release(held[3])
+	// a@ now has no request in flight, so it must be picked next.
+	ac, _ := p.Acquire(nil)
+	if id := p.AccountID(*ac); id != p.AccountID(*held[0]) {
+		t.Fatalf("picked %s, want the idle account %s", id, p.AccountID(*held[0]))
+	}
+}
+
+func TestStrategiesHonorExclude(t *testing.T) {
+	for _, strategy := range []string{StrategyRandom, StrategyLeastActive, StrategyRoundRobin, StrategyWeighted, StrategyLRU} {
+		p := newStrategyPool(t, strategy, 5, 1, 1)
+		exclude := map[string]bool{"a@example.com": true, "b@example.com": true}
+		for i := 0; i < 10; i++ {
+			ac, ok := p.Acquire(exclude)
+			if !ok || p.AccountID(*ac) != "c@example.com" {
+				t.Fatalf("%s: acquired %v despite exclude", strategy, ac)
+			}
+			p.Release(ac)
+		}
+		all := map[string]bool{"a@example.com": true, "b@example.com": true, "c@example.com": true}
+		if _, ok := p.Acquire(all); !ok {
+			t.Fatalf("%s: excluding every account should fall back to all of them", strategy)
+		}
+	}
+}
+
+func TestSetStrategyHotSwap(t *testing.T) {
+	p := newStrategyPool(t, StrategyRandom, 0, 0)
+	if err := p.SetStrategy("bogus"); err == nil {
+		t.Fatal("unknown strategy accepted")
+	}
+	if got := p.StrategyName(); got != StrategyRandom {
+		t.Fatalf("failed swap changed strategy to %q", got)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if j%50 == 0 {
+					_ = p.SetStrategy([]string{StrategyRoundRobin, StrategyLRU}[i%2])
+				}
+				ac, ok := p.Acquire(nil)
+				if !ok {
+					t.Error("acquire failed")
+					return
+				}
+				p.Release(ac)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := p.GetStatus()["active_sessions"]; got != 0 {
+		t.Fatalf("active_sessions = %v after hot swaps", got)
+	}
+}
@@ -0,0 +1,258 @@
+package accounts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const FailureToken = "token"
+
+// ErrTokenInvalid is reported for tokens DeepSeek no longer accepts.
+var ErrTokenInvalid = errors.New("token rejected")
+
+type tokenCheck struct {
+	at     time.Time
+	result string
+}
+
+// TokenChecker periodically validates the token of every enabled account with
+// a current-user call, and logs accounts in again when their token was
+// rejected or is about to reach the token TTL. Checks are spread over the
+// first half of each interval and at most workers run at once.
+type TokenChecker struct {
+	pool         *Pool
+	interval     time.Duration
+	refreshAhead time.Duration
+	sem          chan struct{}
+
+	mu       sync.Mutex
+	runs     int64
+	lastRun  time.Time
+	valid    int64
+	invalid  int64
+	relogins int64
+	errors   int64
+}
+
+func NewTokenChecker(pool *Pool, interval time.Duration, workers int, refreshAhead time.Duration) *TokenChecker {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &TokenChecker{pool: pool, interval: interval, refreshAhead: refreshAhead, sem: make(chan struct{}, workers)}
+}
+
+func (c *TokenChecker) Enabled() bool { return c.interval > 0 }
+
+func (c *TokenChecker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		c.CheckAll(ctx, c.interval/2)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckAll checks every enabled account once, each after a random delay of
+// up to jitter, and returns when all checks are done.
+func (c *TokenChecker) CheckAll(ctx context.Context, jitter time.Duration) {
+	c.mu.Lock()
+	c.runs++
+	c.lastRun = time.Now()
+	c.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, a := range c.pool.enabledAccounts() {
+		wg.Add(1)
+		go func(a Account) {
+			defer wg.Done()
+			if jitter > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Duration(rand.Int63n(int64(jitter)))):
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case c.sem <- struct{}{}:
+			}
+			defer func() { <-c.sem }()
+			c.check(ctx, a)
+		}(a)
+	}
+	wg.Wait()
+}
+
+func (c *TokenChecker) check(ctx context.Context, a Account) {
+	p := c.pool
+	id := p.AccountID(a)
+	canLogin := strings.TrimSpace(a.Password) != "" && id != ""
+	if canLogin && (a.Token == "" || c.nearlyExpired(a)) {
+		if !c.relogin(id, &a) {
+			return
+		}
+	}
+	err := p.validateToken(ctx, a.Token)
+	if errors.Is(err, ErrTokenInvalid) && canLogin {
+		if !c.relogin(id, &a) {
+			return
+		}
+		err = p.validateToken(ctx, a.Token)
+	}
+	switch {
+	case err == nil:
+		c.count(&c.valid)
+		p.recordTokenCheck(id, "valid")
+		p.clearFailures(id, FailureToken, FailureLogin)
+	case errors.Is(err, ErrTokenInvalid):
+		c.count(&c.invalid)
+		p.recordTokenCheck(id, err.Error())
+		if !canLogin {
+			// Nothing can bring this token back short of a config change.
+			err = fmt.Errorf("%w: %v", ErrCredentialsRejected, err)
+		}
+		p.ReportFailure(id, FailureToken, err)
+	default:
+		// Upstream or network trouble says nothing about the account.
+		c.count(&c.errors)
+		p.recordTokenCheck(id, "error: "+err.Error())
+	}
+}
+
+func (c *TokenChecker) nearlyExpired(a Account) bool {
+	if a.TokenIssuedAt == 0 {
+		return false
+	}
+	p := c.pool
+	p.mu.Lock()
+	ttl := p.tokenTTL
+	p.mu.Unlock()
+	return time.Since(time.Unix(a.TokenIssuedAt, 0)) > ttl-c.refreshAhead
+}
+
+func (c *TokenChecker) relogin(id string, a *Account) bool {
+	c.count(&c.relogins)
+	if err := c.pool.refreshToken(a); err != nil {
+		c.pool.recordTokenCheck(id, "login failed: "+err.Error())
+		c.pool.ReportFailure(id, FailureLogin, err)
+		return false
+	}
+	return true
+}
+
+func (c *TokenChecker) count(n *int64) {
+	c.mu.Lock()
+	*n++
+	c.mu.Unlock()
+}
+
+func (c *TokenChecker) Status() map[string]any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := map[string]any{
+		"enabled":          c.Enabled(),
+		"interval_seconds": int(c.interval.Seconds()),
+		"workers":          cap(c.sem),
+		"runs":             c.runs,
+		"valid":            c.valid,
+		"invalid":          c.invalid,
+		"relogins":         c.relogins,
+		"errors":           c.errors,
+	}
+	if !c.lastRun.IsZero() {
+		out["last_run_at"] = c.lastRun.Unix()
+	}
+	return out
+}
+
+func (p *Pool) enabledAccounts() []Account {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]Account, 0, len(p.accounts))
+	for _, a := range p.accounts {
+		if h := p.health[p.AccountID(a)]; !a.Disabled && (h == nil || !h.disabled) {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// validateToken asks DeepSeek for the current user. It returns ErrTokenInvalid
+// when the token was refused and another error when the call itself failed.
+func (p *Pool) validateToken(ctx context.Context, token string) error {
+	if strings.TrimSpace(token) == "" {
+		return fmt.Errorf("%w: no token", ErrTokenInvalid)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.currentUserURL, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range p.baseHeaders {
+		req.Header.Set(k, v)
+	}
+	req.Header.Del("Accept-Encoding")
+	req.Header.Set("authorization", "Bearer "+token)
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("%w: HTTP %d", ErrTokenInvalid, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("current user: HTTP %d", resp.StatusCode)
+	}
+	var body struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+		Data struct {
+			BizCode int    `json:"biz_code"`
+			BizMsg  string `json:"biz_msg"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return err
+	}
+	if body.Code != 0 {
+		return fmt.Errorf("%w: code %d %s", ErrTokenInvalid, body.Code, body.Msg)
+	}
+	if body.Data.BizCode != 0 {
+		return fmt.Errorf("%w: biz_code %d %s", ErrTokenInvalid, body.Data.BizCode, body.Data.BizMsg)
+	}
+	return nil
+}
+
+func (p *Pool) recordTokenCheck(id, result string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tokenChecks[id] = tokenCheck{at: time.Now(), result: result}
+}
+
+// clearFailures closes id's circuit if its last failure was of one of kinds.
+func (p *Pool) clearFailures(id string, kinds ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h := p.health[id]
+	if h == nil {
+		return
+	}
+	for _, k := range kinds {
+		if h.lastKind == k {
+			delete(p.health, id)
+			p.dispatchLocked()
+			return
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package accounts
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"deepseek2api-go/internal/config"
+)
+
+func TestTokenCheckerCheckAll(t *testing.T) {
+	var mu sync.Mutex
+	logins := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			var body struct{ Email string }
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			mu.Lock()
+			logins[body.Email]++
+			mu.Unlock()
+			_, _ = w.Write([]byte(`{"data":{"biz_data":{"user":{"token":"good-` + body.Email + `"}}}}`))
+		case "/current":
+			tok := strings.TrimPrefix(r.Header.Get("authorization"), "Bearer ")
+			switch {
+			case tok == "flaky":
+				w.WriteHeader(http.StatusBadGateway)
+			case strings.HasPrefix(tok, "good"):
+				_, _ = w.Write([]byte(`{"code":0,"data":{"biz_code":0}}`))
+			default:
+				_, _ = w.Write([]byte(`{"code":40003,"msg":"Authorization Failed (invalid token)"}`))
+			}
+		}
+	}))
+	defer srv.Close()
+
+	old := time.Now().Add(-23*time.Hour - 30*time.Minute).Unix()
+	p := NewPool(config.Config{TokenTTLHours: 24, Accounts: []config.AccountConfig{
+		{Email: "expired", Password: "pw", Token: "dead"},
+		{Email: "aging", Password: "pw", Token: "good-aging", TokenIssuedAt: old},
+		{Email: "fine", Password: "pw", Token: "good-fine", TokenIssuedAt: time.Now().Unix()},
+		{Email: "orphan", Token: "dead"},
+		{Email: "flaky", Token: "flaky"},
+	}}, srv.Client())
+	p.loginURL, p.currentUserURL = srv.URL+"/login", srv.URL+"/current"
+	c := NewTokenChecker(p, time.Minute, 2, time.Hour)
+	c.CheckAll(context.Background(), 5*time.Millisecond)
+
+	cases := []struct {
+		id     string
+		logins int
+		state  string
+		check  string
+	}{
+		{"expired", 1, HealthHealthy, "valid"},
+		{"aging", 1, HealthHealthy, "valid"},
+		{"fine", 0, HealthHealthy, "valid"},
+		{"orphan", 0, HealthDisabled, "token rejected"},
+		{"flaky", 0, HealthHealthy, "error"},
+	}
+	entries := map[string]map[string]any{}
+	for _, e := range p.GetStatus()["accounts"].([]map[string]any) {
+		entries[e["id"].(string)] = e
+	}
+	for _, tc := range cases {
+		e := entries[tc.id]
+		if logins[tc.id] != tc.logins || e["state"] != tc.state || !strings.HasPrefix(e["token_check"].(string), tc.check) {
+			t.Fatalf("%s: logins=%d entry=%v", tc.id, logins[tc.id], e)
+		}
+	}
+	if tok := p.SnapshotConfigAccounts()[0].Token; tok != "good-expired" {
+		t.Fatalf("relogged token not stored: %q", tok)
+	}
+	st := c.Status()
+	if st["valid"] != int64(3) || st["invalid"] != int64(1) || st["errors"] != int64(1) || st["relogins"] != int64(2) {
+		t.Fatalf("status = %v", st)
+	}
+}
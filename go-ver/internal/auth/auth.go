@@ -2,9 +2,11 @@ package auth
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"deepseek2api-go/internal/accounts"
 	"deepseek2api-go/internal/config"
@@ -20,6 +22,7 @@ type AuthContext struct {
 	DeepSeekToken  string
 	Account        *accounts.Account
 	FailedAccounts map[string]bool
+	Sessions       []string
 	Released       bool
 }
 
@@ -36,7 +39,7 @@ func WithAuthContext(r *http.Request, ac *AuthContext) *http.Request {
 	return r.WithContext(ctx)
 }
 
-func DetermineModeAndToken(r *http.Request, cfg config.Config, pool *accounts.Pool) (*AuthContext, int, string, error) {
+func CallerKey(r *http.Request) string {
 	callerKey := strings.TrimSpace(r.Header.Get("X-OA-Key"))
 	if callerKey == "" {
 		auth := strings.TrimSpace(r.Header.Get("Authorization"))
@@ -44,6 +47,27 @@ func DetermineModeAndToken(r *http.Request, cfg config.Config, pool *accounts.Po
 			callerKey = strings.TrimSpace(auth[7:])
 		}
 	}
+	return callerKey
+}
+
+// IsAdmin reports whether r carries the admin key, in X-Admin-Key or as a
+// bearer token. It is always false when no admin key is configured.
+func IsAdmin(r *http.Request, cfg config.Config) bool {
+	if cfg.AdminKey == "" {
+		return false
+	}
+	key := strings.TrimSpace(r.Header.Get("X-Admin-Key"))
+	if key == "" {
+		auth := strings.TrimSpace(r.Header.Get("Authorization"))
+		if strings.HasPrefix(strings.ToLower(auth), "bearer ") {
+			key = strings.TrimSpace(auth[7:])
+		}
+	}
+	return subtle.ConstantTimeCompare([]byte(key), []byte(cfg.AdminKey)) == 1
+}
+
+func DetermineModeAndToken(r *http.Request, cfg config.Config, pool *accounts.Pool) (*AuthContext, int, string, error) {
+	callerKey := CallerKey(r)
 	if callerKey == "" {
 		return nil, http.StatusUnauthorized, "Unauthorized: missing X-OA-Key or Authorization Bearer header.", errors.New("missing auth")
 	}
@@ -60,9 +84,12 @@ func DetermineModeAndToken(r *http.Request, cfg config.Config, pool *accounts.Po
 		ac.DeepSeekToken = callerKey
 		return ac, 0, "", nil
 	}
-	acc, ok := pool.Acquire(nil)
-	if !ok || acc == nil {
-		return nil, http.StatusTooManyRequests, "No accounts available in pool.", errors.New("no accounts")
+	acc, err := acquireWait(r.Context(), cfg, pool, nil)
+	if errors.Is(err, accounts.ErrNoAccounts) {
+		return nil, http.StatusTooManyRequests, "No accounts available in pool.", err
+	}
+	if err != nil {
+		return nil, http.StatusTooManyRequests, "Timed out waiting for an available account.", err
 	}
 	if err := pool.EnsureToken(acc); err != nil {
 		pool.Release(acc)
@@ -93,7 +120,14 @@ func ReleaseAccountIfNeeded(ac *AuthContext, pool *accounts.Pool) {
 	ac.Account = nil
 }
 
-func SwitchAccount(ac *AuthContext, pool *accounts.Pool) bool {
+// acquireWait queues for an account for at most the configured account wait.
+func acquireWait(ctx context.Context, cfg config.Config, pool *accounts.Pool, exclude map[string]bool) (*accounts.Account, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.AccountWaitSeconds)*time.Second)
+	defer cancel()
+	return pool.AcquireWait(ctx, exclude)
+}
+
+func SwitchAccount(ctx context.Context, cfg config.Config, ac *AuthContext, pool *accounts.Pool) bool {
 	if ac == nil || !ac.UseConfigToken {
 		return false
 	}
@@ -101,13 +135,14 @@ func SwitchAccount(ac *AuthContext, pool *accounts.Pool) bool {
 		ac.FailedAccounts[pool.AccountID(*ac.Account)] = true
 		pool.Release(ac.Account)
 	}
-	next, ok := pool.Acquire(ac.FailedAccounts)
-	if !ok || next == nil {
+	next, err := acquireWait(ctx, cfg, pool, ac.FailedAccounts)
+	if err != nil {
 		ac.Account = nil
 		ac.DeepSeekToken = ""
 		return false
 	}
 	if err := pool.EnsureToken(next); err != nil {
+		pool.Release(next)
 		ac.Account = nil
 		ac.DeepSeekToken = ""
 		return false
@@ -116,3 +151,74 @@ func SwitchAccount(ac *AuthContext, pool *accounts.Pool) bool {
 	ac.DeepSeekToken = next.Token
 	return true
 }
+
+// ReportFailure records a failure of kind against the request's pooled
+// account so later requests avoid it while it cools down. Failures caused by
+// ctx ending say nothing about the account and are not recorded.
+func ReportFailure(ctx context.Context, ac *AuthContext, pool *accounts.Pool, kind string, err error) {
+	if ac == nil || !ac.UseConfigToken || ac.Account == nil || ctx.Err() != nil {
+		return
+	}
+	if err == nil {
+		err = errors.New(kind + " failed")
+	}
+	pool.ReportFailure(pool.AccountID(*ac.Account), kind, err)
+}
+
+func ReportSuccess(ac *AuthContext, pool *accounts.Pool) {
+	if ac == nil || !ac.UseConfigToken || ac.Account == nil {
+		return
+	}
+	pool.ReportSuccess(pool.AccountID(*ac.Account))
+}
+
+func PinAccount(ctx context.Context, cfg config.Config, ac *AuthContext, pool *accounts.Pool, id string) bool {
+	if ac == nil || !ac.UseConfigToken {
+		return ac != nil && id == ""
+	}
+	if ac.Account != nil && pool.AccountID(*ac.Account) == id {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.AccountWaitSeconds)*time.Second)
+	acc, err := pool.AcquireIDWait(ctx, id)
+	cancel()
+	if err != nil {
+		return false
+	}
+	if err := pool.EnsureToken(acc); err != nil {
+		pool.Release(acc)
+		return false
+	}
+	pool.Release(ac.Account)
+	ac.Account = acc
+	ac.DeepSeekToken = strings.TrimSpace(acc.Token)
+	return true
+}
+
+func AccountID(ac *AuthContext, pool *accounts.Pool) string {
+	if ac == nil || ac.Account == nil {
+		return ""
+	}
+	return pool.AccountID(*ac.Account)
+}
+
+func AcquireExtra(ctx context.Context, cfg config.Config, ac *AuthContext, pool *accounts.Pool, exclude map[string]bool) (*AuthContext, bool) {
+	if ac == nil {
+		return nil, false
+	}
+	extra := &AuthContext{UseConfigToken: ac.UseConfigToken, CallerKey: ac.CallerKey, DeepSeekToken: ac.DeepSeekToken, FailedAccounts: map[string]bool{}}
+	if !ac.UseConfigToken {
+		return extra, true
+	}
+	acc, err := acquireWait(ctx, cfg, pool, exclude)
+	if err != nil {
+		return nil, false
+	}
+	if err := pool.EnsureToken(acc); err != nil {
+		pool.Release(acc)
+		return nil, false
+	}
+	extra.Account = acc
+	extra.DeepSeekToken = strings.TrimSpace(acc.Token)
+	return extra, true
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"deepseek2api-go/internal/accounts"
+	"deepseek2api-go/internal/config"
+)
+
+func TestAcquireExtraQueuesForCapacity(t *testing.T) {
+	cfg := config.Config{
+		Accounts: []config.AccountConfig{
+			{Email: "a@example.com", Token: "t-a"},
+			{Email: "b@example.com", Token: "t-b"},
+		},
+		MaxSessionsPerAcct: 1,
+		AccountWaitSeconds: 5,
+		DeepSeekHost:       "chat.deepseek.com",
+	}
+	pool := accounts.NewPool(cfg, nil)
+	first, _ := pool.Acquire(nil)
+	other, _ := pool.Acquire(nil)
+	ac := &AuthContext{UseConfigToken: true, Account: first, DeepSeekToken: first.Token, FailedAccounts: map[string]bool{}}
+	used := map[string]bool{pool.AccountID(*first): true}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, ok := AcquireExtra(ctx, cfg, ac, pool, used); ok {
+		t.Fatal("extra account acquired while every account is at its limit")
+	}
+
+	got := make(chan *AuthContext, 1)
+	go func() {
+		extra, _ := AcquireExtra(context.Background(), cfg, ac, pool, used)
+		got <- extra
+	}()
+	deadline := time.Now().Add(time.Second)
+	for pool.GetStatus()["queue"].(map[string]any)["depth"] != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("AcquireExtra did not queue")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	pool.Release(other)
+	extra := <-got
+	if extra == nil || AccountID(extra, pool) != pool.AccountID(*other) || extra.DeepSeekToken != other.Token {
+		t.Fatalf("extra = %+v, want the released account", extra)
+	}
+	q := pool.GetStatus()["queue"].(map[string]any)
+	if q["served"] != int64(1) || q["timeouts"] != int64(1) {
+		t.Fatalf("queue status = %v", q)
+	}
+}
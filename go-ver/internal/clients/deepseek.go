@@ -20,13 +20,15 @@ import (
 type DeepSeekClient struct {
 	httpClient  *http.Client
 	urlSession  string
+	urlDelete   string
 	urlPow      string
 	urlComplete string
 	debug       bool
 }
 
 func NewDeepSeekClient(httpClient *http.Client, urlSession, urlPow, urlComplete string) *DeepSeekClient {
-	return &DeepSeekClient{httpClient: httpClient, urlSession: urlSession, urlPow: urlPow, urlComplete: urlComplete, debug: os.Getenv("DEBUG_DS") == "1"}
+	urlDelete := strings.TrimSuffix(urlSession, "/create") + "/delete"
+	return &DeepSeekClient{httpClient: httpClient, urlSession: urlSession, urlDelete: urlDelete, urlPow: urlPow, urlComplete: urlComplete, debug: os.Getenv("DEBUG_DS") == "1"}
 }
 
 func (c *DeepSeekClient) URLCompletion() string { return c.urlComplete }
@@ -63,77 +65,111 @@ func (c *DeepSeekClient) CreateSession(ctx context.Context, headers map[string]s
 	return "", errors.New("failed create session")
 }
 
+func (c *DeepSeekClient) DeleteSession(ctx context.Context, headers map[string]string, sessionID string) error {
+	b, _ := json.Marshal(map[string]any{"chat_session_id": sessionID})
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, c.urlDelete, bytes.NewReader(b))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Del("Accept-Encoding")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var body map[string]any
+	_ = json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("delete session status=%d", resp.StatusCode)
+	}
+	if code, ok := body["code"].(float64); !ok || int(code) != 0 {
+		return fmt.Errorf("delete session code=%v msg=%v", body["code"], body["msg"])
+	}
+	return nil
+}
+
 func (c *DeepSeekClient) GetPoW(ctx context.Context, headers map[string]string, solver pow.Solver, cache *pow.Cache, maxAttempts int) (string, error) {
 	for i := 0; i < maxAttempts; i++ {
-		b, _ := json.Marshal(map[string]any{"target_path": "/api/v0/chat/completion"})
-		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, c.urlPow, bytes.NewReader(b))
-		for k, v := range headers {
-			req.Header.Set(k, v)
+		if enc, _, err := c.PoWAnswer(ctx, headers, solver, cache); err == nil {
+			return enc, nil
 		}
-		resp, err := c.httpClient.Do(req)
-		if err != nil {
-			time.Sleep(time.Second)
-			continue
-		}
-		var body map[string]any
-		_ = json.NewDecoder(resp.Body).Decode(&body)
-		resp.Body.Close()
-		if resp.StatusCode == 200 {
-			if code, ok := body["code"].(float64); ok && int(code) == 0 {
-				data, _ := body["data"].(map[string]any)
-				biz, _ := data["biz_data"].(map[string]any)
-				challenge, _ := biz["challenge"].(map[string]any)
-				alg, _ := challenge["algorithm"].(string)
-				chg, _ := challenge["challenge"].(string)
-				salt, _ := challenge["salt"].(string)
-				sig, _ := challenge["signature"].(string)
-				targetPath, _ := challenge["target_path"].(string)
-				difficulty := int(getFloat(challenge["difficulty"], 144000))
-				expireAt := int64(getFloat(challenge["expire_at"], float64(time.Now().Unix()+60)))
-				if c.debug {
-					if chb, err := json.Marshal(challenge); err == nil {
-						log.Printf("[DEBUG_DS] pow challenge raw=%s", string(chb))
-					}
-					log.Printf("[DEBUG_DS] pow challenge alg=%q difficulty=%d expire_at=%d target_path=%q", alg, difficulty, expireAt, targetPath)
-				}
-				key := pow.HashKey(alg, chg, salt, sig, targetPath)
-				if v, ok := cache.Get(key); ok {
-					return v, nil
-				}
-				ans, ok := solver.Solve(alg, chg, salt, difficulty, expireAt, sig, targetPath)
-				if !ok {
-					time.Sleep(time.Second)
-					continue
-				}
-				pd := struct {
-					Algorithm  string `json:"algorithm"`
-					Challenge  string `json:"challenge"`
-					Salt       string `json:"salt"`
-					Answer     int64  `json:"answer"`
-					Signature  string `json:"signature"`
-					TargetPath string `json:"target_path"`
-				}{
-					Algorithm:  alg,
-					Challenge:  chg,
-					Salt:       salt,
-					Answer:     ans,
-					Signature:  sig,
-					TargetPath: targetPath,
-				}
-				pb, _ := json.Marshal(pd)
-				enc := base64.StdEncoding.EncodeToString(pb)
-				if c.debug {
-					log.Printf("[DEBUG_DS] pow response payload=%s", string(pb))
-				}
-				cache.Set(key, enc, expireAt)
-				return enc, nil
-			}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
 		}
 		time.Sleep(time.Second)
 	}
 	return "", errors.New("failed get pow")
 }
 
+// PoWAnswer fetches one completion challenge and solves it, returning the
+// encoded x-ds-pow-response value and the challenge's expire_at.
+func (c *DeepSeekClient) PoWAnswer(ctx context.Context, headers map[string]string, solver pow.Solver, cache *pow.Cache) (string, int64, error) {
+	b, _ := json.Marshal(map[string]any{"target_path": "/api/v0/chat/completion"})
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, c.urlPow, bytes.NewReader(b))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	var body map[string]any
+	_ = json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", 0, fmt.Errorf("pow challenge status=%d", resp.StatusCode)
+	}
+	if code, ok := body["code"].(float64); !ok || int(code) != 0 {
+		return "", 0, fmt.Errorf("pow challenge code=%v", body["code"])
+	}
+	data, _ := body["data"].(map[string]any)
+	biz, _ := data["biz_data"].(map[string]any)
+	challenge, _ := biz["challenge"].(map[string]any)
+	alg, _ := challenge["algorithm"].(string)
+	chg, _ := challenge["challenge"].(string)
+	salt, _ := challenge["salt"].(string)
+	sig, _ := challenge["signature"].(string)
+	targetPath, _ := challenge["target_path"].(string)
+	difficulty := int(getFloat(challenge["difficulty"], 144000))
+	expireAt := int64(getFloat(challenge["expire_at"], float64(time.Now().Unix()+60)))
+	if c.debug {
+		if chb, err := json.Marshal(challenge); err == nil {
+			log.Printf("[DEBUG_DS] pow challenge raw=%s", string(chb))
+		}
+		log.Printf("[DEBUG_DS] pow challenge alg=%q difficulty=%d expire_at=%d target_path=%q", alg, difficulty, expireAt, targetPath)
+	}
+	key := pow.HashKey(alg, chg, salt, sig, targetPath)
+	if v, ok := cache.Get(key); ok {
+		return v, expireAt, nil
+	}
+	ans, ok := solver.Solve(ctx, alg, chg, salt, difficulty, expireAt, sig, targetPath)
+	if !ok {
+		return "", 0, errors.New("pow solve failed")
+	}
+	pd := struct {
+		Algorithm  string `json:"algorithm"`
+		Challenge  string `json:"challenge"`
+		Salt       string `json:"salt"`
+		Answer     int64  `json:"answer"`
+		Signature  string `json:"signature"`
+		TargetPath string `json:"target_path"`
+	}{
+		Algorithm:  alg,
+		Challenge:  chg,
+		Salt:       salt,
+		Answer:     ans,
+		Signature:  sig,
+		TargetPath: targetPath,
+	}
+	pb, _ := json.Marshal(pd)
+	enc := base64.StdEncoding.EncodeToString(pb)
+	if c.debug {
+		log.Printf("[DEBUG_DS] pow response payload=%s", string(pb))
+	}
+	cache.Set(key, enc, expireAt)
+	return enc, expireAt, nil
+}
+
 func (c *DeepSeekClient) CompletionStreamRequest(ctx context.Context, headers map[string]string, payload map[string]any) (*http.Response, error) {
 	streamPayload := map[string]any{}
 	for k, v := range payload {
@@ -22,6 +22,10 @@ type SyncConfigPayload struct {
 	Refresh            bool              `json:"refresh"`
 	MaxActiveAccounts  int               `json:"max_active_accounts"`
 	ClaudeModelMapping map[string]string `json:"claude_model_mapping"`
+	AccountStrategy    string            `json:"account_strategy,omitempty"`
+	// Keys is nil when the payload has no keys field, as from older nodes,
+	// and points at an empty list once every key was deleted.
+	Keys *[]string `json:"keys"`
 }
 
 type SyncManager struct {
@@ -33,10 +37,19 @@ type SyncManager struct {
 
 	version int64
 	cursor  int64
+	kick    chan struct{}
 }
 
 func NewSyncManager(st *state.AppState, client *Client, cfg config.CloudSyncConfig) *SyncManager {
-	return &SyncManager{st: st, client: client, cfg: cfg}
+	return &SyncManager{st: st, client: client, cfg: cfg, kick: make(chan struct{}, 1)}
+}
+
+// Notify asks Run to sync now instead of waiting for the next interval.
+func (m *SyncManager) Notify() {
+	select {
+	case m.kick <- struct{}{}:
+	default:
+	}
 }
 
 func (m *SyncManager) InitialSync(ctx context.Context) error {
@@ -71,11 +84,12 @@ func (m *SyncManager) Run(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			if err := m.SyncOnce(ctx); err != nil {
-				m.st.MarkSyncError(err.Error())
-			} else {
-				m.st.MarkSyncSuccess(m.getVersion(), m.getCursor())
-			}
+		case <-m.kick:
+		}
+		if err := m.SyncOnce(ctx); err != nil {
+			m.st.MarkSyncError(err.Error())
+		} else {
+			m.st.MarkSyncSuccess(m.getVersion(), m.getCursor())
 		}
 	}
 }
@@ -130,13 +144,16 @@ func (m *SyncManager) pullAndApply(ctx context.Context) error {
 
 func (m *SyncManager) pushLocalSnapshot(ctx context.Context) error {
 	cfg := m.st.GetConfig()
-	accounts := m.st.Pool.SnapshotConfigAccounts()
+	accounts := m.st.ConfiguredAccounts()
 
 	accountsMeta := map[string]any{"accounts": accounts}
+	keys := append([]string{}, cfg.Keys...)
 	configMeta := SyncConfigPayload{
 		Refresh:            cfg.Refresh,
 		MaxActiveAccounts:  cfg.MaxActiveAccounts,
 		ClaudeModelMapping: cfg.ClaudeModelMapping,
+		AccountStrategy:    cfg.AccountStrategy,
+		Keys:               &keys,
 	}
 	if err := m.upsertWithConflictRetry(ctx, accountsPath, accountsMeta); err != nil {
 		return err
@@ -201,12 +218,23 @@ func (m *SyncManager) applyItems(items []SyncItem) error {
 	}
 	if remoteCfg != nil {
 		m.st.UpdateSyncRuntime(remoteCfg.Refresh, remoteCfg.MaxActiveAccounts, remoteCfg.ClaudeModelMapping)
+		if remoteCfg.AccountStrategy != "" {
+			if err := m.st.SetAccountStrategy(remoteCfg.AccountStrategy); err != nil {
+				m.st.Logger.Warnf("cloudsync: %v", err)
+			}
+		}
+		if remoteCfg.Keys != nil {
+			m.st.SetKeys(*remoteCfg.Keys)
+		}
+	}
+	if remoteAccounts != nil {
+		m.st.SetAccounts(remoteAccounts)
 	}
 	if remoteCfg != nil || remoteAccounts != nil {
 		cfg := m.st.GetConfig()
 		accountsToApply := remoteAccounts
 		if accountsToApply == nil {
-			accountsToApply = m.st.Pool.SnapshotConfigAccounts()
+			accountsToApply = m.st.ConfiguredAccounts()
 		}
 		m.st.Pool.Reload(accountsToApply, cfg.Refresh, cfg.MaxActiveAccounts)
 	}
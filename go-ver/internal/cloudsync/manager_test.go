@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync/atomic"
 	"testing"
 
@@ -179,3 +180,72 @@ func TestApplyItemsUpdatesRuntimeAndPool(t *testing.T) {
 		t.Fatalf("expected manager version=11, got %d", v)
 	}
 }
+
+func TestApplyItemsKeys(t *testing.T) {
+	cfg := config.Config{Keys: []string{"k1"}, DeepSeekHost: "chat.deepseek.com"}
+	st := state.NewAppState(cfg, logging.New("error"), &http.Client{}, accounts.NewPool(cfg, &http.Client{}), nil, nil, nil)
+	m := NewSyncManager(st, nil, config.CloudSyncConfig{Limit: 100, IntervalSeconds: 1})
+
+	apply := func(meta map[string]any) []string {
+		t.Helper()
+		if err := m.applyItems([]SyncItem{{Path: configPath, Version: m.getVersion() + 1, Metadata: meta}}); err != nil {
+			t.Fatalf("applyItems error: %v", err)
+		}
+		return st.GetConfig().Keys
+	}
+	if keys := apply(map[string]any{"refresh": false}); len(keys) != 1 {
+		t.Fatalf("payload without keys changed them: %v", keys)
+	}
+	if keys := apply(map[string]any{"keys": []any{}}); len(keys) != 0 {
+		t.Fatalf("deleting every key did not apply: %v", keys)
+	}
+
+	b, _ := json.Marshal(SyncConfigPayload{Keys: &[]string{}})
+	if !strings.Contains(string(b), `"keys":[]`) {
+		t.Fatalf("empty key list not sent: %s", b)
+	}
+}
+
+func TestPushSendsAccountsOutsideActivePool(t *testing.T) {
+	var pushed []config.AccountConfig
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Path     string `json:"path"`
+			Metadata struct {
+				Accounts []config.AccountConfig `json:"accounts"`
+			} `json:"metadata"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.Path == accountsPath {
+			pushed = req.Metadata.Accounts
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"path": req.Path, "version": 1})
+	}))
+	defer ts.Close()
+
+	cfg := config.Config{
+		Accounts: []config.AccountConfig{
+			{Email: "a@example.com", Password: "p"},
+			{Email: "b@example.com", Token: "t-b"},
+			{Email: "c@example.com", Token: "t-c"},
+		},
+		MaxActiveAccounts: 1,
+		DeepSeekHost:      "chat.deepseek.com",
+	}
+	pool := accounts.NewPool(cfg, ts.Client())
+	st := state.NewAppState(cfg, logging.New("error"), ts.Client(), pool, nil, nil, nil)
+	// The active subset is a@, which the pool logged in after the config was loaded.
+	pool.Reload([]config.AccountConfig{{Email: "a@example.com", Password: "p", Token: "t-a", TokenIssuedAt: 100}}, false, 1)
+	m := NewSyncManager(st, NewClient(ts.Client(), ts.URL, "", "u1"), config.CloudSyncConfig{Limit: 100, IntervalSeconds: 1})
+
+	if err := m.pushLocalSnapshot(context.Background()); err != nil {
+		t.Fatalf("pushLocalSnapshot error: %v", err)
+	}
+	if len(pushed) != 3 {
+		t.Fatalf("expected all 3 configured accounts pushed, got %+v", pushed)
+	}
+	if pushed[0].Token != "t-a" {
+		t.Fatalf("expected the pool's token for the active account, got %+v", pushed[0])
+	}
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"encoding/json"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
 )
@@ -12,6 +13,20 @@ type AccountConfig struct {
 	Mobile   string `json:"mobile"`
 	Password string `json:"password"`
 	Token    string `json:"token"`
+	Weight   int    `json:"weight,omitempty"`
+	// TokenIssuedAt is the unix time the token was obtained by logging in,
+	// or 0 when it was configured by hand.
+	TokenIssuedAt int64 `json:"token_issued_at,omitempty"`
+	// Disabled keeps the account out of rotation without removing it.
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// ID is the email, or the mobile number for accounts without one.
+func (a AccountConfig) ID() string {
+	if strings.TrimSpace(a.Email) != "" {
+		return strings.TrimSpace(a.Email)
+	}
+	return strings.TrimSpace(a.Mobile)
 }
 
 type CloudSyncConfig struct {
@@ -24,18 +39,68 @@ type CloudSyncConfig struct {
 	Limit           int    `json:"limit"`
 }
 
+type SessionCleanupConfig struct {
+	Mode         string `json:"mode"`
+	DelayMinutes int    `json:"delay_minutes"`
+	KeepLast     int    `json:"keep_last"`
+	Retries      int    `json:"retries"`
+}
+
+type SessionPoolConfig struct {
+	Size       int `json:"size"`
+	TTLSeconds int `json:"ttl_seconds"`
+}
+
+type PowPrefetchConfig struct {
+	Depth   int `json:"depth"`
+	Workers int `json:"workers"`
+}
+
+type ResponsesConfig struct {
+	MaxEntries int `json:"max_entries"`
+	TTLHours   int `json:"ttl_hours"`
+}
+
+type AccountHealthConfig struct {
+	CooldownSeconds    int `json:"cooldown_seconds"`
+	MaxCooldownSeconds int `json:"max_cooldown_seconds"`
+	ProbeSeconds       int `json:"probe_seconds"`
+}
+
+type TokenCheckConfig struct {
+	IntervalSeconds     int `json:"interval_seconds"`
+	Workers             int `json:"workers"`
+	RefreshAheadMinutes int `json:"refresh_ahead_minutes"`
+}
+
 type Config struct {
-	Keys               []string          `json:"keys"`
-	Accounts           []AccountConfig   `json:"accounts"`
-	Refresh            bool              `json:"refresh"`
-	PowSolver          string            `json:"pow_solver"`
-	MaxActiveAccounts  int               `json:"max_active_accounts"`
-	ClaudeModelMapping map[string]string `json:"claude_model_mapping"`
-	CloudSync          CloudSyncConfig   `json:"cloud_sync"`
-	Port               string            `json:"-"`
-	RequestTimeoutSec  int               `json:"-"`
-	LogLevel           string            `json:"-"`
-	DeepSeekHost       string            `json:"-"`
+	Keys               []string             `json:"keys"`
+	AdminKey           string               `json:"admin_key"`
+	Accounts           []AccountConfig      `json:"accounts"`
+	Refresh            bool                 `json:"refresh"`
+	TokenTTLHours      int                  `json:"token_ttl_hours"`
+	TokenCheck         TokenCheckConfig     `json:"token_check"`
+	PowSolver          string               `json:"pow_solver"`
+	MaxActiveAccounts  int                  `json:"max_active_accounts"`
+	AccountStrategy    string               `json:"account_strategy"`
+	MaxSessionsPerAcct int                  `json:"max_sessions_per_account"`
+	AccountWaitSeconds int                  `json:"account_wait_seconds"`
+	AccountHealth      AccountHealthConfig  `json:"account_health"`
+	ClaudeModelMapping map[string]string    `json:"claude_model_mapping"`
+	ToolPersona        string               `json:"tool_persona"`
+	ToolRepairRetries  int                  `json:"tool_repair_retries"`
+	MaxChoices         int                  `json:"max_choices"`
+	ChoicesMinSuccess  int                  `json:"choices_min_success"`
+	SessionCleanup     SessionCleanupConfig `json:"session_cleanup"`
+	SessionPool        SessionPoolConfig    `json:"session_pool"`
+	PowPrefetch        PowPrefetchConfig    `json:"pow_prefetch"`
+	Responses          ResponsesConfig      `json:"responses"`
+	CloudSync          CloudSyncConfig      `json:"cloud_sync"`
+	Path               string               `json:"-"`
+	Port               string               `json:"-"`
+	RequestTimeoutSec  int                  `json:"-"`
+	LogLevel           string               `json:"-"`
+	DeepSeekHost       string               `json:"-"`
 }
 
 func Load() Config {
@@ -55,6 +120,7 @@ func Load() Config {
 			b, err := os.ReadFile(p)
 			if err == nil {
 				_ = json.Unmarshal(b, &cfg)
+				cfg.Path = p
 				break
 			}
 		}
@@ -81,6 +147,189 @@ func Load() Config {
 		}
 	}
 
+	if v := strings.TrimSpace(os.Getenv("ADMIN_KEY")); v != "" {
+		cfg.AdminKey = v
+	}
+
+	if v := strings.TrimSpace(os.Getenv("TOKEN_TTL_HOURS")); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			cfg.TokenTTLHours = i
+		}
+	}
+	if cfg.TokenTTLHours <= 0 {
+		cfg.TokenTTLHours = 24
+	}
+	if v := strings.TrimSpace(os.Getenv("TOKEN_CHECK_INTERVAL_SECONDS")); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			cfg.TokenCheck.IntervalSeconds = i
+		}
+	}
+	if cfg.TokenCheck.IntervalSeconds == 0 {
+		cfg.TokenCheck.IntervalSeconds = 900
+	}
+	if cfg.TokenCheck.IntervalSeconds < 0 {
+		cfg.TokenCheck.IntervalSeconds = 0
+	}
+	if v := strings.TrimSpace(os.Getenv("TOKEN_CHECK_WORKERS")); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			cfg.TokenCheck.Workers = i
+		}
+	}
+	if cfg.TokenCheck.Workers <= 0 {
+		cfg.TokenCheck.Workers = 2
+	}
+	if v := strings.TrimSpace(os.Getenv("TOKEN_REFRESH_AHEAD_MINUTES")); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			cfg.TokenCheck.RefreshAheadMinutes = i
+		}
+	}
+	if cfg.TokenCheck.RefreshAheadMinutes <= 0 {
+		cfg.TokenCheck.RefreshAheadMinutes = 60
+	}
+
+	if v := strings.TrimSpace(os.Getenv("ACCOUNT_STRATEGY")); v != "" {
+		cfg.AccountStrategy = v
+	}
+	if cfg.AccountStrategy == "" {
+		cfg.AccountStrategy = "random"
+	}
+	if v := strings.TrimSpace(os.Getenv("MAX_SESSIONS_PER_ACCOUNT")); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			cfg.MaxSessionsPerAcct = i
+		}
+	}
+	if cfg.MaxSessionsPerAcct < 0 {
+		cfg.MaxSessionsPerAcct = 0
+	}
+	if v := strings.TrimSpace(os.Getenv("ACCOUNT_WAIT_SECONDS")); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			cfg.AccountWaitSeconds = i
+		}
+	}
+	if cfg.AccountWaitSeconds <= 0 {
+		cfg.AccountWaitSeconds = 30
+	}
+	if v := strings.TrimSpace(os.Getenv("ACCOUNT_COOLDOWN_SECONDS")); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			cfg.AccountHealth.CooldownSeconds = i
+		}
+	}
+	if cfg.AccountHealth.CooldownSeconds <= 0 {
+		cfg.AccountHealth.CooldownSeconds = 30
+	}
+	if v := strings.TrimSpace(os.Getenv("ACCOUNT_COOLDOWN_MAX_SECONDS")); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			cfg.AccountHealth.MaxCooldownSeconds = i
+		}
+	}
+	if cfg.AccountHealth.MaxCooldownSeconds < cfg.AccountHealth.CooldownSeconds {
+		cfg.AccountHealth.MaxCooldownSeconds = max(600, cfg.AccountHealth.CooldownSeconds)
+	}
+	if v := strings.TrimSpace(os.Getenv("ACCOUNT_PROBE_SECONDS")); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			cfg.AccountHealth.ProbeSeconds = i
+		}
+	}
+	if cfg.AccountHealth.ProbeSeconds <= 0 {
+		cfg.AccountHealth.ProbeSeconds = 300
+	}
+
+	if v := strings.TrimSpace(os.Getenv("TOOL_REPAIR_RETRIES")); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			cfg.ToolRepairRetries = i
+		}
+	}
+	if cfg.ToolRepairRetries == 0 {
+		cfg.ToolRepairRetries = 1
+	}
+	if cfg.ToolRepairRetries < 0 {
+		cfg.ToolRepairRetries = 0
+	}
+
+	if v := strings.TrimSpace(os.Getenv("MAX_CHOICES")); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			cfg.MaxChoices = i
+		}
+	}
+	if cfg.MaxChoices <= 0 {
+		cfg.MaxChoices = 8
+	}
+	if v := strings.TrimSpace(os.Getenv("CHOICES_MIN_SUCCESS")); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			cfg.ChoicesMinSuccess = i
+		}
+	}
+	if cfg.ChoicesMinSuccess < 0 {
+		cfg.ChoicesMinSuccess = 0
+	}
+
+	applySessionCleanupEnv(&cfg.SessionCleanup)
+	switch cfg.SessionCleanup.Mode {
+	case "immediate", "delay", "keep_last":
+	default:
+		cfg.SessionCleanup.Mode = "off"
+	}
+	if cfg.SessionCleanup.DelayMinutes <= 0 {
+		cfg.SessionCleanup.DelayMinutes = 30
+	}
+	if cfg.SessionCleanup.KeepLast <= 0 {
+		cfg.SessionCleanup.KeepLast = 20
+	}
+	if cfg.SessionCleanup.Retries <= 0 {
+		cfg.SessionCleanup.Retries = 3
+	}
+
+	if v := strings.TrimSpace(os.Getenv("SESSION_POOL_SIZE")); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			cfg.SessionPool.Size = i
+		}
+	}
+	if cfg.SessionPool.Size < 0 {
+		cfg.SessionPool.Size = 0
+	}
+	if v := strings.TrimSpace(os.Getenv("SESSION_POOL_TTL_SECONDS")); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			cfg.SessionPool.TTLSeconds = i
+		}
+	}
+	if cfg.SessionPool.TTLSeconds <= 0 {
+		cfg.SessionPool.TTLSeconds = 600
+	}
+
+	if v := strings.TrimSpace(os.Getenv("POW_PREFETCH_DEPTH")); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			cfg.PowPrefetch.Depth = i
+		}
+	}
+	if cfg.PowPrefetch.Depth < 0 {
+		cfg.PowPrefetch.Depth = 0
+	}
+	if v := strings.TrimSpace(os.Getenv("POW_PREFETCH_WORKERS")); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			cfg.PowPrefetch.Workers = i
+		}
+	}
+	if cfg.PowPrefetch.Workers <= 0 {
+		cfg.PowPrefetch.Workers = max(1, runtime.NumCPU()/2)
+	}
+
+	if v := strings.TrimSpace(os.Getenv("RESPONSES_MAX_ENTRIES")); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			cfg.Responses.MaxEntries = i
+		}
+	}
+	if cfg.Responses.MaxEntries <= 0 {
+		cfg.Responses.MaxEntries = 1000
+	}
+	if v := strings.TrimSpace(os.Getenv("RESPONSES_TTL_HOURS")); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			cfg.Responses.TTLHours = i
+		}
+	}
+	if cfg.Responses.TTLHours <= 0 {
+		cfg.Responses.TTLHours = 24
+	}
+
 	applyCloudSyncEnv(&cfg.CloudSync)
 	if cfg.CloudSync.IntervalSeconds <= 0 {
 		cfg.CloudSync.IntervalSeconds = 30
@@ -103,6 +352,27 @@ func Load() Config {
 	return cfg
 }
 
+func applySessionCleanupEnv(sc *SessionCleanupConfig) {
+	if v := strings.TrimSpace(strings.ToLower(os.Getenv("SESSION_CLEANUP_MODE"))); v != "" {
+		sc.Mode = v
+	}
+	if v := strings.TrimSpace(os.Getenv("SESSION_CLEANUP_DELAY_MINUTES")); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			sc.DelayMinutes = i
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("SESSION_CLEANUP_KEEP_LAST")); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			sc.KeepLast = i
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("SESSION_CLEANUP_RETRIES")); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			sc.Retries = i
+		}
+	}
+}
+
 func applyCloudSyncEnv(cs *CloudSyncConfig) {
 	if v, ok := getenvBool("CLOUDSYNC_ENABLED"); ok {
 		cs.Enabled = v
@@ -147,6 +417,9 @@ func getenvBool(name string) (bool, bool) {
 }
 
 func (c Config) URLLogin() string { return "https://" + c.DeepSeekHost + "/api/v0/users/login" }
+func (c Config) URLCurrentUser() string {
+	return "https://" + c.DeepSeekHost + "/api/v0/users/current"
+}
 func (c Config) URLSession() string {
 	return "https://" + c.DeepSeekHost + "/api/v0/chat_session/create"
 }
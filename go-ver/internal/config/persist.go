@@ -0,0 +1,115 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// saveMu serialises the read-modify-write cycles on the config file.
+var saveMu sync.Mutex
+
+// SaveAccountTokens writes the tokens of accounts into the accounts of the
+// config file at path, matched by email or mobile. Every other field is kept
+// as is and the file is replaced atomically.
+func SaveAccountTokens(path string, accounts []AccountConfig) error {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+	var entries []map[string]any
+	dec := json.NewDecoder(bytes.NewReader(doc["accounts"]))
+	dec.UseNumber()
+	if err := dec.Decode(&entries); err != nil {
+		return err
+	}
+	byID := make(map[string]AccountConfig, len(accounts))
+	for _, a := range accounts {
+		byID[a.ID()] = a
+	}
+	changed := false
+	for _, e := range entries {
+		email, _ := e["email"].(string)
+		mobile, _ := e["mobile"].(string)
+		a, ok := byID[AccountConfig{Email: email, Mobile: mobile}.ID()]
+		if !ok || a.Token == "" || a.Token == e["token"] {
+			continue
+		}
+		e["token"] = a.Token
+		e["token_issued_at"] = a.TokenIssuedAt
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	if doc["accounts"], err = json.Marshal(entries); err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(out, '\n'))
+}
+
+// SaveFields sets the given top-level fields of the config file at path,
+// keeping all others, and replaces the file atomically.
+func SaveFields(path string, fields map[string]any) error {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+	for k, v := range fields {
+		if doc[k], err = json.Marshal(v); err != nil {
+			return err
+		}
+	}
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(out, '\n'))
+}
+
+// writeFileAtomic replaces path through a synced temporary file in the same
+// directory, so readers never see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0o600)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
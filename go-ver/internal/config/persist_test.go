@@ -0,0 +1,49 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveAccountTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	orig := `{"keys":["k"],"custom":{"x":1},"accounts":[{"email":"a@example.com","password":"pw","weight":3},{"mobile":"123","token":"old"}]}`
+	if err := os.WriteFile(path, []byte(orig), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	err := SaveAccountTokens(path, []AccountConfig{
+		{Email: "a@example.com", Token: "t-a", TokenIssuedAt: 1700000000},
+		{Mobile: "123", Token: "t-m", TokenIssuedAt: 1700000001},
+		{Email: "unknown@example.com", Token: "t-x"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(path)
+	var got struct {
+		Keys     []string        `json:"keys"`
+		Custom   map[string]int  `json:"custom"`
+		Accounts []AccountConfig `json:"accounts"`
+	}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("%v: %s", err, b)
+	}
+	if len(got.Keys) != 1 || got.Custom["x"] != 1 || len(got.Accounts) != 2 {
+		t.Fatalf("other fields not preserved: %s", b)
+	}
+	a, m := got.Accounts[0], got.Accounts[1]
+	if a.Token != "t-a" || a.TokenIssuedAt != 1700000000 || a.Password != "pw" || a.Weight != 3 {
+		t.Fatalf("email account = %+v", a)
+	}
+	if m.Token != "t-m" || m.TokenIssuedAt != 1700000001 {
+		t.Fatalf("mobile account = %+v", m)
+	}
+	if fi, _ := os.Stat(path); fi.Mode().Perm() != 0o640 {
+		t.Fatalf("mode = %v", fi.Mode())
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Fatalf("temporary files left behind: %v", entries)
+	}
+}
@@ -0,0 +1,102 @@
+package conversation
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+type Turn struct {
+	SessionID string
+	MessageID int
+	Account   string
+	ExpireAt  int64
+}
+
+type entry struct {
+	key  string
+	turn Turn
+}
+
+// Cache maps conversation keys to the upstream turn they continue. Entries
+// are evicted oldest first once maxEntries is reached; order holds them in
+// insertion order and turns points at their elements.
+type Cache struct {
+	mu         sync.Mutex
+	turns      map[string]*list.Element
+	order      list.List
+	maxEntries int
+	ttl        time.Duration
+}
+
+func NewCache(maxEntries int, ttl time.Duration) *Cache {
+	return &Cache{turns: map[string]*list.Element{}, maxEntries: maxEntries, ttl: ttl}
+}
+
+func Key(scope, prompt string) string {
+	sum := sha256.Sum256([]byte(scope + "\x00" + prompt))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) Get(key string) (Turn, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.turns[key]
+	if !ok {
+		return Turn{}, false
+	}
+	t := el.Value.(*entry).turn
+	if t.ExpireAt > 0 && time.Now().Unix() >= t.ExpireAt {
+		c.removeLocked(el)
+		return Turn{}, false
+	}
+	return t, true
+}
+
+func (c *Cache) Put(key string, t Turn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl > 0 {
+		t.ExpireAt = time.Now().Add(c.ttl).Unix()
+	}
+	if el, exists := c.turns[key]; exists {
+		el.Value.(*entry).turn = t
+		return
+	}
+	c.turns[key] = c.order.PushBack(&entry{key: key, turn: t})
+	for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		c.removeLocked(c.order.Front())
+	}
+}
+
+func (c *Cache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.turns[key]; ok {
+		c.removeLocked(el)
+	}
+}
+
+func (c *Cache) DropSession(sessionID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, el := range c.turns {
+		if el.Value.(*entry).turn.SessionID == sessionID {
+			c.removeLocked(el)
+		}
+	}
+}
+
+// Len returns the number of cached turns.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *Cache) removeLocked(el *list.Element) {
+	delete(c.turns, el.Value.(*entry).key)
+	c.order.Remove(el)
+}
@@ -0,0 +1,37 @@
+package conversation
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheEvictsOldestLiveEntry(t *testing.T) {
+	c := NewCache(2, time.Hour)
+	c.Put("a", Turn{SessionID: "s1"})
+	c.Delete("a")
+	c.Put("a", Turn{SessionID: "s1"})
+	c.Put("b", Turn{SessionID: "s2"})
+	c.Put("c", Turn{SessionID: "s1"})
+	c.DropSession("s1")
+	c.Put("d", Turn{SessionID: "s3"})
+	for key, want := range map[string]bool{"a": false, "b": true, "c": false, "d": true} {
+		if _, ok := c.Get(key); ok != want {
+			t.Fatalf("Get(%q) = %v, want %v", key, ok, want)
+		}
+	}
+	c.Put("e", Turn{})
+	if _, ok := c.Get("b"); ok || c.Len() != 2 {
+		t.Fatalf("expected b evicted and 2 entries, have %d", c.Len())
+	}
+}
+
+func TestCacheDropsExpiredEntries(t *testing.T) {
+	c := NewCache(2, time.Nanosecond)
+	c.Put("a", Turn{SessionID: "s1"})
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expired turn returned")
+	}
+	if c.Len() != 0 {
+		t.Fatalf("expired turn still cached: %d entries", c.Len())
+	}
+}
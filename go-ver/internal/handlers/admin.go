@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"deepseek2api-go/internal/accounts"
+	"deepseek2api-go/internal/auth"
+	"deepseek2api-go/internal/config"
+	"deepseek2api-go/internal/state"
+)
+
+// adminError is returned from config updates to reject a request with status.
+type adminError struct {
+	status int
+	msg    string
+}
+
+func (e *adminError) Error() string { return e.msg }
+
+// Admin serves the /admin/ API for managing pooled accounts, caller keys and
+// runtime config. It is only reachable with the admin key; every change is
+// persisted to the config file and published through cloud sync.
+func Admin(st *state.AppState) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := st.GetConfig()
+		if cfg.AdminKey == "" {
+			WriteJSON(w, http.StatusNotFound, map[string]any{"error": "Admin API is disabled."})
+			return
+		}
+		if !auth.IsAdmin(r, cfg) {
+			WriteJSON(w, http.StatusUnauthorized, map[string]any{"error": "Unauthorized: invalid admin key."})
+			return
+		}
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/"), "/"), "/")
+		switch parts[0] {
+		case "accounts":
+			adminAccounts(st, w, r, parts[1:])
+		case "keys":
+			adminKeys(st, w, r, parts[1:])
+		case "config":
+			adminConfig(st, w, r, parts[1:])
+		default:
+			WriteJSON(w, http.StatusNotFound, map[string]any{"error": "Not found."})
+		}
+	}
+}
+
+func adminAccounts(st *state.AppState, w http.ResponseWriter, r *http.Request, rest []string) {
+	switch {
+	case len(rest) == 0 && r.Method == http.MethodGet:
+		WriteJSON(w, http.StatusOK, map[string]any{"accounts": adminAccountList(st)})
+	case len(rest) == 0 && r.Method == http.MethodPost:
+		var in config.AccountConfig
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			WriteJSON(w, http.StatusBadRequest, map[string]any{"error": "Invalid JSON body."})
+			return
+		}
+		in.TokenIssuedAt = 0
+		err := st.UpdateConfig(func(cfg *config.Config) error {
+			if in.ID() == "" || (in.Password == "" && in.Token == "") {
+				return &adminError{http.StatusBadRequest, "An account needs an email or mobile, and a password or token."}
+			}
+			if findAccount(cfg.Accounts, in.ID()) >= 0 {
+				return &adminError{http.StatusConflict, "Account already exists."}
+			}
+			cfg.Accounts = append(cfg.Accounts, in)
+			return nil
+		})
+		writeAdminResult(w, err, http.StatusCreated, map[string]any{"id": in.ID()})
+	case len(rest) == 1 && r.Method == http.MethodDelete:
+		id := rest[0]
+		err := st.UpdateConfig(func(cfg *config.Config) error {
+			i := findAccount(cfg.Accounts, id)
+			if i < 0 {
+				return &adminError{http.StatusNotFound, "Account not found."}
+			}
+			cfg.Accounts = slices.Delete(cfg.Accounts, i, i+1)
+			return nil
+		})
+		writeAdminResult(w, err, http.StatusOK, map[string]any{"id": id, "deleted": true})
+	case len(rest) == 2 && r.Method == http.MethodPost && (rest[1] == "disable" || rest[1] == "enable"):
+		id, disable := rest[0], rest[1] == "disable"
+		err := st.UpdateConfig(func(cfg *config.Config) error {
+			i := findAccount(cfg.Accounts, id)
+			if i < 0 {
+				return &adminError{http.StatusNotFound, "Account not found."}
+			}
+			cfg.Accounts[i].Disabled = disable
+			return nil
+		})
+		if err == nil && !disable {
+			// Re-enabling also lifts a cooldown or an automatic disable.
+			st.Pool.ReportSuccess(id)
+		}
+		writeAdminResult(w, err, http.StatusOK, map[string]any{"id": id, "disabled": disable})
+	case len(rest) == 2 && r.Method == http.MethodPost && rest[1] == "refresh":
+		if err := st.Pool.RefreshToken(rest[0]); errors.Is(err, accounts.ErrNoPassword) {
+			WriteJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+			return
+		} else if err != nil {
+			WriteJSON(w, http.StatusBadGateway, map[string]any{"error": "Token refresh failed: " + err.Error()})
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]any{"id": rest[0], "refreshed": true})
+	default:
+		WriteJSON(w, http.StatusNotFound, map[string]any{"error": "Not found."})
+	}
+}
+
+// adminAccountList lists the configured accounts with their pool state;
+// accounts left out of the pool by max_active_accounts show as inactive.
+func adminAccountList(st *state.AppState) []map[string]any {
+	pooled := map[string]map[string]any{}
+	for _, e := range st.Pool.GetStatus()["accounts"].([]map[string]any) {
+		pooled[e["id"].(string)] = e
+	}
+	accounts := st.GetConfig().Accounts
+	out := make([]map[string]any, 0, len(accounts))
+	for _, a := range accounts {
+		e, ok := pooled[a.ID()]
+		if !ok {
+			e = map[string]any{"id": a.ID(), "state": "inactive", "weight": a.Weight}
+		}
+		e["email"], e["mobile"], e["disabled"] = a.Email, a.Mobile, a.Disabled
+		out = append(out, e)
+	}
+	return out
+}
+
+func findAccount(accounts []config.AccountConfig, id string) int {
+	return slices.IndexFunc(accounts, func(a config.AccountConfig) bool { return a.ID() == id })
+}
+
+func adminKeys(st *state.AppState, w http.ResponseWriter, r *http.Request, rest []string) {
+	switch {
+	case len(rest) == 0 && r.Method == http.MethodGet:
+		WriteJSON(w, http.StatusOK, map[string]any{"keys": st.GetConfig().Keys})
+	case len(rest) == 0 && r.Method == http.MethodPost:
+		var in struct {
+			Key string `json:"key"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+				WriteJSON(w, http.StatusBadRequest, map[string]any{"error": "Invalid JSON body."})
+				return
+			}
+		}
+		key := strings.TrimSpace(in.Key)
+		if key == "" {
+			key = newCallerKey()
+		}
+		err := st.UpdateConfig(func(cfg *config.Config) error {
+			if key == cfg.AdminKey {
+				return &adminError{http.StatusBadRequest, "Caller keys must differ from the admin key."}
+			}
+			if slices.Contains(cfg.Keys, key) {
+				return &adminError{http.StatusConflict, "Key already exists."}
+			}
+			cfg.Keys = append(cfg.Keys, key)
+			return nil
+		})
+		writeAdminResult(w, err, http.StatusCreated, map[string]any{"key": key})
+	case len(rest) == 1 && r.Method == http.MethodDelete:
+		key := rest[0]
+		err := st.UpdateConfig(func(cfg *config.Config) error {
+			i := slices.Index(cfg.Keys, key)
+			if i < 0 {
+				return &adminError{http.StatusNotFound, "Key not found."}
+			}
+			cfg.Keys = slices.Delete(cfg.Keys, i, i+1)
+			return nil
+		})
+		writeAdminResult(w, err, http.StatusOK, map[string]any{"deleted": true})
+	default:
+		WriteJSON(w, http.StatusNotFound, map[string]any{"error": "Not found."})
+	}
+}
+
+func newCallerKey() string {
+	b := make([]byte, 24)
+	_, _ = rand.Read(b)
+	return "sk-" + hex.EncodeToString(b)
+}
+
+func adminConfig(st *state.AppState, w http.ResponseWriter, r *http.Request, rest []string) {
+	if len(rest) != 0 {
+		WriteJSON(w, http.StatusNotFound, map[string]any{"error": "Not found."})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		var in struct {
+			ClaudeModelMapping map[string]string `json:"claude_model_mapping"`
+			MaxActiveAccounts  *int              `json:"max_active_accounts"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			WriteJSON(w, http.StatusBadRequest, map[string]any{"error": "Invalid JSON body."})
+			return
+		}
+		err := st.UpdateConfig(func(cfg *config.Config) error {
+			if in.MaxActiveAccounts != nil {
+				if *in.MaxActiveAccounts < 0 {
+					return &adminError{http.StatusBadRequest, "max_active_accounts must not be negative."}
+				}
+				cfg.MaxActiveAccounts = *in.MaxActiveAccounts
+			}
+			if in.ClaudeModelMapping != nil {
+				cfg.ClaudeModelMapping = in.ClaudeModelMapping
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, state.ErrNotPersisted) {
+			writeAdminResult(w, err, 0, nil)
+			return
+		}
+		writeAdminResult(w, err, http.StatusOK, adminConfigView(st))
+		return
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	WriteJSON(w, http.StatusOK, adminConfigView(st))
+}
+
+func adminConfigView(st *state.AppState) map[string]any {
+	cfg := st.GetConfig()
+	return map[string]any{
+		"claude_model_mapping": cfg.ClaudeModelMapping,
+		"max_active_accounts":  cfg.MaxActiveAccounts,
+	}
+}
+
+func writeAdminResult(w http.ResponseWriter, err error, status int, payload map[string]any) {
+	var ae *adminError
+	switch {
+	case errors.Is(err, state.ErrNotPersisted):
+		payload["persisted"], payload["warning"] = false, err.Error()
+		WriteJSON(w, status, payload)
+	case errors.As(err, &ae):
+		WriteJSON(w, ae.status, map[string]any{"error": ae.msg})
+	case err != nil:
+		WriteJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+	default:
+		WriteJSON(w, status, payload)
+	}
+}
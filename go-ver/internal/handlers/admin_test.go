@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"deepseek2api-go/internal/accounts"
+	"deepseek2api-go/internal/config"
+	"deepseek2api-go/internal/logging"
+	"deepseek2api-go/internal/state"
+)
+
+func newAdminState(t *testing.T, adminKey string) *state.AppState {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	raw := `{"keys":["caller"],"admin_key":"` + adminKey + `","accounts":[{"email":"a@example.com","token":"t-a"}],"note":"kept"}`
+	if err := os.WriteFile(path, []byte(raw), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var cfg config.Config
+	_ = json.Unmarshal([]byte(raw), &cfg)
+	cfg.Path, cfg.DeepSeekHost = path, "chat.deepseek.com"
+	pool := accounts.NewPool(cfg, &http.Client{})
+	return state.NewAppState(cfg, logging.New("error"), &http.Client{}, pool, nil, nil, nil)
+}
+
+func TestAdminReportsUnsavedChanges(t *testing.T) {
+	cfg := config.Config{Keys: []string{"caller"}, AdminKey: "root", DeepSeekHost: "chat.deepseek.com"}
+	st := state.NewAppState(cfg, logging.New("error"), &http.Client{}, accounts.NewPool(cfg, &http.Client{}), nil, nil, nil)
+	code, out := adminDo(Admin(st), "root", http.MethodPost, "/admin/keys", `{"key":"second"}`)
+	if code != http.StatusCreated || out["persisted"] != false || out["key"] != "second" {
+		t.Fatalf("status %d: %v", code, out)
+	}
+	if keys := st.GetConfig().Keys; len(keys) != 2 {
+		t.Fatalf("keys = %v", keys)
+	}
+}
+
+func adminDo(h http.Handler, key, method, path, body string) (int, map[string]any) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set("X-Admin-Key", key)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var out map[string]any
+	_ = json.Unmarshal(rec.Body.Bytes(), &out)
+	return rec.Code, out
+}
+
+func TestAdminAuth(t *testing.T) {
+	cases := []struct {
+		adminKey string
+		sent     string
+		want     int
+	}{
+		{"", "", http.StatusNotFound},
+		{"", "anything", http.StatusNotFound},
+		{"root", "", http.StatusUnauthorized},
+		{"root", "caller", http.StatusUnauthorized},
+		{"root", "root", http.StatusOK},
+	}
+	for _, tc := range cases {
+		h := Admin(newAdminState(t, tc.adminKey))
+		if code, _ := adminDo(h, tc.sent, http.MethodGet, "/admin/keys", ""); code != tc.want {
+			t.Fatalf("admin key %q, sent %q: status %d, want %d", tc.adminKey, tc.sent, code, tc.want)
+		}
+	}
+}
+
+func TestAdminManagesAccountsKeysAndConfig(t *testing.T) {
+	st := newAdminState(t, "root")
+	h := Admin(st)
+	steps := []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodPost, "/admin/accounts", `{"email":"b@example.com","token":"t-b","weight":2}`, http.StatusCreated},
+		{http.MethodPost, "/admin/accounts", `{"email":"b@example.com","token":"t-b"}`, http.StatusConflict},
+		{http.MethodPost, "/admin/accounts", `{"email":"c@example.com"}`, http.StatusBadRequest},
+		{http.MethodPost, "/admin/accounts/a@example.com/disable", "", http.StatusOK},
+		{http.MethodDelete, "/admin/accounts/missing@example.com", "", http.StatusNotFound},
+		{http.MethodPost, "/admin/keys", `{"key":"second"}`, http.StatusCreated},
+		{http.MethodPost, "/admin/keys", `{"key":"root"}`, http.StatusBadRequest},
+		{http.MethodDelete, "/admin/keys/caller", "", http.StatusOK},
+		{http.MethodPatch, "/admin/config", `{"max_active_accounts":-1}`, http.StatusBadRequest},
+		{http.MethodPatch, "/admin/config", `{"max_active_accounts":5,"claude_model_mapping":{"fast":"deepseek-reasoner"}}`, http.StatusOK},
+	}
+	for _, s := range steps {
+		if code, out := adminDo(h, "root", s.method, s.path, s.body); code != s.want {
+			t.Fatalf("%s %s: status %d, want %d (%v)", s.method, s.path, code, s.want, out)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		ac, ok := st.Pool.Acquire(nil)
+		if !ok || st.Pool.AccountID(*ac) != "b@example.com" {
+			t.Fatalf("disabled account handed out: %v", ac)
+		}
+		st.Pool.Release(ac)
+	}
+	_, out := adminDo(h, "root", http.MethodGet, "/admin/accounts", "")
+	list := out["accounts"].([]any)
+	if len(list) != 2 || list[0].(map[string]any)["state"] != accounts.HealthOff || list[1].(map[string]any)["token"] != "***" {
+		t.Fatalf("accounts = %v", list)
+	}
+
+	b, _ := os.ReadFile(st.GetConfig().Path)
+	var saved struct {
+		Keys               []string               `json:"keys"`
+		Accounts           []config.AccountConfig `json:"accounts"`
+		ClaudeModelMapping map[string]string      `json:"claude_model_mapping"`
+		MaxActiveAccounts  int                    `json:"max_active_accounts"`
+		Note               string                 `json:"note"`
+	}
+	if err := json.Unmarshal(b, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(saved.Keys, ",") != "second" || len(saved.Accounts) != 2 || !saved.Accounts[0].Disabled ||
+		saved.Accounts[1].Weight != 2 || saved.ClaudeModelMapping["fast"] != "deepseek-reasoner" || saved.MaxActiveAccounts != 5 || saved.Note != "kept" {
+		t.Fatalf("saved config = %s", b)
+	}
+
+	if code, _ := adminDo(h, "root", http.MethodPost, "/admin/accounts/a@example.com/enable", ""); code != http.StatusOK {
+		t.Fatalf("enable: status %d", code)
+	}
+	if code, _ := adminDo(h, "root", http.MethodDelete, "/admin/accounts/b@example.com", ""); code != http.StatusOK {
+		t.Fatalf("delete: status %d", code)
+	}
+	ac, ok := st.Pool.Acquire(nil)
+	if !ok || st.Pool.AccountID(*ac) != "a@example.com" {
+		t.Fatalf("re-enabled account not handed out: %v", ac)
+	}
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -24,7 +25,7 @@ func ClaudeMessages(st *state.AppState) http.HandlerFunc {
 			WriteJSON(w, code, map[string]any{"error": map[string]any{"type": "invalid_request_error", "message": msg}})
 			return
 		}
-		defer auth.ReleaseAccountIfNeeded(ac, st.Pool)
+		defer releaseRequest(st, ac)
 
 		var req map[string]any
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -40,54 +41,42 @@ func ClaudeMessages(st *state.AppState) http.HandlerFunc {
 		}
 
 		normalizedMessages := normalizeClaudeMessages(toMapSlice(messagesAny))
-		toolsRequested := toMapSliceAny(req["tools"])
+		toolChoice := services.ParseClaudeToolChoice(req["tool_choice"])
+		toolsRequested := services.FilterTools(toMapSliceAny(req["tools"]), toolChoice)
 		payloadMessages := make([]map[string]any, 0, len(normalizedMessages)+2)
 
 		if systemMsg := parseClaudeSystemMessage(req["system"]); systemMsg != nil {
 			payloadMessages = append(payloadMessages, systemMsg)
 		}
 		payloadMessages = append(payloadMessages, normalizedMessages...)
-		if len(toolsRequested) > 0 && !hasSystemRole(payloadMessages) {
-			payloadMessages = append([]map[string]any{buildToolSystemMessage(toolsRequested)}, payloadMessages...)
+		if len(toolsRequested) > 0 && (!hasSystemRole(payloadMessages) || toolChoice.Mode == "required") {
+			payloadMessages = append([]map[string]any{buildToolSystemMessage(cfg.ToolPersona, toolsRequested, toolChoice)}, payloadMessages...)
 		}
 
 		deepseekModel := mapClaudeModel(cfg, model)
 		thinkingEnabled, searchEnabled, _ := services.ResolveModelFlags(deepseekModel)
 		finalPrompt := services.MessagesPrepare(payloadMessages)
 
-		headers := auth.GetAuthHeaders(cfg, ac)
-		sessionID, err := st.DeepSeek.CreateSession(r.Context(), headers, 3)
-		if err != nil || sessionID == "" {
-			if ac.UseConfigToken && auth.SwitchAccount(ac, st.Pool) {
-				headers = auth.GetAuthHeaders(cfg, ac)
-				sessionID, err = st.DeepSeek.CreateSession(r.Context(), headers, 3)
-			}
-		}
-		if err != nil || sessionID == "" {
-			WriteJSON(w, http.StatusUnauthorized, map[string]any{"error": map[string]any{"type": "invalid_request_error", "message": "invalid token."}})
-			return
-		}
-
-		powResp, err := st.DeepSeek.GetPoW(r.Context(), headers, st.PowSolver, st.PowCache, 3)
-		if err != nil || powResp == "" {
-			if ac.UseConfigToken && auth.SwitchAccount(ac, st.Pool) {
-				headers = auth.GetAuthHeaders(cfg, ac)
-				powResp, err = st.DeepSeek.GetPoW(r.Context(), headers, st.PowSolver, st.PowCache, 3)
-			}
-		}
-		if err != nil || powResp == "" {
-			WriteJSON(w, http.StatusUnauthorized, map[string]any{"error": map[string]any{"type": "invalid_request_error", "message": "Failed to get PoW."}})
+		scope := conversationScope(ac, deepseekModel)
+		start, failure := startConversation(r.Context(), st, cfg, ac, scope, payloadMessages, finalPrompt)
+		if failure != "" {
+			WriteJSON(w, http.StatusUnauthorized, map[string]any{"error": map[string]any{"type": "invalid_request_error", "message": failure}})
 			return
 		}
-
-		headers["x-ds-pow-response"] = powResp
-		payload := map[string]any{"chat_session_id": sessionID, "parent_message_id": nil, "client_stream_id": services.NewClientStreamID(), "prompt": finalPrompt, "ref_file_ids": []any{}, "thinking_enabled": thinkingEnabled, "search_enabled": searchEnabled}
+		headers := start.Headers
+		payload := map[string]any{"chat_session_id": start.SessionID, "parent_message_id": start.ParentID, "client_stream_id": services.NewClientStreamID(), "prompt": start.Prompt, "ref_file_ids": []any{}, "thinking_enabled": thinkingEnabled, "search_enabled": searchEnabled}
+		opts := services.ClaudeOptions{Repair: toolRepair(st, cfg, headers), ToolChoice: toolChoice}
+		opts.Limits = services.OutputLimits{Stop: services.ParseStopSequences(req["stop_sequences"]), MaxTokens: services.ParseMaxTokens(req["max_tokens"])}
+		opts.OnReply = rememberConversation(st, ac, scope, &start, payloadMessages, func(reply map[string]any) map[string]any {
+			return normalizeClaudeMessages([]map[string]any{reply})[0]
+		})
+		opts.Replay = replayConversation(r.Context(), st, cfg, ac, &start, payload, finalPrompt)
 		streaming, _ := req["stream"].(bool)
 		if streaming {
-			services.ClaudeStream(r.Context(), w, st.DeepSeek, headers, payload, model, normalizedMessages, toolsRequested)
+			services.ClaudeStream(r.Context(), w, st.DeepSeek, headers, payload, model, normalizedMessages, toolsRequested, opts)
 			return
 		}
-		status, out := services.ClaudeNonStream(r.Context(), st.DeepSeek, headers, payload, model, normalizedMessages, toolsRequested)
+		status, out := services.ClaudeNonStream(r.Context(), st.DeepSeek, headers, payload, model, normalizedMessages, toolsRequested, opts)
 		WriteJSON(w, status, out)
 	}
 }
@@ -118,19 +107,20 @@ func toMapSliceAny(v any) []map[string]any {
 }
 
 func normalizeClaudeMessages(messages []map[string]any) []map[string]any {
+	toolNames := map[string]string{}
 	out := make([]map[string]any, 0, len(messages))
 	for _, m := range messages {
 		n := map[string]any{}
 		for k, v := range m {
 			n[k] = v
 		}
-		n["content"] = normalizeClaudeContent(m["content"])
+		n["content"] = normalizeClaudeContent(m["content"], toolNames)
 		out = append(out, n)
 	}
 	return out
 }
 
-func normalizeClaudeContent(content any) any {
+func normalizeClaudeContent(content any, toolNames map[string]string) any {
 	arr, ok := content.([]any)
 	if !ok {
 		if s, ok := content.(string); ok {
@@ -139,6 +129,7 @@ func normalizeClaudeContent(content any) any {
 		return content
 	}
 	parts := make([]string, 0, len(arr))
+	calls := make([]map[string]any, 0)
 	for _, block := range arr {
 		b, ok := block.(map[string]any)
 		if !ok {
@@ -150,12 +141,22 @@ func normalizeClaudeContent(content any) any {
 			if t, ok := b["text"].(string); ok {
 				parts = append(parts, strings.ToValidUTF8(t, ""))
 			}
-		case "tool_result":
-			if c, ok := b["content"]; ok {
-				parts = append(parts, fmt.Sprintf("%v", c))
+		case "tool_use":
+			id, _ := b["id"].(string)
+			name, _ := b["name"].(string)
+			if id != "" {
+				toolNames[id] = name
 			}
+			calls = append(calls, map[string]any{"id": id, "name": name, "input": b["input"]})
+		case "tool_result":
+			id, _ := b["tool_use_id"].(string)
+			isError, _ := b["is_error"].(bool)
+			parts = append(parts, services.FormatToolResult(id, toolNames[id], renderToolResultContent(b["content"]), isError))
 		}
 	}
+	if len(calls) > 0 {
+		parts = append(parts, services.FormatToolCalls(calls))
+	}
 	if len(parts) > 0 {
 		return strings.Join(parts, "\n")
 	}
@@ -165,8 +166,42 @@ func normalizeClaudeContent(content any) any {
 	return ""
 }
 
+func renderToolResultContent(v any) string {
+	switch c := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.ToValidUTF8(c, "")
+	case []any:
+		parts := make([]string, 0, len(c))
+		for _, it := range c {
+			b, ok := it.(map[string]any)
+			if !ok {
+				parts = append(parts, fmt.Sprintf("%v", it))
+				continue
+			}
+			switch typ, _ := b["type"].(string); typ {
+			case "text":
+				t, _ := b["text"].(string)
+				parts = append(parts, strings.ToValidUTF8(t, ""))
+			case "image":
+				parts = append(parts, "[image]")
+			case "document":
+				parts = append(parts, "[document]")
+			default:
+				raw, _ := json.Marshal(b)
+				parts = append(parts, string(raw))
+			}
+		}
+		return strings.Join(parts, "\n")
+	default:
+		b, _ := json.Marshal(c)
+		return string(b)
+	}
+}
+
 func parseClaudeSystemMessage(v any) map[string]any {
-	s := normalizeClaudeContent(v)
+	s := normalizeClaudeContent(v, map[string]string{})
 	if text, ok := s.(string); ok && strings.TrimSpace(text) != "" {
 		return map[string]any{"role": "system", "content": text}
 	}
@@ -182,19 +217,16 @@ func hasSystemRole(messages []map[string]any) bool {
 	return false
 }
 
-func buildToolSystemMessage(tools []map[string]any) map[string]any {
-	infos := make([]string, 0, len(tools))
-	for _, t := range tools {
-		name, _ := t["name"].(string)
-		desc, _ := t["description"].(string)
-		if strings.TrimSpace(name) == "" {
-			name = "unknown"
-		}
-		if strings.TrimSpace(desc) == "" {
-			desc = "No description available"
-		}
-		infos = append(infos, "Tool: "+name+"\nDescription: "+desc)
+func buildToolSystemMessage(persona string, tools []map[string]any, choice services.ToolChoice) map[string]any {
+	content := services.BuildToolPrompt(tools, choice)
+	if strings.TrimSpace(persona) != "" {
+		content = strings.TrimSpace(persona) + "\n\n" + content
 	}
-	content := "You are Claude, a helpful AI assistant. You have access to these tools:\n\n" + strings.Join(infos, "\n\n") + "\n\nWhen you need to use tools, output ONLY valid JSON in this format:\n{\"tool_calls\": [{\"name\": \"tool_name\", \"input\": {\"param\": \"value\"}}]}\n\nYou can call multiple tools in ONE response by including them in the same tool_calls array.\nDo not include any text outside the JSON structure."
 	return map[string]any{"role": "system", "content": content}
 }
+
+func toolRepair(st *state.AppState, cfg config.Config, headers map[string]string) services.ToolRepair {
+	return services.ToolRepair{Retries: cfg.ToolRepairRetries, PoW: func(ctx context.Context) (string, error) {
+		return st.DeepSeek.GetPoW(ctx, headers, st.PowSolver, st.PowCache, 3)
+	}}
+}
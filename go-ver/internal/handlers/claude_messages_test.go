@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeClaudeContent(t *testing.T) {
+	unknown := []any{map[string]any{"type": "server_tool_use", "id": "srv_1"}}
+	messages := normalizeClaudeMessages([]map[string]any{
+		{"role": "user", "content": "Weather in SF?"},
+		{"role": "assistant", "content": []any{
+			map[string]any{"type": "text", "text": "Checking."},
+			map[string]any{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": map[string]any{"city": "SF"}},
+			map[string]any{"type": "thinking", "thinking": "skipped"},
+		}},
+		{"role": "user", "content": []any{
+			map[string]any{"type": "tool_result", "tool_use_id": "toolu_1", "is_error": true, "content": []any{
+				map[string]any{"type": "text", "text": "timeout"},
+				map[string]any{"type": "image", "source": map[string]any{}},
+				map[string]any{"type": "custom", "v": 1},
+				"raw",
+			}},
+			map[string]any{"type": "tool_result", "tool_use_id": "toolu_9", "content": "ok"},
+		}},
+		{"role": "user", "content": unknown},
+		{"role": "user", "content": []any{}},
+	})
+	want := []any{
+		"Weather in SF?",
+		"Checking.\n" + `{"tool_calls":[{"id":"toolu_1","input":{"city":"SF"},"name":"get_weather"}]}`,
+		"<tool_result id=\"toolu_1\" name=\"get_weather\" is_error=\"true\">\ntimeout\n[image]\n{\"type\":\"custom\",\"v\":1}\nraw\n</tool_result>\n" +
+			"<tool_result id=\"toolu_9\">\nok\n</tool_result>",
+		unknown,
+		"",
+	}
+	for i, m := range messages {
+		if !reflect.DeepEqual(m["content"], want[i]) {
+			t.Fatalf("message %d content = %#v, want %#v", i, m["content"], want[i])
+		}
+		if m["role"] == nil {
+			t.Fatalf("message %d lost its role", i)
+		}
+	}
+}
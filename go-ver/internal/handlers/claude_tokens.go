@@ -33,7 +33,7 @@ func ClaudeTokens(st *state.AppState) http.HandlerFunc {
 			WriteJSON(w, http.StatusBadRequest, map[string]any{"error": map[string]any{"type": "invalid_request_error", "message": "Request must include 'model' and 'messages'."}})
 			return
 		}
-		count := len(services.MessagesPrepare(toMapSlice(messages))) / 4
+		count := len(services.MessagesPrepare(normalizeClaudeMessages(toMapSlice(messages)))) / 4
 		if count < 1 {
 			count = 1
 		}
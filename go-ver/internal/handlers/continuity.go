@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"context"
+	"maps"
+
+	"deepseek2api-go/internal/accounts"
+	"deepseek2api-go/internal/auth"
+	"deepseek2api-go/internal/config"
+	"deepseek2api-go/internal/conversation"
+	"deepseek2api-go/internal/services"
+	"deepseek2api-go/internal/state"
+)
+
+type conversationStart struct {
+	Headers   map[string]string
+	SessionID string
+	ParentID  any
+	Prompt    string
+	// resumed is the cache key of the turn being continued, if any.
+	resumed string
+}
+
+func conversationScope(ac *auth.AuthContext, model string) string {
+	return ac.CallerKey + "\x00" + model
+}
+
+// startConversation continues the upstream chat session that produced the
+// conversation's last assistant turn when it is still cached, sending only
+// the messages after it. Anything else opens a new session and replays the
+// whole transcript.
+func startConversation(ctx context.Context, st *state.AppState, cfg config.Config, ac *auth.AuthContext, scope string, messages []map[string]any, finalPrompt string) (conversationStart, string) {
+	if start, ok := resumeConversation(ctx, st, cfg, ac, scope, messages); ok {
+		return start, ""
+	}
+	headers, sessionID, failure := openDeepSeekSession(ctx, st, cfg, ac)
+	return conversationStart{Headers: headers, SessionID: sessionID, Prompt: finalPrompt}, failure
+}
+
+func resumeConversation(ctx context.Context, st *state.AppState, cfg config.Config, ac *auth.AuthContext, scope string, messages []map[string]any) (conversationStart, bool) {
+	cut := len(messages) - 1
+	for cut >= 0 && messages[cut]["role"] != "assistant" {
+		cut--
+	}
+	if cut < 0 || cut == len(messages)-1 {
+		return conversationStart{}, false
+	}
+	key := conversation.Key(scope, services.MessagesPrepare(messages[:cut+1]))
+	turn, ok := st.Conversations.Get(key)
+	if !ok {
+		return conversationStart{}, false
+	}
+	if !auth.PinAccount(ctx, cfg, ac, st.Pool, turn.Account) {
+		st.Conversations.Delete(key)
+		return conversationStart{}, false
+	}
+	headers := auth.GetAuthHeaders(cfg, ac)
+	powResp, err := accountPoW(ctx, st, ac, headers)
+	if err != nil || powResp == "" {
+		auth.ReportFailure(ctx, ac, st.Pool, accounts.FailurePoW, err)
+		return conversationStart{}, false
+	}
+	auth.ReportSuccess(ac, st.Pool)
+	headers["x-ds-pow-response"] = powResp
+	trackSession(st, ac, turn.SessionID)
+	return conversationStart{Headers: headers, SessionID: turn.SessionID, ParentID: turn.MessageID, Prompt: services.MessagesPrepare(messages[cut+1:]), resumed: key}, true
+}
+
+// replayConversation returns the services.Replay for a resumed start: it
+// forgets the cached turn, opens a new session and replays finalPrompt into
+// it, updating start. Starts that did not resume need no replay.
+func replayConversation(ctx context.Context, st *state.AppState, cfg config.Config, ac *auth.AuthContext, start *conversationStart, payload map[string]any, finalPrompt string) services.Replay {
+	if start.resumed == "" {
+		return nil
+	}
+	return func() (map[string]string, map[string]any, bool) {
+		st.Conversations.Delete(start.resumed)
+		headers, sessionID, failure := openDeepSeekSession(ctx, st, cfg, ac)
+		if failure != "" {
+			return nil, nil, false
+		}
+		*start = conversationStart{Headers: headers, SessionID: sessionID, Prompt: finalPrompt}
+		next := maps.Clone(payload)
+		next["chat_session_id"], next["parent_message_id"], next["prompt"] = sessionID, nil, finalPrompt
+		next["client_stream_id"] = services.NewClientStreamID()
+		return headers, next, true
+	}
+}
+
+// rememberConversation returns an OnReply hook that caches start's session so
+// a follow-up request that echoes this reply back can continue it.
+func rememberConversation(st *state.AppState, ac *auth.AuthContext, scope string, start *conversationStart, messages []map[string]any, normalize func(map[string]any) map[string]any) func(int, map[string]any) {
+	return func(messageID int, reply map[string]any) {
+		history := append(append(make([]map[string]any, 0, len(messages)+1), messages...), normalize(reply))
+		st.Conversations.Put(conversation.Key(scope, services.MessagesPrepare(history)), conversation.Turn{SessionID: start.SessionID, MessageID: messageID, Account: auth.AccountID(ac, st.Pool)})
+	}
+}
+
+func trackSession(st *state.AppState, ac *auth.AuthContext, sessionID string) {
+	st.Sessions.Acquire(auth.AccountID(ac, st.Pool), ac.DeepSeekToken, sessionID)
+	ac.Sessions = append(ac.Sessions, sessionID)
+}
+
+// releaseRequest hands the request's sessions to the cleanup policy, lets the
+// PoW prefetcher top up the account and returns it to the pool.
+func releaseRequest(st *state.AppState, ac *auth.AuthContext) {
+	if ac == nil {
+		return
+	}
+	for _, id := range ac.Sessions {
+		st.Sessions.Release(id)
+	}
+	ac.Sessions = nil
+	st.PowPrefetch.Idle(auth.AccountID(ac, st.Pool), ac.DeepSeekToken)
+	auth.ReleaseAccountIfNeeded(ac, st.Pool)
+}
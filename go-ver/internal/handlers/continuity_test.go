@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"deepseek2api-go/internal/auth"
+	"deepseek2api-go/internal/conversation"
+	"deepseek2api-go/internal/services"
+	"deepseek2api-go/internal/state"
+)
+
+func TestRememberedTurnMatchesEchoedHistory(t *testing.T) {
+	cases := []struct {
+		name      string
+		normalize func(map[string]any) map[string]any
+		reply     map[string]any
+		echoed    map[string]any
+	}{
+		{
+			name:      "openai",
+			normalize: func(m map[string]any) map[string]any { return m },
+			reply:     map[string]any{"role": "assistant", "content": "", "tool_calls": []any{map[string]any{"id": "call_1", "type": "function", "function": map[string]any{"name": "get", "arguments": `{"city":"SF"}`}}}},
+			echoed:    map[string]any{"role": "assistant", "content": nil, "tool_calls": []any{map[string]any{"id": "call_1", "type": "function", "function": map[string]any{"name": "get", "arguments": `{"city": "SF"}`}}}},
+		},
+		{
+			name:      "claude",
+			normalize: func(m map[string]any) map[string]any { return normalizeClaudeMessages([]map[string]any{m})[0] },
+			reply:     map[string]any{"role": "assistant", "content": []any{map[string]any{"type": "text", "text": "hi"}}},
+			echoed:    normalizeClaudeMessages([]map[string]any{{"role": "assistant", "content": []any{map[string]any{"type": "thinking", "thinking": "..."}, map[string]any{"type": "text", "text": "hi"}}}})[0],
+		},
+	}
+	for _, tc := range cases {
+		st := &state.AppState{Conversations: conversation.NewCache(10, time.Hour)}
+		ac := &auth.AuthContext{CallerKey: "key"}
+		scope := conversationScope(ac, "m")
+		first := []map[string]any{{"role": "user", "content": "hello"}}
+		rememberConversation(st, ac, scope, &conversationStart{SessionID: "sess"}, first, tc.normalize)(5, tc.reply)
+
+		next := append(append([]map[string]any{}, first...), tc.echoed)
+		turn, ok := st.Conversations.Get(conversation.Key(scope, services.MessagesPrepare(next)))
+		if !ok || turn.SessionID != "sess" || turn.MessageID != 5 {
+			t.Fatalf("%s: expected cached turn, got %v %v", tc.name, turn, ok)
+		}
+		if _, ok := st.Conversations.Get(conversation.Key(conversationScope(ac, "other"), services.MessagesPrepare(next))); ok {
+			t.Fatalf("%s: turn leaked across models", tc.name)
+		}
+	}
+}
@@ -1,12 +1,17 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
+	"deepseek2api-go/internal/accounts"
 	"deepseek2api-go/internal/auth"
+	"deepseek2api-go/internal/config"
 	"deepseek2api-go/internal/services"
 	"deepseek2api-go/internal/state"
 )
@@ -23,7 +28,7 @@ func OpenAIChat(st *state.AppState) http.HandlerFunc {
 			WriteJSON(w, code, map[string]any{"error": msg})
 			return
 		}
-		defer auth.ReleaseAccountIfNeeded(ac, st.Pool)
+		defer releaseRequest(st, ac)
 		var req map[string]any
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			WriteJSON(w, http.StatusBadRequest, map[string]any{"error": "Invalid JSON body."})
@@ -46,40 +51,176 @@ func OpenAIChat(st *state.AppState) http.HandlerFunc {
 			WriteJSON(w, http.StatusServiceUnavailable, map[string]any{"error": "Model '" + model + "' is not available."})
 			return
 		}
-		finalPrompt := services.MessagesPrepare(messages)
-		headers := auth.GetAuthHeaders(cfg, ac)
-		sessionID, err := st.DeepSeek.CreateSession(r.Context(), headers, 3)
-		if err != nil || sessionID == "" {
-			if ac.UseConfigToken && auth.SwitchAccount(ac, st.Pool) {
-				headers = auth.GetAuthHeaders(cfg, ac)
-				sessionID, err = st.DeepSeek.CreateSession(r.Context(), headers, 3)
-			}
+		toolChoice := services.ParseOpenAIToolChoice(req["tool_choice"], req["parallel_tool_calls"])
+		toolsRequested := services.FilterTools(services.NormalizeOpenAITools(req["tools"]), toolChoice)
+		if len(toolsRequested) > 0 {
+			messages = append([]map[string]any{buildToolSystemMessage(cfg.ToolPersona, toolsRequested, toolChoice)}, messages...)
+		}
+		responseFormat := services.ParseResponseFormat(req["response_format"])
+		if responseFormat.Enabled() {
+			messages = append([]map[string]any{{"role": "system", "content": services.BuildResponseFormatPrompt(responseFormat)}}, messages...)
 		}
-		if err != nil || sessionID == "" {
-			WriteJSON(w, http.StatusUnauthorized, map[string]any{"error": "invalid token."})
+		finalPrompt := services.MessagesPrepare(messages)
+		n := parseChoiceCount(req["n"])
+		if n > cfg.MaxChoices {
+			WriteJSON(w, http.StatusBadRequest, map[string]any{"error": fmt.Sprintf("'n' must be at most %d.", cfg.MaxChoices)})
 			return
 		}
-		powResp, err := st.DeepSeek.GetPoW(r.Context(), headers, st.PowSolver, st.PowCache, 3)
-		if err != nil || powResp == "" {
-			if ac.UseConfigToken && auth.SwitchAccount(ac, st.Pool) {
-				headers = auth.GetAuthHeaders(cfg, ac)
-				powResp, err = st.DeepSeek.GetPoW(r.Context(), headers, st.PowSolver, st.PowCache, 3)
-			}
+		var start conversationStart
+		var failure string
+		scope := conversationScope(ac, model)
+		if n > 1 {
+			start.Headers, start.SessionID, failure = openDeepSeekSession(r.Context(), st, cfg, ac)
+			start.Prompt = finalPrompt
+		} else {
+			start, failure = startConversation(r.Context(), st, cfg, ac, scope, messages, finalPrompt)
 		}
-		if err != nil || powResp == "" {
-			WriteJSON(w, http.StatusUnauthorized, map[string]any{"error": "Failed to get PoW (invalid token or unknown error)."})
+		if failure != "" {
+			WriteJSON(w, http.StatusUnauthorized, map[string]any{"error": failure})
 			return
 		}
-		headers["x-ds-pow-response"] = powResp
-		payload := map[string]any{"chat_session_id": sessionID, "parent_message_id": nil, "client_stream_id": services.NewClientStreamID(), "prompt": finalPrompt, "ref_file_ids": []any{}, "thinking_enabled": thinkingEnabled, "search_enabled": searchEnabled}
+		headers, sessionID := start.Headers, start.SessionID
+		opts := services.OpenAIOptions{Tools: toolsRequested, ToolChoice: toolChoice, Repair: toolRepair(st, cfg, headers), ResponseFormat: responseFormat}
+		opts.Limits = services.OutputLimits{Stop: services.ParseStopSequences(req["stop"]), MaxTokens: services.ParseMaxTokens(req["max_completion_tokens"], req["max_tokens"])}
+		payload := map[string]any{"chat_session_id": sessionID, "parent_message_id": start.ParentID, "client_stream_id": services.NewClientStreamID(), "prompt": start.Prompt, "ref_file_ids": []any{}, "thinking_enabled": thinkingEnabled, "search_enabled": searchEnabled}
 		created := time.Now().Unix()
 		completionID := sessionID
 		streaming, _ := req["stream"].(bool)
+		if n > 1 {
+			branches, extras := openChoiceBranches(r.Context(), st, cfg, ac, n, services.OpenAIBranch{Headers: headers, Payload: payload, Repair: opts.Repair})
+			defer func() {
+				for _, extra := range extras {
+					releaseRequest(st, extra)
+				}
+			}()
+			minSuccess := cfg.ChoicesMinSuccess
+			if minSuccess <= 0 || minSuccess > n {
+				minSuccess = n
+			}
+			if len(branches) < minSuccess {
+				WriteJSON(w, http.StatusServiceUnavailable, map[string]any{"error": fmt.Sprintf("Only %d of %d upstream sessions could be opened.", len(branches), n)})
+				return
+			}
+			if streaming {
+				services.OpenAIStreamN(r.Context(), w, st.DeepSeek, branches, model, finalPrompt, completionID, created, thinkingEnabled, searchEnabled, opts, minSuccess)
+				return
+			}
+			status, out := services.OpenAINonStreamN(r.Context(), st.DeepSeek, branches, model, finalPrompt, completionID, created, thinkingEnabled, searchEnabled, opts, minSuccess)
+			WriteJSON(w, status, out)
+			return
+		}
+		opts.OnReply = rememberConversation(st, ac, scope, &start, messages, func(reply map[string]any) map[string]any { return reply })
+		opts.Replay = replayConversation(r.Context(), st, cfg, ac, &start, payload, finalPrompt)
 		if streaming {
-			services.OpenAIStream(r.Context(), w, st.DeepSeek, headers, payload, model, finalPrompt, completionID, created, thinkingEnabled, searchEnabled)
+			services.OpenAIStream(r.Context(), w, st.DeepSeek, headers, payload, model, finalPrompt, completionID, created, thinkingEnabled, searchEnabled, opts)
 			return
 		}
-		status, out := services.OpenAINonStream(r.Context(), st.DeepSeek, headers, payload, model, finalPrompt, completionID, created, thinkingEnabled, searchEnabled)
+		status, out := services.OpenAINonStream(r.Context(), st.DeepSeek, headers, payload, model, finalPrompt, completionID, created, thinkingEnabled, searchEnabled, opts)
 		WriteJSON(w, status, out)
 	}
 }
+
+func openDeepSeekSession(ctx context.Context, st *state.AppState, cfg config.Config, ac *auth.AuthContext) (map[string]string, string, string) {
+	headers := auth.GetAuthHeaders(cfg, ac)
+	var err error
+	sessionID, warm := st.WarmSessions.Take(auth.AccountID(ac, st.Pool), ac.DeepSeekToken)
+	if !warm {
+		sessionID, err = st.DeepSeek.CreateSession(ctx, headers, 3)
+	}
+	if err != nil || sessionID == "" {
+		auth.ReportFailure(ctx, ac, st.Pool, accounts.FailureSession, err)
+		if ac.UseConfigToken && auth.SwitchAccount(ctx, cfg, ac, st.Pool) {
+			headers = auth.GetAuthHeaders(cfg, ac)
+			sessionID, err = st.DeepSeek.CreateSession(ctx, headers, 3)
+			if err != nil || sessionID == "" {
+				auth.ReportFailure(ctx, ac, st.Pool, accounts.FailureSession, err)
+			}
+		}
+	}
+	if err != nil || sessionID == "" {
+		return nil, "", "invalid token."
+	}
+	trackSession(st, ac, sessionID)
+	powResp, err := accountPoW(ctx, st, ac, headers)
+	if err != nil || powResp == "" {
+		auth.ReportFailure(ctx, ac, st.Pool, accounts.FailurePoW, err)
+		if ac.UseConfigToken && auth.SwitchAccount(ctx, cfg, ac, st.Pool) {
+			headers = auth.GetAuthHeaders(cfg, ac)
+			powResp, err = accountPoW(ctx, st, ac, headers)
+			if err != nil || powResp == "" {
+				auth.ReportFailure(ctx, ac, st.Pool, accounts.FailurePoW, err)
+			}
+		}
+	}
+	if err != nil || powResp == "" {
+		return nil, "", "Failed to get PoW (invalid token or unknown error)."
+	}
+	auth.ReportSuccess(ac, st.Pool)
+	headers["x-ds-pow-response"] = powResp
+	return headers, sessionID, ""
+}
+
+// accountPoW consumes an answer prefetched for the request's account and
+// falls back to solving a fresh challenge.
+func accountPoW(ctx context.Context, st *state.AppState, ac *auth.AuthContext, headers map[string]string) (string, error) {
+	if answer, ok := st.PowPrefetch.Take(auth.AccountID(ac, st.Pool), ac.DeepSeekToken); ok {
+		return answer, nil
+	}
+	return st.DeepSeek.GetPoW(ctx, headers, st.PowSolver, st.PowCache, 3)
+}
+
+func parseChoiceCount(v any) int {
+	if f, ok := v.(float64); ok && f >= 1 {
+		return int(f)
+	}
+	return 1
+}
+
+func openChoiceBranches(ctx context.Context, st *state.AppState, cfg config.Config, ac *auth.AuthContext, n int, first services.OpenAIBranch) ([]services.OpenAIBranch, []*auth.AuthContext) {
+	used := map[string]bool{}
+	if ac.Account != nil {
+		used[st.Pool.AccountID(*ac.Account)] = true
+	}
+	extras := make([]*auth.AuthContext, 0, n-1)
+	for i := 1; i < n; i++ {
+		extra, ok := auth.AcquireExtra(ctx, cfg, ac, st.Pool, used)
+		if !ok {
+			break
+		}
+		if extra.Account != nil {
+			used[st.Pool.AccountID(*extra.Account)] = true
+		}
+		extras = append(extras, extra)
+	}
+
+	branches := make([]services.OpenAIBranch, len(extras)+1)
+	ok := make([]bool, len(branches))
+	branches[0], ok[0] = first, true
+	var wg sync.WaitGroup
+	for i, extra := range extras {
+		wg.Add(1)
+		go func(i int, extra *auth.AuthContext) {
+			defer wg.Done()
+			headers, sessionID, failure := openDeepSeekSession(ctx, st, cfg, extra)
+			if failure != "" {
+				return
+			}
+			payload := make(map[string]any, len(first.Payload))
+			for k, v := range first.Payload {
+				payload[k] = v
+			}
+			payload["chat_session_id"] = sessionID
+			payload["client_stream_id"] = services.NewClientStreamID()
+			branches[i], ok[i] = services.OpenAIBranch{Headers: headers, Payload: payload, Repair: toolRepair(st, cfg, headers)}, true
+		}(i+1, extra)
+	}
+	wg.Wait()
+
+	out := make([]services.OpenAIBranch, 0, len(branches))
+	for i, b := range branches {
+		if ok[i] {
+			out = append(out, b)
+		}
+	}
+	return out, extras
+}
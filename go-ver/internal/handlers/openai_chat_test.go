@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"deepseek2api-go/internal/accounts"
+	"deepseek2api-go/internal/auth"
+	"deepseek2api-go/internal/clients"
+	"deepseek2api-go/internal/config"
+	"deepseek2api-go/internal/logging"
+	"deepseek2api-go/internal/pow"
+	"deepseek2api-go/internal/state"
+)
+
+// blockingSolver stands in for a slow PoW solve: it signals started and waits
+// for the request to be cancelled.
+type blockingSolver struct{ started chan struct{} }
+
+func (blockingSolver) Warmup() error { return nil }
+
+func (s blockingSolver) Solve(ctx context.Context, algorithm, challenge, salt string, difficulty int, expireAt int64, signature, targetPath string) (int64, bool) {
+	close(s.started)
+	<-ctx.Done()
+	return 0, false
+}
+
+func TestCancelledPoWKeepsAccountHealthy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/session":
+			_ = json.NewEncoder(w).Encode(map[string]any{"code": 0, "data": map[string]any{"biz_data": map[string]any{"id": "sess"}}})
+		case "/pow":
+			_ = json.NewEncoder(w).Encode(map[string]any{"code": 0, "data": map[string]any{"biz_data": map[string]any{"challenge": map[string]any{
+				"algorithm": "DeepSeekHashV1", "challenge": "c", "salt": "s", "signature": "sig", "difficulty": 1000, "expire_at": 4102444800,
+			}}}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	cfg := config.Config{Keys: []string{"caller"}, Accounts: []config.AccountConfig{{Email: "a@example.com", Token: "t-a"}}, DeepSeekHost: "chat.deepseek.com"}
+	pool := accounts.NewPool(cfg, upstream.Client())
+	solver := blockingSolver{started: make(chan struct{})}
+	ds := clients.NewDeepSeekClient(upstream.Client(), upstream.URL+"/session", upstream.URL+"/pow", upstream.URL+"/completion")
+	st := state.NewAppState(cfg, logging.New("error"), upstream.Client(), pool, solver, pow.NewCache(), ds)
+
+	acc, ok := pool.Acquire(nil)
+	if !ok {
+		t.Fatal("no account")
+	}
+	ac := &auth.AuthContext{UseConfigToken: true, CallerKey: "caller", Account: acc, DeepSeekToken: acc.Token, FailedAccounts: map[string]bool{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-solver.started
+		cancel()
+	}()
+	if _, _, failure := openDeepSeekSession(ctx, st, cfg, ac); failure == "" {
+		t.Fatal("expected the cancelled request to fail")
+	}
+	for _, e := range pool.GetStatus()["accounts"].([]map[string]any) {
+		if e["state"] != accounts.HealthHealthy || e["consecutive_failures"] != nil {
+			t.Fatalf("cancellation counted against the account: %v", e)
+		}
+	}
+}
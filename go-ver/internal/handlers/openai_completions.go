@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"deepseek2api-go/internal/auth"
+	"deepseek2api-go/internal/services"
+	"deepseek2api-go/internal/state"
+)
+
+func OpenAICompletions(st *state.AppState) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		cfg := st.GetConfig()
+		ac, code, msg, err := auth.DetermineModeAndToken(r, cfg, st.Pool)
+		if err != nil {
+			WriteJSON(w, code, map[string]any{"error": msg})
+			return
+		}
+		defer releaseRequest(st, ac)
+		var req map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			WriteJSON(w, http.StatusBadRequest, map[string]any{"error": "Invalid JSON body."})
+			return
+		}
+		model, _ := req["model"].(string)
+		prompts, ok := services.ParseCompletionPrompts(req["prompt"])
+		if model == "" || !ok {
+			WriteJSON(w, http.StatusBadRequest, map[string]any{"error": "Request must include 'model' and a string or array-of-strings 'prompt'."})
+			return
+		}
+		if len(prompts) > cfg.MaxChoices {
+			WriteJSON(w, http.StatusBadRequest, map[string]any{"error": fmt.Sprintf("At most %d prompts are allowed per request.", cfg.MaxChoices)})
+			return
+		}
+		for i := range prompts {
+			prompts[i] = strings.ToValidUTF8(prompts[i], "")
+		}
+		thinkingEnabled, searchEnabled, ok := services.ResolveModelFlags(model)
+		if !ok {
+			WriteJSON(w, http.StatusServiceUnavailable, map[string]any{"error": "Model '" + model + "' is not available."})
+			return
+		}
+		suffix, _ := req["suffix"].(string)
+		echo, _ := req["echo"].(bool)
+
+		headers, sessionID, failure := openDeepSeekSession(r.Context(), st, cfg, ac)
+		if failure != "" {
+			WriteJSON(w, http.StatusUnauthorized, map[string]any{"error": failure})
+			return
+		}
+		payload := map[string]any{"chat_session_id": sessionID, "parent_message_id": nil, "client_stream_id": services.NewClientStreamID(), "prompt": services.BuildCompletionPrompt(prompts[0], suffix), "ref_file_ids": []any{}, "thinking_enabled": thinkingEnabled, "search_enabled": searchEnabled}
+		branches := []services.OpenAIBranch{{Headers: headers, Payload: payload}}
+		if len(prompts) > 1 {
+			var extras []*auth.AuthContext
+			branches, extras = openChoiceBranches(r.Context(), st, cfg, ac, len(prompts), branches[0])
+			defer func() {
+				for _, extra := range extras {
+					releaseRequest(st, extra)
+				}
+			}()
+			if len(branches) < len(prompts) {
+				WriteJSON(w, http.StatusServiceUnavailable, map[string]any{"error": fmt.Sprintf("Only %d of %d upstream sessions could be opened.", len(branches), len(prompts))})
+				return
+			}
+			for i := 1; i < len(branches); i++ {
+				branches[i].Payload["prompt"] = services.BuildCompletionPrompt(prompts[i], suffix)
+			}
+		}
+		opts := services.OpenAIOptions{ToolChoice: services.DefaultToolChoice()}
+		opts.Limits = services.OutputLimits{Stop: services.ParseStopSequences(req["stop"]), MaxTokens: services.ParseMaxTokens(req["max_tokens"])}
+		completionID := "cmpl-" + sessionID
+		created := time.Now().Unix()
+		if streaming, _ := req["stream"].(bool); streaming {
+			services.CompletionStream(r.Context(), w, st.DeepSeek, branches, prompts, echo, model, completionID, created, opts)
+			return
+		}
+		status, out := services.CompletionNonStream(r.Context(), st.DeepSeek, branches, prompts, echo, model, completionID, created, opts)
+		WriteJSON(w, status, out)
+	}
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"deepseek2api-go/internal/auth"
+	"deepseek2api-go/internal/responses"
+	"deepseek2api-go/internal/services"
+	"deepseek2api-go/internal/state"
+)
+
+func OpenAIResponses(st *state.AppState) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		cfg := st.GetConfig()
+		ac, code, msg, err := auth.DetermineModeAndToken(r, cfg, st.Pool)
+		if err != nil {
+			WriteJSON(w, code, map[string]any{"error": msg})
+			return
+		}
+		defer releaseRequest(st, ac)
+		var req map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			WriteJSON(w, http.StatusBadRequest, map[string]any{"error": "Invalid JSON body."})
+			return
+		}
+		model, _ := req["model"].(string)
+		input := services.ResponsesInputToMessages(req["input"])
+		if model == "" || len(input) == 0 {
+			WriteJSON(w, http.StatusBadRequest, map[string]any{"error": "Request must include 'model' and 'input'."})
+			return
+		}
+		thinkingEnabled, searchEnabled, ok := services.ResolveModelFlags(model)
+		if !ok {
+			WriteJSON(w, http.StatusServiceUnavailable, map[string]any{"error": "Model '" + model + "' is not available."})
+			return
+		}
+
+		var history []map[string]any
+		previousID, _ := req["previous_response_id"].(string)
+		if previousID != "" {
+			prev, ok := st.Responses.Get(previousID)
+			if !ok || prev.Owner != ac.CallerKey {
+				WriteJSON(w, http.StatusNotFound, map[string]any{"error": "Previous response '" + previousID + "' not found."})
+				return
+			}
+			history = append(history, prev.Messages...)
+		}
+		conversation := append(history, input...)
+
+		messages := make([]map[string]any, 0, len(conversation)+2)
+		if instructions, _ := req["instructions"].(string); strings.TrimSpace(instructions) != "" {
+			messages = append(messages, map[string]any{"role": "system", "content": instructions})
+		}
+		toolChoice := services.ParseResponsesToolChoice(req["tool_choice"], req["parallel_tool_calls"])
+		toolsRequested := services.FilterTools(services.NormalizeOpenAITools(req["tools"]), toolChoice)
+		if len(toolsRequested) > 0 {
+			messages = append(messages, buildToolSystemMessage(cfg.ToolPersona, toolsRequested, toolChoice))
+		}
+		responseFormat := services.ParseResponsesTextFormat(req["text"])
+		if responseFormat.Enabled() {
+			messages = append(messages, map[string]any{"role": "system", "content": services.BuildResponseFormatPrompt(responseFormat)})
+		}
+		messages = append(messages, conversation...)
+		finalPrompt := services.MessagesPrepare(messages)
+
+		headers, sessionID, failure := openDeepSeekSession(r.Context(), st, cfg, ac)
+		if failure != "" {
+			WriteJSON(w, http.StatusUnauthorized, map[string]any{"error": failure})
+			return
+		}
+		opts := services.OpenAIOptions{Tools: toolsRequested, ToolChoice: toolChoice, Repair: toolRepair(st, cfg, headers), ResponseFormat: responseFormat}
+		opts.Limits = services.OutputLimits{MaxTokens: services.ParseMaxTokens(req["max_output_tokens"])}
+		payload := map[string]any{"chat_session_id": sessionID, "parent_message_id": nil, "client_stream_id": services.NewClientStreamID(), "prompt": finalPrompt, "ref_file_ids": []any{}, "thinking_enabled": thinkingEnabled, "search_enabled": searchEnabled}
+
+		base := map[string]any{
+			"id":                   services.NewResponseID(),
+			"object":               "response",
+			"created_at":           time.Now().Unix(),
+			"status":               "in_progress",
+			"model":                model,
+			"output":               []any{},
+			"previous_response_id": nilIfEmpty(previousID),
+			"instructions":         req["instructions"],
+			"tools":                firstNonNil(req["tools"], []any{}),
+			"tool_choice":          firstNonNil(req["tool_choice"], "auto"),
+			"parallel_tool_calls":  toolChoice.Parallel,
+			"max_output_tokens":    req["max_output_tokens"],
+			"text":                 firstNonNil(req["text"], map[string]any{"format": map[string]any{"type": "text"}}),
+			"metadata":             firstNonNil(req["metadata"], map[string]any{}),
+			"store":                firstNonNil(req["store"], true),
+			"usage":                nil,
+			"error":                nil,
+			"incomplete_details":   nil,
+		}
+		var resp map[string]any
+		if streaming, _ := req["stream"].(bool); streaming {
+			resp = services.ResponsesStream(r.Context(), w, st.DeepSeek, headers, payload, model, finalPrompt, thinkingEnabled, searchEnabled, opts, base)
+		} else {
+			var status int
+			status, resp = services.ResponsesNonStream(r.Context(), st.DeepSeek, headers, payload, model, finalPrompt, thinkingEnabled, searchEnabled, opts, base)
+			WriteJSON(w, status, resp)
+			if status != http.StatusOK {
+				return
+			}
+		}
+		if store, _ := base["store"].(bool); store && resp["status"] != "failed" {
+			output, _ := resp["output"].([]map[string]any)
+			id, _ := resp["id"].(string)
+			st.Responses.Put(id, responses.Entry{Owner: ac.CallerKey, Response: resp, Messages: append(conversation, services.ResponsesInputToMessages(output)...)})
+		}
+	}
+}
+
+func OpenAIResponseByID(st *state.AppState) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/responses/"), "/")
+		entry, ok := st.Responses.Get(id)
+		if !ok || id == "" || entry.Owner != auth.CallerKey(r) {
+			WriteJSON(w, http.StatusNotFound, map[string]any{"error": "Response '" + id + "' not found."})
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			WriteJSON(w, http.StatusOK, entry.Response)
+		case http.MethodDelete:
+			st.Responses.Delete(id)
+			WriteJSON(w, http.StatusOK, map[string]any{"id": id, "object": "response", "deleted": true})
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func nilIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func firstNonNil(v, fallback any) any {
+	if v == nil {
+		return fallback
+	}
+	return v
+}
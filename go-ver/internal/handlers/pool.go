@@ -12,6 +12,25 @@ func PoolStatus(st *state.AppState) http.HandlerFunc {
 			w.WriteHeader(http.StatusMethodNotAllowed)
 			return
 		}
-		WriteJSON(w, http.StatusOK, st.Pool.GetStatus())
+		status := st.Pool.GetStatus()
+		status["session_cleanup"] = st.Sessions.Status()
+		status["session_pool"] = st.WarmSessions.Status()
+		status["pow_prefetch"] = st.PowPrefetch.Status()
+		status["token_check"] = st.TokenCheck.Status()
+		WriteJSON(w, http.StatusOK, status)
+	}
+}
+
+func PowStatus(st *state.AppState) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		status := map[string]any{"prefetch": st.PowPrefetch.Status()}
+		if s, ok := st.PowSolver.(interface{ Status() map[string]any }); ok {
+			status["solver"] = s.Status()
+		}
+		WriteJSON(w, http.StatusOK, status)
 	}
 }
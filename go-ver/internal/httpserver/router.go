@@ -13,10 +13,15 @@ func NewRouter(st *state.AppState) http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", handlers.Root)
 	mux.HandleFunc("/pool/status", handlers.PoolStatus(st))
+	mux.HandleFunc("/pow/status", handlers.PowStatus(st))
 	mux.HandleFunc("/sync/status", handlers.SyncStatus(st))
+	mux.HandleFunc("/admin/", handlers.Admin(st))
 	mux.HandleFunc("/v1/models", handlers.OpenAIModels)
 	mux.HandleFunc("/anthropic/v1/models", handlers.AnthropicModels)
 	mux.HandleFunc("/v1/chat/completions", handlers.OpenAIChat(st))
+	mux.HandleFunc("/v1/completions", handlers.OpenAICompletions(st))
+	mux.HandleFunc("/v1/responses", handlers.OpenAIResponses(st))
+	mux.HandleFunc("/v1/responses/", handlers.OpenAIResponseByID(st))
 	mux.HandleFunc("/anthropic/v1/messages", handlers.ClaudeMessages(st))
 	mux.HandleFunc("/anthropic/v1/messages/count_tokens", handlers.ClaudeTokens(st))
 
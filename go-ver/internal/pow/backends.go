@@ -0,0 +1,219 @@
+package pow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BackendFactory builds a solver backend from its POW_SOLVER_* environment.
+type BackendFactory func() (Solver, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]BackendFactory{
+		"wasm":   func() (Solver, error) { return newDeepSeekHashSolver("wasm"), nil },
+		"native": func() (Solver, error) { return newDeepSeekHashSolver("native"), nil },
+		"python": func() (Solver, error) { return newDeepSeekHashSolver("native"), nil },
+		"exec":   newExecSolverFromEnv,
+		"http":   newHTTPSolverFromEnv,
+	}
+)
+
+// builtinBackends only understand DeepSeekHashV1 and are bounded by the
+// challenge's expire_at, so they get no default timeout.
+var builtinBackends = map[string]bool{"wasm": true, "native": true, "python": true}
+
+func RegisterBackend(name string, factory BackendFactory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	backends[strings.ToLower(name)] = factory
+}
+
+// NewSolver builds the backends listed in POW_SOLVER (comma separated,
+// default "wasm") and chains them in order. Per backend,
+// POW_SOLVER_TIMEOUT_<NAME> bounds each solve and POW_SOLVER_ALGORITHMS_<NAME>
+// lists the challenge algorithms it is tried for ("*" for any).
+func NewSolver() Solver {
+	spec := strings.TrimSpace(strings.ToLower(os.Getenv("POW_SOLVER")))
+	if spec == "" {
+		spec = "wasm"
+	}
+	var entries []chainEntry
+	var errs []string
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		entry, err := newChainEntry(name)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		entry, _ := newChainEntry("wasm")
+		entries = append(entries, entry)
+	}
+	return &ChainSolver{backends: entries, configErrs: errs, strict: os.Getenv("POW_STRICT") == "1"}
+}
+
+func newChainEntry(name string) (chainEntry, error) {
+	backendsMu.RLock()
+	factory, ok := backends[name]
+	backendsMu.RUnlock()
+	if !ok {
+		return chainEntry{}, errors.New("unknown pow solver backend")
+	}
+	s, err := factory()
+	if err != nil {
+		return chainEntry{}, err
+	}
+	key := strings.ToUpper(name)
+	entry := chainEntry{name: name, solver: s, algorithms: []string{"*"}}
+	if builtinBackends[name] {
+		entry.algorithms = []string{"DeepSeekHashV1"}
+	} else {
+		entry.timeout = 30 * time.Second
+	}
+	if v := strings.TrimSpace(os.Getenv("POW_SOLVER_TIMEOUT_" + key)); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return chainEntry{}, fmt.Errorf("invalid timeout %q", v)
+		}
+		entry.timeout = d
+	}
+	if v := strings.TrimSpace(os.Getenv("POW_SOLVER_ALGORITHMS_" + key)); v != "" {
+		entry.algorithms = nil
+		for _, alg := range strings.Split(v, ",") {
+			if alg = strings.TrimSpace(alg); alg != "" {
+				entry.algorithms = append(entry.algorithms, alg)
+			}
+		}
+	}
+	return entry, nil
+}
+
+type chainEntry struct {
+	name       string
+	solver     Solver
+	timeout    time.Duration
+	algorithms []string
+	warmErr    error
+	solved     int64
+	failed     int64
+}
+
+func (e *chainEntry) accepts(algorithm string) bool {
+	algorithm = strings.TrimSpace(algorithm)
+	for _, a := range e.algorithms {
+		if a == "*" || a == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// ChainSolver tries its backends in order, skipping the ones not configured
+// for the challenge's algorithm, until one returns an answer.
+type ChainSolver struct {
+	mu         sync.Mutex
+	backends   []chainEntry
+	configErrs []string
+	strict     bool
+}
+
+// Warmup warms every backend. ErrNoWorkingSolver is only reported when no
+// backend warmed up cleanly, and then always in strict mode.
+func (c *ChainSolver) Warmup() error {
+	var errs []error
+	working := false
+	for i := range c.backends {
+		b := &c.backends[i]
+		err := b.solver.Warmup()
+		c.mu.Lock()
+		b.warmErr = err
+		c.mu.Unlock()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", b.name, err))
+			continue
+		}
+		working = true
+	}
+	for _, msg := range c.configErrs {
+		errs = append(errs, errors.New(msg))
+	}
+	err := errors.Join(errs...)
+	if err != nil && working && errors.Is(err, ErrNoWorkingSolver) {
+		return errors.New(err.Error())
+	}
+	if !working && c.strict && !errors.Is(err, ErrNoWorkingSolver) {
+		return fmt.Errorf("%w: %v", ErrNoWorkingSolver, err)
+	}
+	return err
+}
+
+func (c *ChainSolver) Solve(ctx context.Context, algorithm, challenge, salt string, difficulty int, expireAt int64, signature, targetPath string) (int64, bool) {
+	for i := range c.backends {
+		b := &c.backends[i]
+		if !b.accepts(algorithm) {
+			continue
+		}
+		bctx, cancel := ctx, context.CancelFunc(func() {})
+		if b.timeout > 0 {
+			bctx, cancel = context.WithTimeout(ctx, b.timeout)
+		}
+		nonce, ok := b.solver.Solve(bctx, algorithm, challenge, salt, difficulty, expireAt, signature, targetPath)
+		cancel()
+		c.mu.Lock()
+		if ok {
+			b.solved++
+		} else {
+			b.failed++
+		}
+		c.mu.Unlock()
+		if ok {
+			return nonce, true
+		}
+		if ctx.Err() != nil {
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
+func (c *ChainSolver) Status() map[string]any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := make([]map[string]any, 0, len(c.backends))
+	for _, b := range c.backends {
+		algorithms := append([]string(nil), b.algorithms...)
+		sort.Strings(algorithms)
+		entry := map[string]any{
+			"name":            b.name,
+			"timeout_seconds": b.timeout.Seconds(),
+			"algorithms":      algorithms,
+			"solved":          b.solved,
+			"failed":          b.failed,
+		}
+		if b.warmErr != nil {
+			entry["warmup_error"] = b.warmErr.Error()
+		}
+		if s, ok := b.solver.(interface{ Status() map[string]any }); ok {
+			entry["status"] = s.Status()
+		}
+		list = append(list, entry)
+	}
+	out := map[string]any{"backends": list}
+	if len(c.configErrs) > 0 {
+		out["config_errors"] = c.configErrs
+	}
+	return out
+}
@@ -0,0 +1,280 @@
+package pow
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeSolver struct {
+	answer  int64
+	ok      bool
+	delay   time.Duration
+	warmErr error
+	calls   int
+}
+
+func (f *fakeSolver) Warmup() error { return f.warmErr }
+
+func (f *fakeSolver) Solve(ctx context.Context, algorithm, challenge, salt string, difficulty int, expireAt int64, signature, targetPath string) (int64, bool) {
+	f.calls++
+	select {
+	case <-time.After(f.delay):
+		return f.answer, f.ok
+	case <-ctx.Done():
+		return 0, false
+	}
+}
+
+func TestChainSolverDispatch(t *testing.T) {
+	slow := &fakeSolver{answer: 1, ok: true, delay: time.Second}
+	v1 := &fakeSolver{answer: 2, ok: true}
+	failing := &fakeSolver{}
+	catchAll := &fakeSolver{answer: 3, ok: true}
+	c := &ChainSolver{backends: []chainEntry{
+		{name: "slow", solver: slow, timeout: 20 * time.Millisecond, algorithms: []string{"*"}},
+		{name: "failing", solver: failing, algorithms: []string{"DeepSeekHashV2"}},
+		{name: "v1", solver: v1, algorithms: []string{"DeepSeekHashV1"}},
+		{name: "any", solver: catchAll, algorithms: []string{"*"}},
+	}}
+	cases := []struct {
+		algorithm string
+		want      int64
+	}{
+		{"DeepSeekHashV1", 2},
+		{"DeepSeekHashV2", 3},
+		{"Other", 3},
+	}
+	for _, tc := range cases {
+		got, ok := c.Solve(context.Background(), tc.algorithm, "c", "s", 1, 0, "", "")
+		if !ok || got != tc.want {
+			t.Fatalf("%s: got %d,%v want %d", tc.algorithm, got, ok, tc.want)
+		}
+	}
+	if slow.calls != 3 || failing.calls != 1 || v1.calls != 1 || catchAll.calls != 2 {
+		t.Fatalf("calls slow=%d failing=%d v1=%d any=%d", slow.calls, failing.calls, v1.calls, catchAll.calls)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, ok := c.Solve(ctx, "Other", "c", "s", 1, 0, "", ""); ok {
+		t.Fatal("cancelled request should stop the chain")
+	}
+	if catchAll.calls != 2 {
+		t.Fatalf("chain kept going after cancellation")
+	}
+}
+
+func TestChainSolverWarmup(t *testing.T) {
+	strictFail := &fakeSolver{warmErr: ErrNoWorkingSolver}
+	down := &fakeSolver{warmErr: errors.New("connection refused")}
+	cases := []struct {
+		name     string
+		backends []Solver
+		strict   bool
+		fatal    bool
+	}{
+		{"all fine", []Solver{&fakeSolver{}}, true, false},
+		{"one broken", []Solver{strictFail, &fakeSolver{}}, true, false},
+		{"all broken", []Solver{strictFail}, false, true},
+		{"all down", []Solver{down}, false, false},
+		{"all down strict", []Solver{down, down}, true, true},
+	}
+	for _, tc := range cases {
+		c := &ChainSolver{strict: tc.strict}
+		for _, s := range tc.backends {
+			c.backends = append(c.backends, chainEntry{name: "b", solver: s, algorithms: []string{"*"}})
+		}
+		err := c.Warmup()
+		if got := errors.Is(err, ErrNoWorkingSolver); got != tc.fatal {
+			t.Fatalf("%s: err = %v", tc.name, err)
+		}
+	}
+}
+
+func TestNewSolverFromEnv(t *testing.T) {
+	t.Setenv("POW_SOLVER", "native, http ,bogus")
+	t.Setenv("POW_SOLVER_HTTP_URL", "http://127.0.0.1:1/solve")
+	t.Setenv("POW_SOLVER_TIMEOUT_HTTP", "5s")
+	t.Setenv("POW_SOLVER_ALGORITHMS_HTTP", "DeepSeekHashV2, DeepSeekHashV3")
+	c := NewSolver().(*ChainSolver)
+	if len(c.backends) != 2 || c.backends[0].name != "native" || c.backends[1].name != "http" {
+		t.Fatalf("backends = %+v", c.backends)
+	}
+	if c.backends[0].timeout != 0 || c.backends[0].algorithms[0] != "DeepSeekHashV1" {
+		t.Fatalf("native entry = %+v", c.backends[0])
+	}
+	if h := c.backends[1]; h.timeout != 5*time.Second || strings.Join(h.algorithms, ",") != "DeepSeekHashV2,DeepSeekHashV3" {
+		t.Fatalf("http entry = %+v", h)
+	}
+	if len(c.configErrs) != 1 || !strings.HasPrefix(c.configErrs[0], "bogus:") {
+		t.Fatalf("config errors = %v", c.configErrs)
+	}
+}
+
+func TestHTTPSolver(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req solveRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		switch req.Challenge {
+		case "ok":
+			_, _ = w.Write([]byte(`{"answer":42}`))
+		case "refuse":
+			_, _ = w.Write([]byte(`{"error":"unsupported"}`))
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+	s := NewHTTPSolver(srv.URL, srv.Client())
+	for challenge, want := range map[string]bool{"ok": true, "refuse": false, "boom": false} {
+		got, ok := s.Solve(context.Background(), "DeepSeekHashV2", challenge, "s", 1, 0, "", "")
+		if ok != want || (ok && got != 42) {
+			t.Fatalf("%s: got %d,%v", challenge, got, ok)
+		}
+	}
+}
+
+// TestExecSolverHelper is the subprocess side of TestExecSolver: it answers
+// every request with difficulty-1, or an error for challenge "refuse", and
+// never answers challenge "hang".
+func TestExecSolverHelper(t *testing.T) {
+	if os.Getenv("POW_EXEC_HELPER") != "1" {
+		t.Skip("helper process")
+	}
+	sc := bufio.NewScanner(os.Stdin)
+	enc := json.NewEncoder(os.Stdout)
+	for sc.Scan() {
+		var req solveRequest
+		if json.Unmarshal(sc.Bytes(), &req) != nil {
+			continue
+		}
+		switch req.Challenge {
+		case "hang":
+		case "stall":
+			time.Sleep(time.Hour)
+		case "exit":
+			os.Exit(3)
+		case "refuse":
+			_ = enc.Encode(solveResponse{ID: req.ID, Error: "unsupported"})
+		default:
+			answer := int64(req.Difficulty - 1)
+			_ = enc.Encode(solveResponse{ID: req.ID, Answer: &answer})
+		}
+	}
+	os.Exit(0)
+}
+
+func TestExecSolver(t *testing.T) {
+	t.Setenv("POW_EXEC_HELPER", "1")
+	s := NewExecSolver([]string{os.Args[0], "-test.run=^TestExecSolverHelper$"})
+	if err := s.Warmup(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	for i := 1; i <= 5; i++ {
+		go func(difficulty int) {
+			defer func() { done <- struct{}{} }()
+			got, ok := s.Solve(context.Background(), "DeepSeekHashV2", "c", "s", difficulty, 0, "", "")
+			if !ok || got != int64(difficulty-1) {
+				t.Errorf("difficulty %d: got %d,%v", difficulty, got, ok)
+			}
+		}(i * 10)
+	}
+	for i := 0; i < 5; i++ {
+		<-done
+	}
+	if _, ok := s.Solve(context.Background(), "DeepSeekHashV2", "refuse", "s", 1, 0, "", ""); ok {
+		t.Fatal("refused challenge reported as solved")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, ok := s.Solve(ctx, "DeepSeekHashV2", "hang", "s", 1, 0, "", ""); ok {
+		t.Fatal("unanswered challenge reported as solved")
+	}
+	if _, ok := s.Solve(context.Background(), "DeepSeekHashV2", "exit", "s", 1, 0, "", ""); ok {
+		t.Fatal("crashed solver reported as solved")
+	}
+	got, ok := s.Solve(context.Background(), "DeepSeekHashV2", "c", "s", 8, 0, "", "")
+	if !ok || got != 7 {
+		t.Fatalf("after restart: got %d,%v", got, ok)
+	}
+}
+
+func TestExecSolverStalledStdin(t *testing.T) {
+	s := NewExecSolver([]string{"solver"})
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	s.cmd, s.stdin = &exec.Cmd{}, pw
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan bool)
+	go func() {
+		_, ok := s.Solve(ctx, "DeepSeekHashV2", "c", "s", 1, 0, "", "")
+		done <- ok
+	}()
+	// The request is pending while its write is stuck; Status must still
+	// get the lock.
+	seen := make(chan struct{})
+	go func() {
+		for s.Status()["pending"] != 1 {
+			time.Sleep(time.Millisecond)
+		}
+		close(seen)
+	}()
+	select {
+	case <-seen:
+	case <-time.After(time.Second):
+		t.Fatal("Status blocked behind a stalled write")
+	}
+	if <-done {
+		t.Fatal("unwritten challenge reported as solved")
+	}
+	if n := s.Status()["pending"]; n != 0 {
+		t.Fatalf("pending = %v", n)
+	}
+}
+
+func TestExecSolverRestartsStalledProcess(t *testing.T) {
+	t.Setenv("POW_EXEC_HELPER", "1")
+	s := NewExecSolver([]string{os.Args[0], "-test.run=^TestExecSolverHelper$"})
+	if err := s.Warmup(); err != nil {
+		t.Fatal(err)
+	}
+	base := runtime.NumGoroutine()
+	// Larger than a pipe buffer, so the write blocks once the helper stops
+	// reading.
+	big := strings.Repeat("x", 1<<20)
+	solve := func(challenge, targetPath string) bool {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, ok := s.Solve(ctx, "DeepSeekHashV2", challenge, "s", 1, 0, "", targetPath)
+		return ok
+	}
+	for i := 0; i < 3; i++ {
+		if solve("stall", "") || solve("c", big) {
+			t.Fatal("challenge to a stalled solver reported as solved")
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > base && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > base {
+		t.Fatalf("goroutines = %d, want at most %d", n, base)
+	}
+	if got, ok := s.Solve(context.Background(), "DeepSeekHashV2", "c", "s", 10, 0, "", ""); !ok || got != 9 {
+		t.Fatalf("after restart: got %d,%v", got, ok)
+	}
+}
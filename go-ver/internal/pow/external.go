@@ -0,0 +1,261 @@
+package pow
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// solveRequest is the challenge sent to external solvers, one JSON object per
+// line for subprocesses and as the request body for HTTP services.
+type solveRequest struct {
+	ID         int64  `json:"id,omitempty"`
+	Algorithm  string `json:"algorithm"`
+	Challenge  string `json:"challenge"`
+	Salt       string `json:"salt"`
+	Difficulty int    `json:"difficulty"`
+	ExpireAt   int64  `json:"expire_at"`
+	Signature  string `json:"signature"`
+	TargetPath string `json:"target_path"`
+}
+
+type solveResponse struct {
+	ID     int64  `json:"id,omitempty"`
+	Answer *int64 `json:"answer"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (r solveResponse) result() (int64, bool) {
+	if r.Error != "" || r.Answer == nil {
+		return 0, false
+	}
+	return *r.Answer, true
+}
+
+// ExecSolver runs a long-lived subprocess that reads solveRequest lines on
+// stdin and answers with solveResponse lines carrying the same id on stdout.
+// Requests are pipelined; the process is restarted after it exits.
+type ExecSolver struct {
+	command []string
+
+	mu      sync.Mutex
+	writeMu sync.Mutex
+	stdin   io.WriteCloser
+	cmd     *exec.Cmd
+	nextID  int64
+	pending map[int64]chan solveResponse
+	lastErr string
+}
+
+func NewExecSolver(command []string) *ExecSolver {
+	return &ExecSolver{command: command, pending: map[int64]chan solveResponse{}}
+}
+
+func newExecSolverFromEnv() (Solver, error) {
+	command := strings.Fields(os.Getenv("POW_SOLVER_EXEC"))
+	if len(command) == 0 {
+		return nil, errors.New("POW_SOLVER_EXEC is empty")
+	}
+	return NewExecSolver(command), nil
+}
+
+func (s *ExecSolver) Warmup() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.startLocked()
+}
+
+func (s *ExecSolver) startLocked() error {
+	if s.cmd != nil {
+		return nil
+	}
+	cmd := exec.Command(s.command[0], s.command[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		s.lastErr = err.Error()
+		return err
+	}
+	s.cmd, s.stdin = cmd, stdin
+	go s.read(cmd, stdout)
+	return nil
+}
+
+func (s *ExecSolver) read(cmd *exec.Cmd, stdout io.Reader) {
+	sc := bufio.NewScanner(stdout)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		var resp solveResponse
+		if err := json.Unmarshal(sc.Bytes(), &resp); err != nil {
+			continue
+		}
+		s.mu.Lock()
+		ch, ok := s.pending[resp.ID]
+		delete(s.pending, resp.ID)
+		s.mu.Unlock()
+		if ok {
+			ch <- resp
+		}
+	}
+	err := cmd.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cmd == cmd {
+		s.resetLocked(fmt.Sprintf("solver process exited: %v", err))
+	}
+}
+
+// resetLocked forgets the running process and fails its pending requests, so
+// the next Solve starts a new one.
+func (s *ExecSolver) resetLocked(reason string) {
+	s.cmd, s.stdin = nil, nil
+	s.lastErr = reason
+	for id, ch := range s.pending {
+		ch <- solveResponse{ID: id, Error: reason}
+		delete(s.pending, id)
+	}
+}
+
+func (s *ExecSolver) Solve(ctx context.Context, algorithm, challenge, salt string, difficulty int, expireAt int64, signature, targetPath string) (int64, bool) {
+	s.mu.Lock()
+	if err := s.startLocked(); err != nil {
+		s.mu.Unlock()
+		return 0, false
+	}
+	s.nextID++
+	id := s.nextID
+	ch := make(chan solveResponse, 1)
+	s.pending[id] = ch
+	cmd, stdin := s.cmd, s.stdin
+	s.mu.Unlock()
+
+	// The write can block on a solver that stopped reading, so it runs
+	// outside s.mu and the request can give up on it. A write still blocked
+	// when the request gives up means the process is stuck: it is killed,
+	// which fails the write and lets the next request start a new one.
+	line, _ := json.Marshal(solveRequest{ID: id, Algorithm: algorithm, Challenge: challenge, Salt: salt, Difficulty: difficulty, ExpireAt: expireAt, Signature: signature, TargetPath: targetPath})
+	written := make(chan error, 1)
+	go func() {
+		s.writeMu.Lock()
+		defer s.writeMu.Unlock()
+		_, err := stdin.Write(append(line, '\n'))
+		written <- err
+	}()
+	for {
+		select {
+		case err := <-written:
+			if err == nil {
+				written = nil
+				continue
+			}
+			s.drop(id, err.Error())
+			return 0, false
+		case resp := <-ch:
+			return resp.result()
+		case <-ctx.Done():
+			if written != nil {
+				s.kill(cmd, stdin)
+			}
+			s.drop(id, "")
+			return 0, false
+		}
+	}
+}
+
+func (s *ExecSolver) kill(cmd *exec.Cmd, stdin io.Closer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cmd != cmd {
+		return
+	}
+	if cmd.Process != nil {
+		_ = cmd.Process.Kill()
+	}
+	_ = stdin.Close()
+	s.resetLocked("solver stopped reading requests")
+}
+
+func (s *ExecSolver) drop(id int64, lastErr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, id)
+	if lastErr != "" {
+		s.lastErr = lastErr
+	}
+}
+
+func (s *ExecSolver) Status() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := map[string]any{"command": strings.Join(s.command, " "), "running": s.cmd != nil, "pending": len(s.pending)}
+	if s.lastErr != "" {
+		out["last_error"] = s.lastErr
+	}
+	return out
+}
+
+// HTTPSolver posts each challenge as a solveRequest to a solver service and
+// expects a solveResponse back.
+type HTTPSolver struct {
+	url    string
+	client *http.Client
+}
+
+func NewHTTPSolver(url string, client *http.Client) *HTTPSolver {
+	if client == nil {
+		client = &http.Client{}
+	}
+	return &HTTPSolver{url: url, client: client}
+}
+
+func newHTTPSolverFromEnv() (Solver, error) {
+	url := strings.TrimSpace(os.Getenv("POW_SOLVER_HTTP_URL"))
+	if url == "" {
+		return nil, errors.New("POW_SOLVER_HTTP_URL is empty")
+	}
+	return NewHTTPSolver(url, nil), nil
+}
+
+func (s *HTTPSolver) Warmup() error { return nil }
+
+func (s *HTTPSolver) Solve(ctx context.Context, algorithm, challenge, salt string, difficulty int, expireAt int64, signature, targetPath string) (int64, bool) {
+	b, _ := json.Marshal(solveRequest{Algorithm: algorithm, Challenge: challenge, Salt: salt, Difficulty: difficulty, ExpireAt: expireAt, Signature: signature, TargetPath: targetPath})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(b))
+	if err != nil {
+		return 0, false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, false
+	}
+	var out solveResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return 0, false
+	}
+	return out.result()
+}
+
+func (s *HTTPSolver) Status() map[string]any {
+	return map[string]any{"url": s.url}
+}
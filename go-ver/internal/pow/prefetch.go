@@ -0,0 +1,224 @@
+package pow
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// expiryMargin keeps answers that are about to expire from being handed to a
+// request that still has to reach upstream.
+const expiryMargin = 10 * time.Second
+
+// idleWindow bounds how long after its last request an account keeps being
+// topped up by Run.
+const idleWindow = 10 * time.Minute
+
+// Fetcher fetches a completion challenge with token and solves it, returning
+// the encoded x-ds-pow-response value and the challenge's expire_at.
+type Fetcher func(ctx context.Context, token string) (string, int64, error)
+
+// prefetched is a solved answer and the token its challenge was fetched
+// with; upstream rejects it for any other token.
+type prefetched struct {
+	answer   string
+	token    string
+	expireAt int64
+}
+
+type prefetchQueue struct {
+	ready    []prefetched
+	token    string
+	lastIdle time.Time
+	filling  bool
+}
+
+// Prefetcher solves completion challenges for idle pooled accounts in the
+// background so the request path can consume a ready answer. At most workers
+// solves run at once across all accounts.
+type Prefetcher struct {
+	mu        sync.Mutex
+	fetch     Fetcher
+	depth     int
+	workers   int
+	sem       chan struct{}
+	queues    map[string]*prefetchQueue
+	hits      int64
+	misses    int64
+	expired   int64
+	stale     int64
+	failures  int64
+	solved    int64
+	solveTime time.Duration
+	inflight  int
+}
+
+func NewPrefetcher(fetch Fetcher, depth, workers int) *Prefetcher {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &Prefetcher{fetch: fetch, depth: depth, workers: workers, sem: make(chan struct{}, workers), queues: map[string]*prefetchQueue{}}
+}
+
+func (p *Prefetcher) Enabled() bool { return p.depth > 0 }
+
+// Take returns a prefetched answer for account that is still valid and was
+// fetched with token. Answers fetched with an older token are discarded.
+func (p *Prefetcher) Take(account, token string) (string, bool) {
+	if !p.Enabled() || account == "" {
+		return "", false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	q, ok := p.queues[account]
+	if ok {
+		p.expireLocked(q, time.Now())
+		for len(q.ready) > 0 {
+			a := q.ready[0]
+			q.ready = q.ready[1:]
+			if a.token != token {
+				p.stale++
+				continue
+			}
+			p.hits++
+			return a.answer, true
+		}
+	}
+	p.misses++
+	return "", false
+}
+
+// Idle records that account has no request in flight and starts topping up
+// its queue.
+func (p *Prefetcher) Idle(account, token string) {
+	if !p.Enabled() || account == "" || token == "" {
+		return
+	}
+	p.mu.Lock()
+	q, ok := p.queues[account]
+	if !ok {
+		q = &prefetchQueue{}
+		p.queues[account] = q
+	}
+	q.token = token
+	q.lastIdle = time.Now()
+	refill := p.startFillLocked(q)
+	p.mu.Unlock()
+	if refill {
+		go p.fill(account)
+	}
+}
+
+func (p *Prefetcher) expireLocked(q *prefetchQueue, now time.Time) {
+	kept := q.ready[:0]
+	for _, a := range q.ready {
+		if time.Unix(a.expireAt, 0).Sub(now) <= expiryMargin {
+			p.expired++
+			continue
+		}
+		kept = append(kept, a)
+	}
+	q.ready = kept
+}
+
+func (p *Prefetcher) startFillLocked(q *prefetchQueue) bool {
+	if q.filling || len(q.ready) >= p.depth {
+		return false
+	}
+	q.filling = true
+	return true
+}
+
+func (p *Prefetcher) fill(account string) {
+	for {
+		p.mu.Lock()
+		q := p.queues[account]
+		if len(q.ready) >= p.depth {
+			q.filling = false
+			p.mu.Unlock()
+			return
+		}
+		token := q.token
+		p.mu.Unlock()
+
+		p.sem <- struct{}{}
+		p.mu.Lock()
+		p.inflight++
+		p.mu.Unlock()
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		answer, expireAt, err := p.fetch(ctx, token)
+		cancel()
+		<-p.sem
+
+		p.mu.Lock()
+		p.inflight--
+		if err != nil || answer == "" {
+			p.failures++
+			q.filling = false
+			p.mu.Unlock()
+			return
+		}
+		p.solved++
+		p.solveTime += time.Since(start)
+		q.ready = append(q.ready, prefetched{answer: answer, token: token, expireAt: expireAt})
+		p.expireLocked(q, time.Now())
+		p.mu.Unlock()
+	}
+}
+
+// Run drops expired answers and tops up accounts that were idle within the
+// last idleWindow, so answers that expired while waiting are replaced.
+func (p *Prefetcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.sweep(time.Now())
+		}
+	}
+}
+
+func (p *Prefetcher) sweep(now time.Time) {
+	var refill []string
+	p.mu.Lock()
+	for account, q := range p.queues {
+		p.expireLocked(q, now)
+		if now.Sub(q.lastIdle) < idleWindow && p.startFillLocked(q) {
+			refill = append(refill, account)
+		}
+	}
+	p.mu.Unlock()
+	for _, account := range refill {
+		go p.fill(account)
+	}
+}
+
+func (p *Prefetcher) Status() map[string]any {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ready := make(map[string]int, len(p.queues))
+	for account, q := range p.queues {
+		ready[account] = len(q.ready)
+	}
+	var avg int64
+	if p.solved > 0 {
+		avg = (p.solveTime / time.Duration(p.solved)).Milliseconds()
+	}
+	return map[string]any{
+		"depth":        p.depth,
+		"workers":      p.workers,
+		"hits":         p.hits,
+		"misses":       p.misses,
+		"expired":      p.expired,
+		"stale":        p.stale,
+		"failures":     p.failures,
+		"solved":       p.solved,
+		"avg_solve_ms": avg,
+		"inflight":     p.inflight,
+		"ready":        ready,
+	}
+}
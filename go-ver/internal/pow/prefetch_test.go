@@ -0,0 +1,132 @@
+package pow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func readyCount(p *Prefetcher, account string) int {
+	return p.Status()["ready"].(map[string]int)[account]
+}
+
+func TestPrefetcherFillsAndTakes(t *testing.T) {
+	var n atomic.Int64
+	p := NewPrefetcher(func(ctx context.Context, token string) (string, int64, error) {
+		return fmt.Sprintf("%s-%d", token, n.Add(1)), time.Now().Add(time.Minute).Unix(), nil
+	}, 2, 1)
+
+	if _, ok := p.Take("a", "tok"); ok {
+		t.Fatal("take before idle should miss")
+	}
+	p.Idle("a", "tok")
+	waitFor(t, func() bool { return readyCount(p, "a") == 2 })
+
+	for _, want := range []string{"tok-1", "tok-2"} {
+		got, ok := p.Take("a", "tok")
+		if !ok || got != want {
+			t.Fatalf("take = %q,%v want %q", got, ok, want)
+		}
+	}
+	if _, ok := p.Take("a", "tok"); ok {
+		t.Fatal("drained queue should miss")
+	}
+	st := p.Status()
+	if st["hits"].(int64) != 2 || st["misses"].(int64) != 2 || st["solved"].(int64) != 2 {
+		t.Fatalf("status = %v", st)
+	}
+}
+
+func TestPrefetcherDropsExpiringAnswers(t *testing.T) {
+	p := NewPrefetcher(func(ctx context.Context, token string) (string, int64, error) {
+		return "ans", time.Now().Add(expiryMargin / 2).Unix(), nil
+	}, 1, 1)
+	p.mu.Lock()
+	p.queues["a"] = &prefetchQueue{ready: []prefetched{{answer: "old", token: "tok", expireAt: time.Now().Add(expiryMargin / 2).Unix()}}}
+	p.mu.Unlock()
+
+	if _, ok := p.Take("a", "tok"); ok {
+		t.Fatal("answer inside the expiry margin must not be served")
+	}
+	if got := p.Status()["expired"].(int64); got != 1 {
+		t.Fatalf("expired = %d", got)
+	}
+}
+
+func TestPrefetcherDiscardsAnswersForOldToken(t *testing.T) {
+	p := NewPrefetcher(nil, 2, 1)
+	expireAt := time.Now().Add(time.Minute).Unix()
+	p.mu.Lock()
+	p.queues["a"] = &prefetchQueue{ready: []prefetched{{answer: "old", token: "tok-1", expireAt: expireAt}, {answer: "new", token: "tok-2", expireAt: expireAt}}}
+	p.mu.Unlock()
+
+	if got, ok := p.Take("a", "tok-2"); !ok || got != "new" {
+		t.Fatalf("take = %q,%v", got, ok)
+	}
+	if _, ok := p.Take("a", "tok-2"); ok {
+		t.Fatal("drained queue should miss")
+	}
+	if st := p.Status(); st["stale"].(int64) != 1 || st["hits"].(int64) != 1 {
+		t.Fatalf("status = %v", st)
+	}
+}
+
+func TestPrefetcherStopsOnFailure(t *testing.T) {
+	var calls atomic.Int64
+	p := NewPrefetcher(func(ctx context.Context, token string) (string, int64, error) {
+		calls.Add(1)
+		return "", 0, errors.New("boom")
+	}, 3, 1)
+	p.Idle("a", "tok")
+	waitFor(t, func() bool { return p.Status()["failures"].(int64) == 1 })
+	p.Idle("a", "tok")
+	waitFor(t, func() bool { return p.Status()["failures"].(int64) == 2 })
+	if calls.Load() != 2 {
+		t.Fatalf("calls = %d", calls.Load())
+	}
+}
+
+func TestPrefetcherBoundsConcurrentSolves(t *testing.T) {
+	var mu sync.Mutex
+	running, peak := 0, 0
+	p := NewPrefetcher(func(ctx context.Context, token string) (string, int64, error) {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return "ans", time.Now().Add(time.Minute).Unix(), nil
+	}, 2, 2)
+	for _, account := range []string{"a", "b", "c", "d"} {
+		p.Idle(account, "tok")
+	}
+	waitFor(t, func() bool {
+		for _, account := range []string{"a", "b", "c", "d"} {
+			if readyCount(p, account) != 2 {
+				return false
+			}
+		}
+		return true
+	})
+	if peak > 2 {
+		t.Fatalf("peak concurrent solves = %d, want <= 2", peak)
+	}
+}
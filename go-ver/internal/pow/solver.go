@@ -2,59 +2,84 @@ package pow
 
 import (
 	"context"
-	"encoding/binary"
 	"encoding/hex"
-	"errors"
 	"fmt"
-	"math"
-	"math/big"
 	"math/bits"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tetratelabs/wazero"
-	"github.com/tetratelabs/wazero/api"
 )
 
 type Solver interface {
 	Warmup() error
-	Solve(algorithm, challenge, salt string, difficulty int, expireAt int64, signature, targetPath string) (int64, bool)
+	Solve(ctx context.Context, algorithm, challenge, salt string, difficulty int, expireAt int64, signature, targetPath string) (int64, bool)
 }
 
 type DeepSeekHashSolver struct {
-	mode string
+	mode    string
+	workers int
 
 	mu              sync.Mutex
 	inited          bool
-	runtime         wazero.Runtime
-	module          api.Module
-	memory          api.Memory
-	addStack        api.Function
-	alloc           api.Function
-	wasmSolve       api.Function
+	initErr         error
+	pool            *wasmPool
+	poolSize        int
 	wasmPath        string
+	wasmSHA256      string
+	wasmSource      string
+	wasmDigest      string
+	strict          bool
 	stackResultSize uint32
 }
 
-func NewSolver() Solver {
-	mode := strings.TrimSpace(strings.ToLower(os.Getenv("POW_SOLVER")))
-	if mode == "" {
-		mode = "wasm"
-	}
+func newDeepSeekHashSolver(mode string) *DeepSeekHashSolver {
 	wasmPath := strings.TrimSpace(os.Getenv("POW_WASM_PATH"))
-	if wasmPath == "" {
-		wasmPath = "../sha3_wasm_bg.7b9ca65ddd.wasm"
+	workers := runtime.NumCPU()
+	if v, err := strconv.Atoi(strings.TrimSpace(os.Getenv("POW_NATIVE_WORKERS"))); err == nil && v > 0 {
+		workers = v
+	}
+	poolSize := runtime.GOMAXPROCS(0)
+	if v, err := strconv.Atoi(strings.TrimSpace(os.Getenv("POW_WASM_POOL_SIZE"))); err == nil && v > 0 {
+		poolSize = v
+	}
+	return &DeepSeekHashSolver{
+		mode:            mode,
+		workers:         workers,
+		poolSize:        poolSize,
+		wasmPath:        wasmPath,
+		wasmSHA256:      strings.TrimSpace(os.Getenv("POW_WASM_SHA256")),
+		strict:          os.Getenv("POW_STRICT") == "1",
+		stackResultSize: 16,
 	}
-	return &DeepSeekHashSolver{mode: mode, wasmPath: wasmPath, stackResultSize: 16}
 }
+
+// Warmup loads the WASM module. In strict mode both solvers are also run
+// against a known challenge, and ErrNoWorkingSolver is returned when neither
+// produces the right answer.
 func (s *DeepSeekHashSolver) Warmup() error {
-	if s.mode == "native" || s.mode == "python" {
-		return nil
+	var err error
+	if s.mode != "native" && s.mode != "python" {
+		err = s.initWASM(context.Background())
+		if err == nil && s.strict {
+			err = selfTest(s.solveWASMOnce)
+		}
+	}
+	if err == nil || !s.strict {
+		return err
 	}
-	return s.initWASM(context.Background())
+	if nerr := selfTest(func(ctx context.Context, challenge, salt string, difficulty int, expireAt int64) (int64, bool, error) {
+		n, ok := s.solveNative(ctx, "DeepSeekHashV1", challenge, salt, difficulty, expireAt, "", "")
+		return n, ok, nil
+	}); nerr != nil {
+		return fmt.Errorf("%w: wasm: %v; native: %v", ErrNoWorkingSolver, err, nerr)
+	}
+	return err
 }
 
 var keccakRC = [24]uint64{
@@ -74,6 +99,18 @@ var rho = [5][5]uint{ // x,y
 	{27, 20, 39, 8, 14},
 }
 
+// keccakPi and keccakRho flatten the pi lane permutation and rho rotations
+// to lane index x+5*y.
+var keccakPi, keccakRho = func() (pi [25]int, rot [25]int) {
+	for x := 0; x < 5; x++ {
+		for y := 0; y < 5; y++ {
+			pi[x+5*y] = y + 5*((2*x+3*y)%5)
+			rot[x+5*y] = int(rho[x][y])
+		}
+	}
+	return pi, rot
+}()
+
 func keccakF1600Rounds1To23(a *[25]uint64) {
 	for round := 1; round < 24; round++ {
 		var c [5]uint64
@@ -96,17 +133,17 @@ func keccakF1600Rounds1To23(a *[25]uint64) {
 		}
 
 		var b [25]uint64
-		for x := 0; x < 5; x++ {
-			for y := 0; y < 5; y++ {
-				b[y+5*((2*x+3*y)%5)] = bits.RotateLeft64(a[x+5*y], int(rho[x][y]))
-			}
+		for i := 0; i < 25; i++ {
+			b[keccakPi[i]] = bits.RotateLeft64(a[i], keccakRho[i])
 		}
 
-		for y := 0; y < 5; y++ {
-			y5 := 5 * y
-			for x := 0; x < 5; x++ {
-				a[x+y5] = b[x+y5] ^ ((^b[((x+1)%5)+y5]) & b[((x+2)%5)+y5])
-			}
+		for y := 0; y < 25; y += 5 {
+			b0, b1, b2, b3, b4 := b[y], b[y+1], b[y+2], b[y+3], b[y+4]
+			a[y] = b0 ^ (^b1 & b2)
+			a[y+1] = b1 ^ (^b2 & b3)
+			a[y+2] = b2 ^ (^b3 & b4)
+			a[y+3] = b3 ^ (^b4 & b0)
+			a[y+4] = b4 ^ (^b0 & b1)
 		}
 		a[0] ^= keccakRC[round]
 	}
@@ -166,152 +203,158 @@ func deepSeekHashV1(data []byte) [32]byte {
 	return out
 }
 
-func littleEndianInt(b [32]byte) *big.Int {
-	rev := make([]byte, 32)
-	for i := 0; i < 32; i++ {
-		rev[31-i] = b[i]
-	}
-	return new(big.Int).SetBytes(rev)
-}
-
-func targetFromDifficulty(diff int) *big.Int {
-	if diff <= 0 {
-		return nil
-	}
-	base := new(big.Int).Lsh(big.NewInt(1), 256)
-	return new(big.Int).Div(base, big.NewInt(int64(diff)))
-}
-
+// initWASM loads, verifies and instantiates the module once; a failure is
+// remembered so later solves go straight to the native solver.
 func (s *DeepSeekHashSolver) initWASM(ctx context.Context) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if s.inited {
-		return nil
+		return s.initErr
 	}
-	wasmBytes, err := os.ReadFile(s.wasmPath)
+	s.inited = true
+	s.initErr = s.loadPool(ctx)
+	return s.initErr
+}
+
+func (s *DeepSeekHashSolver) loadPool(ctx context.Context) error {
+	wasmBytes, source, digest, err := loadWASM(s.wasmPath, s.wasmSHA256)
+	s.wasmSource, s.wasmDigest = source, digest
 	if err != nil {
 		return err
 	}
-	r := wazero.NewRuntime(ctx)
-	mod, err := r.Instantiate(ctx, wasmBytes)
+	// Closing on context done lets a cancelled request abort a running solve;
+	// the pool replaces the closed instance.
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	compiled, err := r.CompileModule(ctx, wasmBytes)
+	if err == nil {
+		err = checkWASMExports(compiled)
+	}
 	if err != nil {
 		_ = r.Close(ctx)
 		return err
 	}
-	mem := mod.Memory()
-	if mem == nil {
-		_ = mod.Close(ctx)
-		_ = r.Close(ctx)
-		return errors.New("wasm memory export not found")
-	}
-	addStack := mod.ExportedFunction("__wbindgen_add_to_stack_pointer")
-	alloc := mod.ExportedFunction("__wbindgen_export_0")
-	wasmSolve := mod.ExportedFunction("wasm_solve")
-	if addStack == nil || alloc == nil || wasmSolve == nil {
-		_ = mod.Close(ctx)
+	pool, err := newWASMPool(ctx, r, compiled, s.poolSize)
+	if err != nil {
 		_ = r.Close(ctx)
-		return errors.New("required wasm exports not found")
+		return err
 	}
-	s.runtime = r
-	s.module = mod
-	s.memory = mem
-	s.addStack = addStack
-	s.alloc = alloc
-	s.wasmSolve = wasmSolve
-	s.inited = true
+	s.pool = pool
 	return nil
 }
 
-func (s *DeepSeekHashSolver) wasmEncodeString(ctx context.Context, text string) (uint32, uint32, error) {
-	b := []byte(text)
-	out, err := s.alloc.Call(ctx, uint64(len(b)), 1)
-	if err != nil || len(out) == 0 {
-		return 0, 0, errors.New("wasm alloc failed")
+// solveNative looks for the nonce below difficulty whose hash of
+// "<salt>_<expireAt>_<nonce>" equals the hex challenge, the same search the
+// WASM module runs. The range is split across s.workers goroutines, worker i
+// trying i, i+workers, i+2*workers and so on; it returns as soon as one of
+// them finds the nonce and gives up when ctx is done or the challenge expires.
+func (s *DeepSeekHashSolver) solveNative(ctx context.Context, algorithm, challenge, salt string, difficulty int, expireAt int64, signature, targetPath string) (int64, bool) {
+	if strings.TrimSpace(algorithm) != "DeepSeekHashV1" || difficulty <= 0 {
+		return 0, false
+	}
+	raw, err := hex.DecodeString(strings.TrimSpace(challenge))
+	if err != nil || len(raw) != 32 {
+		return 0, false
+	}
+	var target [32]byte
+	copy(target[:], raw)
+	prefix := []byte(fmt.Sprintf("%s_%d_", salt, expireAt))
+	workers := s.workers
+	if workers <= 0 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if expireAt > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithDeadline(ctx, time.Unix(expireAt, 0))
+		defer stop()
+	}
+
+	var found atomic.Int64
+	found.Store(-1)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(start int64) {
+			defer wg.Done()
+			buf := make([]byte, len(prefix), len(prefix)+20)
+			copy(buf, prefix)
+			for nonce, i := start, 0; nonce < int64(difficulty); nonce, i = nonce+int64(workers), i+1 {
+				if i%1024 == 0 && (ctx.Err() != nil || found.Load() >= 0) {
+					return
+				}
+				if deepSeekHashV1(strconv.AppendInt(buf, nonce, 10)) == target {
+					found.CompareAndSwap(-1, nonce)
+					cancel()
+					return
+				}
+			}
+		}(int64(w))
 	}
-	ptr := uint32(out[0])
-	if ok := s.memory.Write(ptr, b); !ok {
-		return 0, 0, errors.New("wasm memory write failed")
+	wg.Wait()
+	if n := found.Load(); n >= 0 {
+		return n, true
 	}
-	return ptr, uint32(len(b)), nil
+	return 0, false
 }
 
-func (s *DeepSeekHashSolver) solveNative(algorithm, challenge, salt string, difficulty int, expireAt int64, signature, targetPath string) (int64, bool) {
+func (s *DeepSeekHashSolver) solveWASM(ctx context.Context, algorithm, challenge, salt string, difficulty int, expireAt int64, signature, targetPath string) (int64, bool) {
 	if strings.TrimSpace(algorithm) != "DeepSeekHashV1" {
 		return 0, false
 	}
-	target := targetFromDifficulty(difficulty)
-	if target == nil {
-		return 0, false
+	if err := s.initWASM(context.Background()); err != nil {
+		return s.solveNative(ctx, algorithm, challenge, salt, difficulty, expireAt, signature, targetPath)
 	}
-	prefix := fmt.Sprintf("%s_%d_", salt, expireAt)
-	base := challenge + prefix
-	for nonce := int64(0); ; nonce++ {
-		if expireAt > 0 && time.Now().Unix() >= expireAt {
+	nonce, ok, err := s.solveWASMOnce(ctx, challenge, salt, difficulty, expireAt)
+	if err != nil {
+		if ctx.Err() != nil {
 			return 0, false
 		}
-		h := deepSeekHashV1([]byte(base + strconv.FormatInt(nonce, 10)))
-		if littleEndianInt(h).Cmp(target) < 0 {
-			return nonce, true
-		}
+		return s.solveNative(ctx, algorithm, challenge, salt, difficulty, expireAt, signature, targetPath)
 	}
+	return nonce, ok
 }
 
-func (s *DeepSeekHashSolver) solveWASM(algorithm, challenge, salt string, difficulty int, expireAt int64, signature, targetPath string) (int64, bool) {
-	ctx := context.Background()
-	if strings.TrimSpace(algorithm) != "DeepSeekHashV1" {
-		return 0, false
+// solveWASMOnce runs one solve on a pooled instance without any fallback.
+func (s *DeepSeekHashSolver) solveWASMOnce(ctx context.Context, challenge, salt string, difficulty int, expireAt int64) (int64, bool, error) {
+	inst, wait, err := s.pool.get(ctx)
+	if err != nil {
+		return 0, false, err
 	}
-	if err := s.initWASM(ctx); err != nil {
-		return s.solveNative(algorithm, challenge, salt, difficulty, expireAt, signature, targetPath)
+	start := time.Now()
+	nonce, ok, err := inst.solve(ctx, challenge, fmt.Sprintf("%s_%d_", salt, expireAt), difficulty)
+	s.pool.put(ctx, inst, wait, time.Since(start), err)
+	return nonce, ok, err
+}
+
+func (s *DeepSeekHashSolver) Solve(ctx context.Context, algorithm, challenge, salt string, difficulty int, expireAt int64, signature, targetPath string) (int64, bool) {
+	if s.mode == "native" || s.mode == "python" {
+		return s.solveNative(ctx, algorithm, challenge, salt, difficulty, expireAt, signature, targetPath)
 	}
+	return s.solveWASM(ctx, algorithm, challenge, salt, difficulty, expireAt, signature, targetPath)
+}
+
+// Status reports which solver is serving requests, where its WASM module
+// came from and, once initialised, the WASM pool.
+func (s *DeepSeekHashSolver) Status() map[string]any {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	stackDelta := int32(-16)
-	retPtrRaw, err := s.addStack.Call(ctx, uint64(uint32(stackDelta)))
-	if err != nil || len(retPtrRaw) == 0 {
-		return s.solveNative(algorithm, challenge, salt, difficulty, expireAt, signature, targetPath)
-	}
-	retPtr := uint32(retPtrRaw[0])
-	defer s.addStack.Call(ctx, 16)
-	prefix := fmt.Sprintf("%s_%d_", salt, expireAt)
-	pChallenge, lChallenge, err := s.wasmEncodeString(ctx, challenge)
-	if err != nil {
-		return s.solveNative(algorithm, challenge, salt, difficulty, expireAt, signature, targetPath)
+	active := "native"
+	if s.mode != "native" && s.mode != "python" && s.pool != nil {
+		active = "wasm"
 	}
-	pPrefix, lPrefix, err := s.wasmEncodeString(ctx, prefix)
-	if err != nil {
-		return s.solveNative(algorithm, challenge, salt, difficulty, expireAt, signature, targetPath)
+	out := map[string]any{"mode": s.mode, "active": active, "strict": s.strict, "native_workers": s.workers}
+	if s.wasmSource != "" {
+		out["wasm_source"] = s.wasmSource
+		out["wasm_sha256"] = s.wasmDigest
 	}
-	_, err = s.wasmSolve.Call(ctx,
-		uint64(retPtr),
-		uint64(pChallenge), uint64(lChallenge),
-		uint64(pPrefix), uint64(lPrefix),
-		math.Float64bits(float64(difficulty)),
-	)
-	if err != nil {
-		return s.solveNative(algorithm, challenge, salt, difficulty, expireAt, signature, targetPath)
+	if s.initErr != nil {
+		out["wasm_error"] = s.initErr.Error()
 	}
-	statusBytes, ok := s.memory.Read(retPtr, 4)
-	if !ok || len(statusBytes) != 4 {
-		return s.solveNative(algorithm, challenge, salt, difficulty, expireAt, signature, targetPath)
+	if s.pool != nil {
+		out["wasm_pool"] = s.pool.Status()
 	}
-	status := int32(binary.LittleEndian.Uint32(statusBytes))
-	if status == 0 {
-		return 0, false
-	}
-	valueBytes, ok := s.memory.Read(retPtr+8, 8)
-	if !ok || len(valueBytes) != 8 {
-		return s.solveNative(algorithm, challenge, salt, difficulty, expireAt, signature, targetPath)
-	}
-	nonceF := math.Float64frombits(binary.LittleEndian.Uint64(valueBytes))
-	return int64(nonceF), true
-}
-
-func (s *DeepSeekHashSolver) Solve(algorithm, challenge, salt string, difficulty int, expireAt int64, signature, targetPath string) (int64, bool) {
-	if s.mode == "native" || s.mode == "python" {
-		return s.solveNative(algorithm, challenge, salt, difficulty, expireAt, signature, targetPath)
-	}
-	return s.solveWASM(algorithm, challenge, salt, difficulty, expireAt, signature, targetPath)
+	return out
 }
 
 func HashKey(parts ...string) string {
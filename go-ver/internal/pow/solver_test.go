@@ -0,0 +1,185 @@
+package pow
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testExpireAt = 4102444800 // 2100-01-01, far enough to never expire
+
+// challengeFor builds the challenge upstream would send for answer.
+func challengeFor(salt string, expireAt, answer int64) string {
+	h := deepSeekHashV1([]byte(fmt.Sprintf("%s_%d_%d", salt, expireAt, answer)))
+	return hex.EncodeToString(h[:])
+}
+
+func TestSolveNative(t *testing.T) {
+	cases := []struct {
+		salt       string
+		answer     int64
+		difficulty int
+	}{
+		{"salt", 0, 1},
+		{"salt", 1, 144000},
+		{"s4lt", 1234, 144000},
+		{"f0e1d2c3", 99999, 144000},
+		{"x", 143999, 144000},
+	}
+	for _, tc := range cases {
+		challenge := challengeFor(tc.salt, testExpireAt, tc.answer)
+		for _, workers := range []int{1, 3, 8} {
+			s := &DeepSeekHashSolver{mode: "native", workers: workers}
+			got, ok := s.Solve(context.Background(), "DeepSeekHashV1", challenge, tc.salt, tc.difficulty, testExpireAt, "", "")
+			if !ok || got != tc.answer {
+				t.Fatalf("answer %d workers=%d: got %d,%v", tc.answer, workers, got, ok)
+			}
+		}
+	}
+}
+
+func TestSolveNativeRejects(t *testing.T) {
+	s := &DeepSeekHashSolver{mode: "native", workers: 4}
+	challenge := challengeFor("salt", testExpireAt, 500)
+	cases := []struct {
+		name       string
+		algorithm  string
+		challenge  string
+		difficulty int
+	}{
+		{"unknown algorithm", "OtherHash", challenge, 144000},
+		{"answer beyond difficulty", "DeepSeekHashV1", challenge, 500},
+		{"malformed challenge", "DeepSeekHashV1", "zz", 144000},
+		{"zero difficulty", "DeepSeekHashV1", challenge, 0},
+	}
+	for _, tc := range cases {
+		if n, ok := s.Solve(context.Background(), tc.algorithm, tc.challenge, "salt", tc.difficulty, testExpireAt, "", ""); ok {
+			t.Fatalf("%s: unexpectedly solved with %d", tc.name, n)
+		}
+	}
+}
+
+func TestSolveNativeStops(t *testing.T) {
+	s := &DeepSeekHashSolver{mode: "native", workers: 2}
+	unsolvable := challengeFor("other", testExpireAt, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, ok := s.Solve(ctx, "DeepSeekHashV1", unsolvable, "salt", 1<<40, testExpireAt, "", ""); ok {
+		t.Fatal("unsolvable challenge was solved")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("solve ignored cancellation for %v", elapsed)
+	}
+
+	start = time.Now()
+	if _, ok := s.Solve(context.Background(), "DeepSeekHashV1", unsolvable, "salt", 1<<40, time.Now().Add(50*time.Millisecond).Unix(), "", ""); ok {
+		t.Fatal("unsolvable challenge was solved")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("solve ignored expire_at for %v", elapsed)
+	}
+}
+
+func TestSolveWASMMatchesNative(t *testing.T) {
+	wasm := &DeepSeekHashSolver{mode: "wasm", stackResultSize: 16}
+	if err := wasm.Warmup(); err != nil {
+		t.Fatal(err)
+	}
+	native := &DeepSeekHashSolver{mode: "native", workers: 4}
+	for _, answer := range []int64{0, 1234, 143999} {
+		challenge := challengeFor("salt", testExpireAt, answer)
+		w, wok := wasm.Solve(context.Background(), "DeepSeekHashV1", challenge, "salt", 144000, testExpireAt, "", "")
+		n, nok := native.Solve(context.Background(), "DeepSeekHashV1", challenge, "salt", 144000, testExpireAt, "", "")
+		if !wok || !nok || w != answer || n != answer {
+			t.Fatalf("answer %d: wasm=%d,%v native=%d,%v", answer, w, wok, n, nok)
+		}
+	}
+}
+
+func benchmarkSolve(b *testing.B, s *DeepSeekHashSolver) {
+	challenge := challengeFor("salt", testExpireAt, 100000)
+	for i := 0; i < b.N; i++ {
+		if _, ok := s.Solve(context.Background(), "DeepSeekHashV1", challenge, "salt", 144000, testExpireAt, "", ""); !ok {
+			b.Fatal("no solution")
+		}
+	}
+}
+
+func BenchmarkSolveNative1(b *testing.B) {
+	benchmarkSolve(b, &DeepSeekHashSolver{mode: "native", workers: 1})
+}
+
+func BenchmarkSolveNativeAllCores(b *testing.B) {
+	benchmarkSolve(b, &DeepSeekHashSolver{mode: "native", workers: runtime.NumCPU()})
+}
+
+func BenchmarkSolveWASM(b *testing.B) {
+	s := &DeepSeekHashSolver{mode: "wasm", stackResultSize: 16}
+	if err := s.Warmup(); err != nil {
+		b.Fatal(err)
+	}
+	benchmarkSolve(b, s)
+}
+
+func TestWASMPoolConcurrentSolves(t *testing.T) {
+	s := &DeepSeekHashSolver{mode: "wasm", poolSize: 3, stackResultSize: 16}
+	if err := s.Warmup(); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(answer int64) {
+			defer wg.Done()
+			got, ok := s.Solve(context.Background(), "DeepSeekHashV1", challengeFor("salt", testExpireAt, answer), "salt", 144000, testExpireAt, "", "")
+			if !ok || got != answer {
+				errs <- fmt.Errorf("answer %d: got %d,%v", answer, got, ok)
+			}
+		}(int64(i * 1000))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	st := s.pool.Status()
+	if st["solves"].(int64) != 8 || st["idle"].(int) != 3 || st["live"].(int) != 3 {
+		t.Fatalf("status = %v", st)
+	}
+}
+
+func TestWASMPoolReplacesBrokenInstance(t *testing.T) {
+	s := &DeepSeekHashSolver{mode: "wasm", workers: 1, poolSize: 1, stackResultSize: 16}
+	if err := s.Warmup(); err != nil {
+		t.Fatal(err)
+	}
+	inst := <-s.pool.idle
+	_ = inst.module.Close(context.Background())
+	s.pool.idle <- inst
+
+	challenge := challengeFor("salt", testExpireAt, 42)
+	for i := 0; i < 2; i++ {
+		got, ok := s.Solve(context.Background(), "DeepSeekHashV1", challenge, "salt", 144000, testExpireAt, "", "")
+		if !ok || got != 42 {
+			t.Fatalf("solve %d: got %d,%v", i, got, ok)
+		}
+	}
+	st := s.pool.Status()
+	if st["traps"].(int64) != 1 || st["live"].(int) != 1 || st["idle"].(int) != 1 {
+		t.Fatalf("status = %v", st)
+	}
+
+	<-s.pool.idle
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, ok := s.Solve(ctx, "DeepSeekHashV1", challenge, "salt", 144000, testExpireAt, "", ""); ok {
+		t.Fatal("solve should give up waiting for an instance when ctx is done")
+	}
+}
@@ -0,0 +1,92 @@
+package pow
+
+import (
+	"context"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/tetratelabs/wazero"
+)
+
+//go:embed sha3_wasm_bg.7b9ca65ddd.wasm
+var embeddedWASM []byte
+
+const embeddedWASMSHA256 = "b3fca8cc072c1defbd60c02266a8e48bd307a1804aaff4314900aea720e72f7d"
+
+var ErrNoWorkingSolver = errors.New("no working pow solver")
+
+var requiredWASMExports = map[string]int{
+	"__wbindgen_add_to_stack_pointer": 1,
+	"__wbindgen_export_0":             2,
+	"wasm_solve":                      6,
+}
+
+// loadWASM returns the module bytes with their source and SHA-256. The
+// embedded module must match its pinned digest; a POW_WASM_PATH override is
+// only checked when an expected digest is configured.
+func loadWASM(path, wantSHA256 string) ([]byte, string, string, error) {
+	source := "embedded"
+	b := embeddedWASM
+	want := embeddedWASMSHA256
+	if path != "" {
+		var err error
+		if b, err = os.ReadFile(path); err != nil {
+			return nil, path, "", err
+		}
+		source, want = path, wantSHA256
+	}
+	sum := sha256.Sum256(b)
+	digest := hex.EncodeToString(sum[:])
+	if want != "" && !strings.EqualFold(digest, want) {
+		return nil, source, digest, fmt.Errorf("wasm sha256 mismatch: got %s want %s", digest, want)
+	}
+	return b, source, digest, nil
+}
+
+func checkWASMExports(compiled wazero.CompiledModule) error {
+	funcs := compiled.ExportedFunctions()
+	var missing []string
+	for name, params := range requiredWASMExports {
+		f, ok := funcs[name]
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		if len(f.ParamTypes()) != params {
+			return fmt.Errorf("wasm export %s takes %d params, want %d", name, len(f.ParamTypes()), params)
+		}
+	}
+	if len(compiled.ExportedMemories()) == 0 {
+		missing = append(missing, "memory")
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("required wasm exports not found: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// selfTest solves a challenge built from a known answer.
+func selfTest(solve func(ctx context.Context, challenge, salt string, difficulty int, expireAt int64) (int64, bool, error)) error {
+	const (
+		salt       = "warmup"
+		expireAt   = 4102444800
+		answer     = 1234
+		difficulty = 2000
+	)
+	h := deepSeekHashV1([]byte(fmt.Sprintf("%s_%d_%d", salt, expireAt, answer)))
+	got, ok, err := solve(context.Background(), hex.EncodeToString(h[:]), salt, difficulty, expireAt)
+	if err != nil {
+		return err
+	}
+	if !ok || got != answer {
+		return fmt.Errorf("self-test returned %d,%v want %d", got, ok, answer)
+	}
+	return nil
+}
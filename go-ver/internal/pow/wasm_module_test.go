@@ -0,0 +1,100 @@
+package pow
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tetratelabs/wazero"
+)
+
+func TestLoadWASM(t *testing.T) {
+	dir := t.TempDir()
+	copyPath := filepath.Join(dir, "copy.wasm")
+	if err := os.WriteFile(copyPath, embeddedWASM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name    string
+		path    string
+		digest  string
+		source  string
+		wantErr string
+	}{
+		{name: "embedded", source: "embedded"},
+		{name: "override without digest", path: copyPath, source: copyPath},
+		{name: "override with digest", path: copyPath, digest: strings.ToUpper(embeddedWASMSHA256), source: copyPath},
+		{name: "override digest mismatch", path: copyPath, digest: strings.Repeat("0", 64), source: copyPath, wantErr: "sha256 mismatch"},
+		{name: "missing file", path: filepath.Join(dir, "nope.wasm"), source: filepath.Join(dir, "nope.wasm"), wantErr: "no such file"},
+	}
+	for _, tc := range cases {
+		_, source, digest, err := loadWASM(tc.path, tc.digest)
+		if source != tc.source {
+			t.Fatalf("%s: source = %q", tc.name, source)
+		}
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("%s: err = %v, want %q", tc.name, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil || digest != embeddedWASMSHA256 {
+			t.Fatalf("%s: digest=%s err=%v", tc.name, digest, err)
+		}
+	}
+}
+
+func TestCheckWASMExports(t *testing.T) {
+	ctx := context.Background()
+	r := wazero.NewRuntime(ctx)
+	defer r.Close(ctx)
+	compiled, err := r.CompileModule(ctx, embeddedWASM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkWASMExports(compiled); err != nil {
+		t.Fatalf("embedded module: %v", err)
+	}
+	// The smallest valid module: magic and version, nothing exported.
+	empty, err := r.CompileModule(ctx, []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = checkWASMExports(empty)
+	if err == nil || !strings.Contains(err.Error(), "__wbindgen_add_to_stack_pointer, __wbindgen_export_0, memory, wasm_solve") {
+		t.Fatalf("empty module: %v", err)
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	bad := filepath.Join(t.TempDir(), "bad.wasm")
+	if err := os.WriteFile(bad, []byte("not wasm"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name    string
+		solver  *DeepSeekHashSolver
+		wantErr bool
+		active  string
+	}{
+		{"embedded strict", &DeepSeekHashSolver{mode: "wasm", workers: 1, strict: true}, false, "wasm"},
+		{"native strict", &DeepSeekHashSolver{mode: "native", workers: 1, strict: true}, false, "native"},
+		{"broken override falls back", &DeepSeekHashSolver{mode: "wasm", workers: 1, wasmPath: bad}, true, "native"},
+		{"broken override strict", &DeepSeekHashSolver{mode: "wasm", workers: 1, wasmPath: bad, strict: true}, true, "native"},
+	}
+	for _, tc := range cases {
+		err := tc.solver.Warmup()
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("%s: err = %v", tc.name, err)
+		}
+		if errors.Is(err, ErrNoWorkingSolver) {
+			t.Fatalf("%s: native still works, got %v", tc.name, err)
+		}
+		if got := tc.solver.Status()["active"]; got != tc.active {
+			t.Fatalf("%s: active = %v want %s", tc.name, got, tc.active)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package pow
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+var errWASMPoolEmpty = errors.New("wasm pool has no instances")
+
+type wasmInstance struct {
+	module    api.Module
+	memory    api.Memory
+	addStack  api.Function
+	alloc     api.Function
+	wasmSolve api.Function
+}
+
+func instantiateWASM(ctx context.Context, r wazero.Runtime, compiled wazero.CompiledModule) (*wasmInstance, error) {
+	// An empty name lets the same compiled module be instantiated many times.
+	mod, err := r.InstantiateModule(ctx, compiled, wazero.NewModuleConfig().WithName(""))
+	if err != nil {
+		return nil, err
+	}
+	inst := &wasmInstance{
+		module:    mod,
+		memory:    mod.Memory(),
+		addStack:  mod.ExportedFunction("__wbindgen_add_to_stack_pointer"),
+		alloc:     mod.ExportedFunction("__wbindgen_export_0"),
+		wasmSolve: mod.ExportedFunction("wasm_solve"),
+	}
+	if inst.memory == nil {
+		_ = mod.Close(ctx)
+		return nil, errors.New("wasm memory export not found")
+	}
+	if inst.addStack == nil || inst.alloc == nil || inst.wasmSolve == nil {
+		_ = mod.Close(ctx)
+		return nil, errors.New("required wasm exports not found")
+	}
+	return inst, nil
+}
+
+func (w *wasmInstance) encodeString(ctx context.Context, text string) (uint32, uint32, error) {
+	b := []byte(text)
+	out, err := w.alloc.Call(ctx, uint64(len(b)), 1)
+	if err != nil || len(out) == 0 {
+		return 0, 0, errors.New("wasm alloc failed")
+	}
+	ptr := uint32(out[0])
+	if ok := w.memory.Write(ptr, b); !ok {
+		return 0, 0, errors.New("wasm memory write failed")
+	}
+	return ptr, uint32(len(b)), nil
+}
+
+// solve runs wasm_solve once. A non-nil error means the instance can no
+// longer be trusted and must be replaced.
+func (w *wasmInstance) solve(ctx context.Context, challenge, prefix string, difficulty int) (int64, bool, error) {
+	stackDelta := int32(-16)
+	retPtrRaw, err := w.addStack.Call(ctx, uint64(uint32(stackDelta)))
+	if err != nil || len(retPtrRaw) == 0 {
+		return 0, false, errors.New("wasm stack alloc failed")
+	}
+	retPtr := uint32(retPtrRaw[0])
+	defer w.addStack.Call(ctx, 16)
+	pChallenge, lChallenge, err := w.encodeString(ctx, challenge)
+	if err != nil {
+		return 0, false, err
+	}
+	pPrefix, lPrefix, err := w.encodeString(ctx, prefix)
+	if err != nil {
+		return 0, false, err
+	}
+	_, err = w.wasmSolve.Call(ctx,
+		uint64(retPtr),
+		uint64(pChallenge), uint64(lChallenge),
+		uint64(pPrefix), uint64(lPrefix),
+		math.Float64bits(float64(difficulty)),
+	)
+	if err != nil {
+		return 0, false, err
+	}
+	statusBytes, ok := w.memory.Read(retPtr, 4)
+	if !ok || len(statusBytes) != 4 {
+		return 0, false, errors.New("wasm status read failed")
+	}
+	if int32(binary.LittleEndian.Uint32(statusBytes)) == 0 {
+		return 0, false, nil
+	}
+	valueBytes, ok := w.memory.Read(retPtr+8, 8)
+	if !ok || len(valueBytes) != 8 {
+		return 0, false, errors.New("wasm result read failed")
+	}
+	return int64(math.Float64frombits(binary.LittleEndian.Uint64(valueBytes))), true, nil
+}
+
+// wasmPool hands out independently instantiated copies of one compiled
+// module so solves run concurrently. An instance whose call fails (a trap, or
+// a cancelled context closing it) is replaced with a fresh one.
+type wasmPool struct {
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	idle     chan *wasmInstance
+
+	mu         sync.Mutex
+	live       int
+	size       int
+	solves     int64
+	traps      int64
+	cancelled  int64
+	waitTotal  time.Duration
+	waitMax    time.Duration
+	solveTotal time.Duration
+}
+
+func newWASMPool(ctx context.Context, r wazero.Runtime, compiled wazero.CompiledModule, size int) (*wasmPool, error) {
+	if size <= 0 {
+		size = 1
+	}
+	p := &wasmPool{runtime: r, compiled: compiled, idle: make(chan *wasmInstance, size), size: size}
+	for i := 0; i < size; i++ {
+		inst, err := instantiateWASM(ctx, r, compiled)
+		if err != nil {
+			return nil, err
+		}
+		p.idle <- inst
+		p.live++
+	}
+	return p, nil
+}
+
+func (p *wasmPool) get(ctx context.Context) (*wasmInstance, time.Duration, error) {
+	start := time.Now()
+	select {
+	case inst := <-p.idle:
+		return inst, time.Since(start), nil
+	default:
+	}
+	p.mu.Lock()
+	live := p.live
+	p.mu.Unlock()
+	if live == 0 {
+		return nil, 0, errWASMPoolEmpty
+	}
+	select {
+	case inst := <-p.idle:
+		return inst, time.Since(start), nil
+	case <-ctx.Done():
+		return nil, time.Since(start), ctx.Err()
+	}
+}
+
+// put returns inst after a solve. When the solve failed the instance is
+// closed and re-instantiated; if that fails too the pool shrinks by one.
+func (p *wasmPool) put(ctx context.Context, inst *wasmInstance, wait, took time.Duration, solveErr error) {
+	p.mu.Lock()
+	p.solves++
+	p.waitTotal += wait
+	if wait > p.waitMax {
+		p.waitMax = wait
+	}
+	p.solveTotal += took
+	if solveErr != nil {
+		if ctx.Err() != nil {
+			p.cancelled++
+		} else {
+			p.traps++
+		}
+	}
+	p.mu.Unlock()
+	if solveErr == nil {
+		p.idle <- inst
+		return
+	}
+	_ = inst.module.Close(context.Background())
+	fresh, err := instantiateWASM(context.Background(), p.runtime, p.compiled)
+	if err != nil {
+		p.mu.Lock()
+		p.live--
+		p.mu.Unlock()
+		return
+	}
+	p.idle <- fresh
+}
+
+func (p *wasmPool) Status() map[string]any {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var avgWait, avgSolve float64
+	if p.solves > 0 {
+		avgWait = float64(p.waitTotal.Microseconds()) / 1000 / float64(p.solves)
+		avgSolve = float64(p.solveTotal.Microseconds()) / 1000 / float64(p.solves)
+	}
+	return map[string]any{
+		"size":         p.size,
+		"live":         p.live,
+		"idle":         len(p.idle),
+		"solves":       p.solves,
+		"traps":        p.traps,
+		"cancelled":    p.cancelled,
+		"avg_wait_ms":  avgWait,
+		"max_wait_ms":  float64(p.waitMax.Microseconds()) / 1000,
+		"avg_solve_ms": avgSolve,
+	}
+}
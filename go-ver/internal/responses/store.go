@@ -0,0 +1,83 @@
+package responses
+
+import (
+	"sync"
+	"time"
+)
+
+type Entry struct {
+	Owner    string
+	Response map[string]any
+	Messages []map[string]any
+	ExpireAt int64
+}
+
+type Store struct {
+	mu         sync.Mutex
+	entries    map[string]Entry
+	order      []string
+	maxEntries int
+	ttl        time.Duration
+}
+
+func NewStore(maxEntries int, ttl time.Duration) *Store {
+	return &Store{entries: map[string]Entry{}, maxEntries: maxEntries, ttl: ttl}
+}
+
+func (s *Store) Get(id string) (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[id]
+	if !ok {
+		return Entry{}, false
+	}
+	if e.ExpireAt > 0 && time.Now().Unix() >= e.ExpireAt {
+		delete(s.entries, id)
+		return Entry{}, false
+	}
+	return e, true
+}
+
+func (s *Store) Put(id string, e Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ttl > 0 {
+		e.ExpireAt = time.Now().Add(s.ttl).Unix()
+	}
+	if _, exists := s.entries[id]; !exists {
+		s.order = append(s.order, id)
+	}
+	s.entries[id] = e
+	s.evictLocked()
+}
+
+func (s *Store) Delete(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[id]; !ok {
+		return false
+	}
+	delete(s.entries, id)
+	return true
+}
+
+func (s *Store) evictLocked() {
+	now := time.Now().Unix()
+	kept := s.order[:0]
+	for _, id := range s.order {
+		e, ok := s.entries[id]
+		if !ok {
+			continue
+		}
+		if e.ExpireAt > 0 && now >= e.ExpireAt {
+			delete(s.entries, id)
+			continue
+		}
+		kept = append(kept, id)
+	}
+	s.order = kept
+	for s.maxEntries > 0 && len(s.order) > s.maxEntries {
+		delete(s.entries, s.order[0])
+		s.order = s.order[1:]
+	}
+}
@@ -0,0 +1,38 @@
+package responses
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStoreEvictsOldest(t *testing.T) {
+	s := NewStore(2, time.Hour)
+	for i := 0; i < 3; i++ {
+		s.Put(fmt.Sprintf("resp_%d", i), Entry{Owner: "k"})
+	}
+	if _, ok := s.Get("resp_0"); ok {
+		t.Fatal("expected oldest entry to be evicted")
+	}
+	for _, id := range []string{"resp_1", "resp_2"} {
+		if e, ok := s.Get(id); !ok || e.Owner != "k" {
+			t.Fatalf("expected %s to be stored", id)
+		}
+	}
+	if !s.Delete("resp_1") || s.Delete("resp_1") {
+		t.Fatal("unexpected delete result")
+	}
+}
+
+func TestStoreExpires(t *testing.T) {
+	s := NewStore(0, time.Hour)
+	s.Put("resp_1", Entry{})
+	s.mu.Lock()
+	e := s.entries["resp_1"]
+	e.ExpireAt = time.Now().Add(-time.Second).Unix()
+	s.entries["resp_1"] = e
+	s.mu.Unlock()
+	if _, ok := s.Get("resp_1"); ok {
+		t.Fatal("expected expired entry to be dropped")
+	}
+}
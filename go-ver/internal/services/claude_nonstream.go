@@ -13,10 +13,25 @@ import (
 	"deepseek2api-go/internal/clients"
 )
 
-func ClaudeNonStream(ctx context.Context, ds *clients.DeepSeekClient, headers map[string]string, payload map[string]any, model string, normalizedMessages []map[string]any, toolsRequested []map[string]any) (int, map[string]any) {
+type ClaudeOptions struct {
+	Repair     ToolRepair
+	ToolChoice ToolChoice
+	Limits     OutputLimits
+	OnReply    func(messageID int, reply map[string]any)
+	Replay     Replay
+}
+
+func ClaudeNonStream(ctx context.Context, ds *clients.DeepSeekClient, headers map[string]string, payload map[string]any, model string, normalizedMessages []map[string]any, toolsRequested []map[string]any, opts ClaudeOptions) (int, map[string]any) {
+	var followed *bool
+	opts.Repair, followed = trackFollowups(opts.Repair)
 	for attempt := 0; attempt <= maxRetries; attempt++ {
-		resp, err := ds.CompletionRawStreamRequest(ctx, headers, payload)
+		if attempt > 0 {
+			headers, payload = replayOnRetry(headers, payload, &opts.Replay)
+		}
+		streamCtx, cancel := context.WithCancel(ctx)
+		resp, err := ds.CompletionRawStreamRequest(streamCtx, headers, payload)
 		if err != nil {
+			cancel()
 			if attempt < maxRetries {
 				time.Sleep(retryDelaySeconds * time.Duration(attempt+1))
 				continue
@@ -27,8 +42,11 @@ func ClaudeNonStream(ctx context.Context, ds *clients.DeepSeekClient, headers ma
 		finalContent := ""
 		finalReasoning := ""
 		sawSSEData := false
+		messageID := 0
+		limiter := newOutputLimiter(opts.Limits)
 
 		func() {
+			defer cancel()
 			defer resp.Body.Close()
 			scanner := bufio.NewScanner(resp.Body)
 			scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
@@ -40,28 +58,32 @@ func ClaudeNonStream(ctx context.Context, ds *clients.DeepSeekClient, headers ma
 				if json.Unmarshal([]byte(data), &chunk) != nil {
 					return true
 				}
+				if id, ok := responseMessageID(chunk); ok {
+					messageID = id
+				}
 				var segs []segment
 				ptype, segs, finished = parseChunk(chunk, ptype)
 				for _, seg := range segs {
 					if seg.Type == "thinking" {
-						finalReasoning += seg.Text
+						finalReasoning += limiter.Thinking(seg.Text)
 					} else {
-						finalContent += seg.Text
+						finalContent += limiter.Feed(seg.Text)
 					}
 				}
-				return !finished
+				return !finished && !limiter.Done()
 			})
 		}()
+		finalContent += limiter.Flush()
 
 		if !sawSSEData {
 			if body, jerr := ds.CompletionJSONRequest(ctx, headers, payload); jerr == nil {
 				jThinking, jText, ok := extractCompletionFromJSON(body)
 				if ok {
-					finalContent = jText
-					finalReasoning = jThinking
+					finalReasoning = limiter.Thinking(jThinking)
+					finalContent = limiter.Feed(jText) + limiter.Flush()
 				}
 			}
-			if finalContent == "" && finalReasoning == "" {
+			if finalContent == "" && finalReasoning == "" && !limiter.Done() {
 				if attempt < maxRetries {
 					time.Sleep(retryDelaySeconds * time.Duration(attempt+1))
 					continue
@@ -69,20 +91,30 @@ func ClaudeNonStream(ctx context.Context, ds *clients.DeepSeekClient, headers ma
 				return http.StatusBadGateway, map[string]any{"error": map[string]any{"type": "api_error", "message": "Invalid upstream stream."}}
 			}
 		}
-		if finalContent == "" && finalReasoning == "" && attempt < maxRetries {
+		if finalContent == "" && finalReasoning == "" && !limiter.Done() && attempt < maxRetries {
 			time.Sleep(retryDelaySeconds * time.Duration(attempt+1))
 			continue
 		}
 
-		detected := DetectToolCalls(finalContent, toolsRequested)
+		detected, prose := resolveToolCalls(ctx, ds, headers, payload, finalContent, toolsRequested, opts.Repair, messageID)
+		if len(detected) == 0 && opts.ToolChoice.Mode == "required" && len(toolsRequested) > 0 {
+			forced, errs, ferr := forceToolCall(ctx, ds, headers, payload, toolsRequested, opts, messageID, finalContent)
+			if ferr != nil {
+				return http.StatusBadGateway, map[string]any{"error": map[string]any{"type": "api_error", "message": forcedToolCallError(errs)}}
+			}
+			detected, prose = forced, ""
+		}
+		if !opts.ToolChoice.Parallel && len(detected) > 1 {
+			detected = detected[:1]
+		}
 		out := map[string]any{
 			"id":            "msg_" + strconvI64(time.Now().Unix()) + "_" + strconvI(rand.Intn(9000)+1000),
 			"type":          "message",
 			"role":          "assistant",
 			"model":         model,
 			"content":       []map[string]any{},
-			"stop_reason":   ternary(len(detected) > 0, "tool_use", "end_turn"),
-			"stop_sequence": nil,
+			"stop_reason":   claudeStopReason(len(detected) > 0, limiter),
+			"stop_sequence": claudeStopSequence(len(detected) > 0, limiter),
 			"usage":         map[string]any{"input_tokens": len(toJSON(normalizedMessages)) / 4, "output_tokens": (len(finalContent) + len(finalReasoning)) / 4},
 		}
 		content := out["content"].([]map[string]any)
@@ -90,49 +122,59 @@ func ClaudeNonStream(ctx context.Context, ds *clients.DeepSeekClient, headers ma
 			content = append(content, map[string]any{"type": "thinking", "thinking": finalReasoning})
 		}
 		if len(detected) > 0 {
+			if prose != "" {
+				content = append(content, map[string]any{"type": "text", "text": prose})
+			}
 			for i, t := range detected {
 				content = append(content, map[string]any{"type": "tool_use", "id": "toolu_" + strconvI(i+1) + "_" + strconvI(rand.Intn(9000)+1000), "name": t["name"], "input": t["input"]})
 			}
 		} else {
 			if finalContent != "" || finalReasoning == "" {
-				content = append(content, map[string]any{"type": "text", "text": firstNonEmpty(finalContent, "抱歉，没有生成有效的响应内容。")})
+				text := finalContent
+				if !limiter.Done() {
+					text = firstNonEmpty(finalContent, "抱歉，没有生成有效的响应内容。")
+				}
+				content = append(content, map[string]any{"type": "text", "text": text})
 			}
 		}
 		out["content"] = content
+		reportReply(opts.OnReply, messageID, *followed, map[string]any{"role": "assistant", "content": claudeReplyBlocks(content)})
 		return http.StatusOK, out
 	}
 	return http.StatusBadGateway, map[string]any{"error": map[string]any{"type": "api_error", "message": "Upstream DeepSeek completion failed."}}
 }
 
-func DetectToolCalls(text string, tools []map[string]any) []map[string]any {
-	clean := strings.TrimSpace(text)
-	if !strings.HasPrefix(clean, "{\"tool_calls\":") || !strings.HasSuffix(clean, "]}") {
-		return nil
+func claudeStopReason(toolUse bool, limiter *outputLimiter) string {
+	switch {
+	case toolUse:
+		return "tool_use"
+	case limiter.reason != "":
+		return limiter.reason
 	}
-	var body map[string]any
-	if json.Unmarshal([]byte(clean), &body) != nil {
+	return "end_turn"
+}
+
+func claudeStopSequence(toolUse bool, limiter *outputLimiter) any {
+	if toolUse || limiter.reason != limitStop {
 		return nil
 	}
-	arr, _ := body["tool_calls"].([]any)
-	allowed := map[string]bool{}
-	for _, t := range tools {
-		if n, ok := t["name"].(string); ok {
-			allowed[n] = true
-		}
-	}
-	out := make([]map[string]any, 0)
-	for _, it := range arr {
-		m, _ := it.(map[string]any)
-		n, _ := m["name"].(string)
-		if !allowed[n] {
-			continue
+	return limiter.matched
+}
+
+func claudeReplyBlocks(content []map[string]any) []map[string]any {
+	out := make([]map[string]any, 0, len(content))
+	for _, b := range content {
+		if b["type"] != "thinking" {
+			out = append(out, b)
 		}
-		inp, _ := m["input"].(map[string]any)
-		out = append(out, map[string]any{"name": n, "input": inp})
 	}
 	return out
 }
 
+func DetectToolCalls(text string, tools []map[string]any) []map[string]any {
+	return ExtractToolCalls(text, tools).Calls
+}
+
 func toJSON(v any) string { b, _ := json.Marshal(v); return string(b) }
 func firstNonEmpty(a, b string) string {
 	if strings.TrimSpace(a) != "" {
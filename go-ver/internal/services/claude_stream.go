@@ -12,31 +12,161 @@ import (
 	"deepseek2api-go/internal/clients"
 )
 
-func ClaudeStream(ctx context.Context, w http.ResponseWriter, ds *clients.DeepSeekClient, headers map[string]string, payload map[string]any, model string, messages []map[string]any, toolsRequested []map[string]any) {
+type claudeEmitter struct {
+	w            http.ResponseWriter
+	flusher      http.Flusher
+	index        int
+	open         string
+	outputTokens int
+	toolCalls    int
+	reply        []map[string]any
+}
+
+func (e *claudeEmitter) send(event map[string]any) {
+	b, _ := json.Marshal(event)
+	_, _ = fmt.Fprintf(e.w, "data: %s\n\n", string(b))
+	if e.flusher != nil {
+		e.flusher.Flush()
+	}
+}
+
+func (e *claudeEmitter) startBlock(typ string, block map[string]any) {
+	e.stopBlock()
+	e.send(map[string]any{"type": "content_block_start", "index": e.index, "content_block": block})
+	e.open = typ
+}
+
+func (e *claudeEmitter) stopBlock() {
+	if e.open == "" {
+		return
+	}
+	e.send(map[string]any{"type": "content_block_stop", "index": e.index})
+	e.open = ""
+	e.index++
+}
+
+func (e *claudeEmitter) thinking(text string) {
+	if text == "" {
+		return
+	}
+	if e.open != "thinking" {
+		e.startBlock("thinking", map[string]any{"type": "thinking", "thinking": ""})
+	}
+	e.send(map[string]any{"type": "content_block_delta", "index": e.index, "delta": map[string]any{"type": "thinking_delta", "thinking": text}})
+	e.outputTokens += len(text) / 4
+}
+
+func (e *claudeEmitter) text(text string) {
+	if text == "" {
+		return
+	}
+	if e.open != "text" {
+		e.startBlock("text", map[string]any{"type": "text", "text": ""})
+	}
+	e.send(map[string]any{"type": "content_block_delta", "index": e.index, "delta": map[string]any{"type": "text_delta", "text": text}})
+	e.outputTokens += len(text) / 4
+	if n := len(e.reply); n > 0 && e.reply[n-1]["type"] == "text" {
+		e.reply[n-1]["text"] = e.reply[n-1]["text"].(string) + text
+	} else {
+		e.reply = append(e.reply, map[string]any{"type": "text", "text": text})
+	}
+}
+
+func (e *claudeEmitter) toolEvents(events []ToolStreamEvent) {
+	for _, ev := range events {
+		switch ev.Kind {
+		case "text":
+			e.text(ev.Text)
+		case "tool_start":
+			id := newToolUseID(e.index)
+			e.startBlock("tool_use", map[string]any{"type": "tool_use", "id": id, "name": ev.Name, "input": map[string]any{}})
+			e.reply = append(e.reply, map[string]any{"type": "tool_use", "id": id, "name": ev.Name, "input": map[string]any{}})
+		case "tool_delta":
+			e.send(map[string]any{"type": "content_block_delta", "index": e.index, "delta": map[string]any{"type": "input_json_delta", "partial_json": ev.PartialJSON}})
+			e.outputTokens += len(ev.PartialJSON) / 4
+		case "tool_stop":
+			e.stopBlock()
+			if n := len(e.reply); n > 0 && ev.Input != nil {
+				e.reply[n-1]["input"] = ev.Input
+			}
+			e.toolCalls++
+		}
+	}
+}
+
+func newToolUseID(index int) string {
+	return fmt.Sprintf("toolu_%d_%d_%d", time.Now().Unix(), rand.Intn(9000)+1000, index)
+}
+
+func (e *claudeEmitter) finish(stopReason string, stopSequence any) {
+	e.stopBlock()
+	e.send(map[string]any{"type": "message_delta", "delta": map[string]any{"stop_reason": stopReason, "stop_sequence": stopSequence}, "usage": map[string]any{"output_tokens": e.outputTokens}})
+	e.send(map[string]any{"type": "message_stop"})
+}
+
+func (e *claudeEmitter) fail(message string) {
+	e.send(map[string]any{"type": "error", "error": map[string]any{"type": "api_error", "message": message}})
+}
+
+func ClaudeStream(ctx context.Context, w http.ResponseWriter, ds *clients.DeepSeekClient, headers map[string]string, payload map[string]any, model string, messages []map[string]any, toolsRequested []map[string]any, opts ClaudeOptions) {
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.WriteHeader(http.StatusOK)
 	flusher, _ := w.(http.Flusher)
+	em := &claudeEmitter{w: w, flusher: flusher}
+	started := false
+	var followed *bool
+	opts.Repair, followed = trackFollowups(opts.Repair)
 
 	for attempt := 0; attempt <= maxRetries; attempt++ {
-		resp, err := ds.CompletionRawStreamRequest(ctx, headers, payload)
+		if attempt > 0 {
+			headers, payload = replayOnRetry(headers, payload, &opts.Replay)
+		}
+		streamCtx, cancel := context.WithCancel(ctx)
+		resp, err := ds.CompletionRawStreamRequest(streamCtx, headers, payload)
 		if err != nil {
+			cancel()
 			if attempt < maxRetries {
 				time.Sleep(retryDelaySeconds * time.Duration(attempt+1))
 				continue
 			}
-			errEvent := map[string]any{"type": "error", "error": map[string]any{"type": "api_error", "message": "Stream processing error: " + err.Error()}}
-			b, _ := json.Marshal(errEvent)
-			_, _ = fmt.Fprintf(w, "data: %s\n\n", string(b))
-			if flusher != nil {
-				flusher.Flush()
-			}
+			em.fail("Stream processing error: " + err.Error())
 			return
 		}
 
-		finalText := ""
-		finalThinking := ""
+		if !started {
+			messageID := fmt.Sprintf("msg_%d_%d", time.Now().Unix(), rand.Intn(9000)+1000)
+			inputTokens := len(toJSON(messages)) / 4
+			em.send(map[string]any{"type": "message_start", "message": map[string]any{"id": messageID, "type": "message", "role": "assistant", "model": model, "content": []any{}, "stop_reason": nil, "stop_sequence": nil, "usage": map[string]any{"input_tokens": inputTokens, "output_tokens": 0}}})
+			started = true
+		}
+
 		sawSSEData := false
+		emitted := false
+		messageID := 0
+		limiter := newOutputLimiter(opts.Limits)
+		var parser *ToolCallStreamParser
+		gate := newToolGate(toolsRequested, opts.Repair)
+		forced := opts.ToolChoice.Mode == "required" && len(toolsRequested) > 0
+		hold := &textHold{on: forced}
+		if len(toolsRequested) > 0 {
+			maxCalls := 0
+			if !opts.ToolChoice.Parallel {
+				maxCalls = 1
+			}
+			parser = NewToolCallStreamParser(toolsRequested, maxCalls)
+		}
+		emitText := func(text string) {
+			if text == "" {
+				return
+			}
+			if parser != nil {
+				em.toolEvents(hold.filter(gate.filter(parser.Feed(text))))
+				return
+			}
+			em.text(text)
+		}
 		func() {
+			defer cancel()
 			defer resp.Body.Close()
 			scanner := bufio.NewScanner(resp.Body)
 			scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
@@ -48,106 +178,73 @@ func ClaudeStream(ctx context.Context, w http.ResponseWriter, ds *clients.DeepSe
 				if json.Unmarshal([]byte(data), &chunk) != nil {
 					return true
 				}
+				if id, ok := responseMessageID(chunk); ok {
+					messageID = id
+				}
 				var segs []segment
 				ptype, segs, finished = parseChunk(chunk, ptype)
 				for _, seg := range segs {
+					if seg.Text == "" {
+						continue
+					}
+					emitted = true
 					if seg.Type == "thinking" {
-						finalThinking += seg.Text
-					} else {
-						finalText += seg.Text
+						em.thinking(limiter.Thinking(seg.Text))
+						continue
 					}
+					emitText(limiter.Feed(seg.Text))
 				}
-				return !finished
+				return !finished && !limiter.Done()
 			})
 		}()
+		emitText(limiter.Flush())
 
 		if !sawSSEData {
 			if body, jerr := ds.CompletionJSONRequest(ctx, headers, payload); jerr == nil {
 				jThinking, jText, ok := extractCompletionFromJSON(body)
-				if ok {
-					finalText = jText
-					finalThinking = jThinking
+				if ok && (jText != "" || jThinking != "") {
+					emitted = true
+					em.thinking(limiter.Thinking(jThinking))
+					emitText(limiter.Feed(jText) + limiter.Flush())
 				}
 			}
-			if finalText == "" && finalThinking == "" {
+			if !emitted {
 				if attempt < maxRetries {
 					time.Sleep(retryDelaySeconds * time.Duration(attempt+1))
 					continue
 				}
-				errEvent := map[string]any{"type": "error", "error": map[string]any{"type": "api_error", "message": "Invalid upstream stream."}}
-				b, _ := json.Marshal(errEvent)
-				_, _ = fmt.Fprintf(w, "data: %s\n\n", string(b))
-				if flusher != nil {
-					flusher.Flush()
-				}
+				em.fail("Invalid upstream stream.")
 				return
 			}
 		}
-		if finalText == "" && finalThinking == "" && attempt < maxRetries {
+		if !emitted && attempt < maxRetries {
 			time.Sleep(retryDelaySeconds * time.Duration(attempt+1))
 			continue
 		}
 
-		messageID := fmt.Sprintf("msg_%d_%d", time.Now().Unix(), rand.Intn(9000)+1000)
-		inputTokens := len(toJSON(messages)) / 4
-		start := map[string]any{"type": "message_start", "message": map[string]any{"id": messageID, "type": "message", "role": "assistant", "model": model, "content": []any{}, "stop_reason": nil, "stop_sequence": nil, "usage": map[string]any{"input_tokens": inputTokens, "output_tokens": 0}}}
-		b, _ := json.Marshal(start)
-		_, _ = fmt.Fprintf(w, "data: %s\n\n", string(b))
-		detected := DetectToolCalls(finalText, toolsRequested)
-		outputTokens := 0
-		contentIndex := 0
-
-		if finalThinking != "" {
-			cbStart := map[string]any{"type": "content_block_start", "index": contentIndex, "content_block": map[string]any{"type": "thinking", "thinking": ""}}
-			cbs, _ := json.Marshal(cbStart)
-			_, _ = fmt.Fprintf(w, "data: %s\n\n", string(cbs))
-			cbDelta := map[string]any{"type": "content_block_delta", "index": contentIndex, "delta": map[string]any{"type": "thinking_delta", "thinking": finalThinking}}
-			cbd, _ := json.Marshal(cbDelta)
-			_, _ = fmt.Fprintf(w, "data: %s\n\n", string(cbd))
-			cbStop := map[string]any{"type": "content_block_stop", "index": contentIndex}
-			cbe, _ := json.Marshal(cbStop)
-			_, _ = fmt.Fprintf(w, "data: %s\n\n", string(cbe))
-			outputTokens += len(finalThinking) / 4
-			contentIndex++
-		}
-
-		if len(detected) > 0 {
-			for i, t := range detected {
-				idx := contentIndex + i
-				blk := map[string]any{"type": "content_block_start", "index": idx, "content_block": map[string]any{"type": "tool_use", "id": fmt.Sprintf("toolu_%d_%d_%d", time.Now().Unix(), rand.Intn(9000)+1000, idx), "name": t["name"], "input": t["input"]}}
-				bb, _ := json.Marshal(blk)
-				_, _ = fmt.Fprintf(w, "data: %s\n\n", string(bb))
-				stop := map[string]any{"type": "content_block_stop", "index": idx}
-				bs, _ := json.Marshal(stop)
-				_, _ = fmt.Fprintf(w, "data: %s\n\n", string(bs))
-				outputTokens += len(toJSON(t["input"])) / 4
+		stopReason := claudeStopReason(false, limiter)
+		if parser != nil {
+			em.toolEvents(hold.filter(gate.filter(parser.Finish())))
+			em.toolEvents(hold.filter(gate.resolve(func(calls []map[string]any, errs []string) ([]map[string]any, bool) {
+				return repairToolCalls(ctx, ds, headers, payload, toolsRequested, opts.Repair, messageID, FormatToolCalls(calls), errs)
+			})))
+			if hold.on {
+				calls, errs, err := forceToolCall(ctx, ds, headers, payload, toolsRequested, opts, messageID, hold.take())
+				if err != nil {
+					em.fail(forcedToolCallError(errs))
+					return
+				}
+				if !opts.ToolChoice.Parallel && len(calls) > 1 {
+					calls = calls[:1]
+				}
+				em.toolEvents(toolCallEvents(calls))
 			}
-			delta := map[string]any{"type": "message_delta", "delta": map[string]any{"stop_reason": "tool_use", "stop_sequence": nil}, "usage": map[string]any{"output_tokens": outputTokens}}
-			bd, _ := json.Marshal(delta)
-			_, _ = fmt.Fprintf(w, "data: %s\n\n", string(bd))
-		} else {
-			if finalText != "" {
-				cbStart := map[string]any{"type": "content_block_start", "index": contentIndex, "content_block": map[string]any{"type": "text", "text": ""}}
-				cbs, _ := json.Marshal(cbStart)
-				_, _ = fmt.Fprintf(w, "data: %s\n\n", string(cbs))
-				cbDelta := map[string]any{"type": "content_block_delta", "index": contentIndex, "delta": map[string]any{"type": "text_delta", "text": finalText}}
-				cbd, _ := json.Marshal(cbDelta)
-				_, _ = fmt.Fprintf(w, "data: %s\n\n", string(cbd))
-				cbStop := map[string]any{"type": "content_block_stop", "index": contentIndex}
-				cbe, _ := json.Marshal(cbStop)
-				_, _ = fmt.Fprintf(w, "data: %s\n\n", string(cbe))
-				outputTokens += len(finalText) / 4
+			if em.toolCalls > 0 {
+				stopReason = "tool_use"
 			}
-			delta := map[string]any{"type": "message_delta", "delta": map[string]any{"stop_reason": "end_turn", "stop_sequence": nil}, "usage": map[string]any{"output_tokens": outputTokens}}
-			bd, _ := json.Marshal(delta)
-			_, _ = fmt.Fprintf(w, "data: %s\n\n", string(bd))
-		}
-		stop := map[string]any{"type": "message_stop"}
-		bs, _ := json.Marshal(stop)
-		_, _ = fmt.Fprintf(w, "data: %s\n\n", string(bs))
-		if flusher != nil {
-			flusher.Flush()
 		}
+		em.finish(stopReason, claudeStopSequence(em.toolCalls > 0, limiter))
+		reportReply(opts.OnReply, messageID, *followed, map[string]any{"role": "assistant", "content": em.reply})
 		return
 	}
 }
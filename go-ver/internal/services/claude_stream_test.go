@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"deepseek2api-go/internal/clients"
+)
+
+func TestClaudeStreamEventSequence(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, line := range []string{
+			`{"request_message_id":1,"response_message_id":2}`,
+			`{"p":"response/thinking_content","v":"Let me"}`,
+			`{"v":" think."}`,
+			`{"p":"response/content","v":"Hello"}`,
+			`{"v":" world"}`,
+			`{"p":"response/status","v":"FINISHED"}`,
+		} {
+			fmt.Fprintf(w, "data: %s\n\n", line)
+		}
+	}))
+	defer ts.Close()
+
+	rec := httptest.NewRecorder()
+	ds := clients.NewDeepSeekClient(ts.Client(), ts.URL, ts.URL, ts.URL)
+	ClaudeStream(context.Background(), rec, ds, map[string]string{}, map[string]any{}, "m", nil, nil, ClaudeOptions{})
+
+	var got []string
+	for _, ev := range sseEvents(t, rec.Body.String()) {
+		s := ev["type"].(string)
+		if i, ok := ev["index"].(float64); ok {
+			s += fmt.Sprintf(" %d", int(i))
+		}
+		switch ev["type"] {
+		case "message_start":
+			if msg := ev["message"].(map[string]any); msg["role"] != "assistant" || msg["model"] != "m" {
+				t.Fatalf("message_start = %v", ev)
+			}
+		case "content_block_start":
+			s += " " + ev["content_block"].(map[string]any)["type"].(string)
+		case "content_block_delta":
+			delta := ev["delta"].(map[string]any)
+			s += " " + delta["type"].(string)
+			for _, k := range []string{"thinking", "text"} {
+				if v, ok := delta[k].(string); ok {
+					s += " " + v
+				}
+			}
+		case "message_delta":
+			s += " " + ev["delta"].(map[string]any)["stop_reason"].(string)
+		}
+		got = append(got, s)
+	}
+	want := []string{
+		"message_start",
+		"content_block_start 0 thinking",
+		"content_block_delta 0 thinking_delta Let me",
+		"content_block_delta 0 thinking_delta  think.",
+		"content_block_stop 0",
+		"content_block_start 1 text",
+		"content_block_delta 1 text_delta Hello",
+		"content_block_delta 1 text_delta  world",
+		"content_block_stop 1",
+		"message_delta end_turn",
+		"message_stop",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("events:\n%q\nwant:\n%q", got, want)
+	}
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"deepseek2api-go/internal/clients"
+)
+
+const completionInstructions = "You are a raw text completion engine. Continue the text exactly where it stops. Output only the continuation: do not repeat the given text, do not add commentary, and do not wrap the output in quotes or code fences."
+
+const infillInstructions = "You are a raw text infilling engine. Write the text that belongs between PREFIX and SUFFIX so that PREFIX + your output + SUFFIX reads as one continuous document. Output only the missing middle part: do not repeat the prefix or the suffix, do not add commentary, and do not wrap the output in quotes or code fences."
+
+func BuildCompletionPrompt(prompt, suffix string) string {
+	if suffix == "" {
+		return MessagesPrepare([]map[string]any{{"role": "system", "content": completionInstructions}, {"role": "user", "content": prompt}})
+	}
+	return MessagesPrepare([]map[string]any{{"role": "system", "content": infillInstructions}, {"role": "user", "content": "PREFIX:\n" + prompt + "\n\nSUFFIX:\n" + suffix}})
+}
+
+func ParseCompletionPrompts(v any) ([]string, bool) {
+	switch p := v.(type) {
+	case string:
+		return []string{p}, true
+	case []any:
+		out := make([]string, 0, len(p))
+		for _, it := range p {
+			s, ok := it.(string)
+			if !ok {
+				return nil, false
+			}
+			out = append(out, s)
+		}
+		return out, len(out) > 0
+	}
+	return nil, false
+}
+
+func completionChoice(index int, text string, finishReason any) map[string]any {
+	return map[string]any{"text": text, "index": index, "logprobs": nil, "finish_reason": finishReason}
+}
+
+func CompletionNonStream(ctx context.Context, ds *clients.DeepSeekClient, branches []OpenAIBranch, prompts []string, echo bool, model, completionID string, created int64, opts OpenAIOptions) (int, map[string]any) {
+	type result struct {
+		status int
+		out    map[string]any
+	}
+	results := make([]result, len(branches))
+	var wg sync.WaitGroup
+	for i, b := range branches {
+		wg.Add(1)
+		go func(i int, b OpenAIBranch) {
+			defer wg.Done()
+			o := opts
+			o.Repair = b.Repair
+			finalPrompt, _ := b.Payload["prompt"].(string)
+			results[i].status, results[i].out = OpenAINonStream(ctx, ds, b.Headers, b.Payload, model, finalPrompt, completionID, created, false, false, o)
+		}(i, b)
+	}
+	wg.Wait()
+
+	choices := make([]map[string]any, 0, len(results))
+	promptTokens, completionTokens := 0, 0
+	for i, r := range results {
+		if r.status != http.StatusOK {
+			msg, _ := r.out["error"].(string)
+			return r.status, map[string]any{"error": fmt.Sprintf("Completion for prompt %d failed: %s", i, firstNonEmpty(msg, "upstream error"))}
+		}
+		chs, _ := r.out["choices"].([]map[string]any)
+		text, finishReason := "", any("stop")
+		if len(chs) > 0 {
+			msg, _ := chs[0]["message"].(map[string]any)
+			text, _ = msg["content"].(string)
+			finishReason = chs[0]["finish_reason"]
+		}
+		if echo {
+			text = prompts[i] + text
+		}
+		choices = append(choices, completionChoice(i, text, finishReason))
+		usage, _ := r.out["usage"].(map[string]any)
+		promptTokens += intValue(usage["prompt_tokens"])
+		completionTokens += intValue(usage["completion_tokens"])
+	}
+	return http.StatusOK, map[string]any{
+		"id":      completionID,
+		"object":  "text_completion",
+		"created": created,
+		"model":   model,
+		"choices": choices,
+		"usage":   map[string]any{"prompt_tokens": promptTokens, "completion_tokens": completionTokens, "total_tokens": promptTokens + completionTokens},
+	}
+}
+
+func CompletionStream(ctx context.Context, w http.ResponseWriter, ds *clients.DeepSeekClient, branches []OpenAIBranch, prompts []string, echo bool, model, completionID string, created int64, opts OpenAIOptions) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	mux := &streamMux{w: w, flusher: flusher}
+	chunk := func(index int, text string, finishReason any) map[string]any {
+		return map[string]any{"id": completionID, "object": "text_completion", "created": created, "model": model, "choices": []map[string]any{completionChoice(index, text, finishReason)}}
+	}
+
+	writers := make([]*branchWriter, len(branches))
+	var wg sync.WaitGroup
+	for i, b := range branches {
+		writers[i] = &branchWriter{mux: mux, index: i, header: http.Header{}, rewrite: func(c map[string]any) map[string]any {
+			choices, _ := c["choices"].([]any)
+			if len(choices) == 0 {
+				return nil
+			}
+			first, _ := choices[0].(map[string]any)
+			delta, _ := first["delta"].(map[string]any)
+			text, _ := delta["content"].(string)
+			if text == "" && first["finish_reason"] == nil {
+				return nil
+			}
+			return chunk(intValue(first["index"]), text, first["finish_reason"])
+		}}
+		if echo && prompts[i] != "" {
+			mux.send(toJSON(chunk(i, prompts[i], nil)))
+		}
+		wg.Add(1)
+		go func(bw *branchWriter, b OpenAIBranch) {
+			defer wg.Done()
+			o := opts
+			o.Repair = b.Repair
+			finalPrompt, _ := b.Payload["prompt"].(string)
+			OpenAIStream(ctx, bw, ds, b.Headers, b.Payload, model, finalPrompt, completionID, created, false, false, o)
+		}(writers[i], b)
+	}
+	wg.Wait()
+
+	promptTokens, completionTokens := 0, 0
+	for i, bw := range writers {
+		if bw.err != "" || !bw.finished {
+			mux.send(toJSON(map[string]any{"error": fmt.Sprintf("Completion for prompt %d failed: %s", i, firstNonEmpty(bw.err, "upstream stream ended early"))}))
+			continue
+		}
+		usage, _ := bw.usage.(map[string]any)
+		promptTokens += intValue(usage["prompt_tokens"])
+		completionTokens += intValue(usage["completion_tokens"])
+	}
+	mux.send(toJSON(map[string]any{"id": completionID, "object": "text_completion", "created": created, "model": model, "choices": []any{}, "usage": map[string]any{"prompt_tokens": promptTokens, "completion_tokens": completionTokens, "total_tokens": promptTokens + completionTokens}}))
+	mux.send("[DONE]")
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseCompletionPrompts(t *testing.T) {
+	if p, ok := ParseCompletionPrompts("abc"); !ok || len(p) != 1 || p[0] != "abc" {
+		t.Fatalf("unexpected prompts %v %v", p, ok)
+	}
+	if p, ok := ParseCompletionPrompts([]any{"a", "b"}); !ok || len(p) != 2 {
+		t.Fatalf("unexpected prompts %v %v", p, ok)
+	}
+	for _, bad := range []any{nil, []any{}, []any{1.0, 2.0}} {
+		if _, ok := ParseCompletionPrompts(bad); ok {
+			t.Fatalf("expected %v to be rejected", bad)
+		}
+	}
+}
+
+func TestCompletionNonStream(t *testing.T) {
+	ds := newUpstream(t, helloWorld...)
+	branches := []OpenAIBranch{{Headers: map[string]string{}, Payload: map[string]any{"prompt": BuildCompletionPrompt("Say", "")}}, {Headers: map[string]string{}, Payload: map[string]any{"prompt": BuildCompletionPrompt("Hi", "!")}}}
+	status, out := CompletionNonStream(context.Background(), ds, branches, []string{"Say", "Hi"}, true, "m", "cmpl-1", 1, OpenAIOptions{})
+	if status != http.StatusOK || out["object"] != "text_completion" {
+		t.Fatalf("unexpected response %d %v", status, out)
+	}
+	choices := out["choices"].([]map[string]any)
+	if len(choices) != 2 || choices[0]["text"] != "SayHello world" || choices[1]["text"] != "HiHello world" || choices[1]["index"] != 1 {
+		t.Fatalf("unexpected choices %v", choices)
+	}
+}
+
+func TestCompletionStream(t *testing.T) {
+	ds := newUpstream(t, helloWorld...)
+	branches := []OpenAIBranch{{Headers: map[string]string{}, Payload: map[string]any{"prompt": "p"}}}
+	rec := httptest.NewRecorder()
+	CompletionStream(context.Background(), rec, ds, branches, []string{"Say"}, true, "m", "cmpl-1", 1, OpenAIOptions{})
+	body := rec.Body.String()
+	for _, want := range []string{`"text":"Say"`, `"text":"Hello "`, `"finish_reason":"stop"`, `"object":"text_completion"`, "data: [DONE]"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("stream missing %s:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "chat.completion") || strings.Contains(body, "delta") {
+		t.Fatalf("stream leaked chat chunks:\n%s", body)
+	}
+}
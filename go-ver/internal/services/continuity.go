@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+// Replay restarts a conversation whose resumed completion failed: it opens a
+// fresh upstream session and returns the headers and payload that send it the
+// whole transcript.
+type Replay func() (map[string]string, map[string]any, bool)
+
+// replayOnRetry switches a resumed completion over to its full replay before
+// the first retry; later retries keep using the replay.
+func replayOnRetry(headers map[string]string, payload map[string]any, replay *Replay) (map[string]string, map[string]any) {
+	r := *replay
+	if r == nil {
+		return headers, payload
+	}
+	*replay = nil
+	if h, p, ok := r(); ok {
+		return h, p
+	}
+	return headers, payload
+}
+
+func trackFollowups(r ToolRepair) (ToolRepair, *bool) {
+	used := new(bool)
+	if pow := r.PoW; pow != nil {
+		r.PoW = func(ctx context.Context) (string, error) {
+			*used = true
+			return pow(ctx)
+		}
+	}
+	return r, used
+}
+
+// reportReply hands the assistant message, in the shape a client would echo
+// back, to onReply. Replies that went through a follow-up are skipped because
+// the upstream thread no longer ends at messageID.
+func reportReply(onReply func(int, map[string]any), messageID int, followed bool, reply map[string]any) {
+	if onReply == nil || messageID <= 0 || followed {
+		return
+	}
+	var m map[string]any
+	if json.Unmarshal([]byte(toJSON(reply)), &m) == nil {
+		onReply(messageID, m)
+	}
+}
+
+type openAIReply struct {
+	content strings.Builder
+	calls   []map[string]any
+}
+
+func (r *openAIReply) add(delta map[string]any) {
+	if c, _ := delta["content"].(string); c != "" {
+		r.content.WriteString(c)
+	}
+	calls, _ := delta["tool_calls"].([]map[string]any)
+	for _, tc := range calls {
+		fn, _ := tc["function"].(map[string]any)
+		if id, ok := tc["id"].(string); ok {
+			r.calls = append(r.calls, map[string]any{"id": id, "type": "function", "function": map[string]any{"name": fn["name"], "arguments": ""}})
+		}
+		if n := len(r.calls); n > 0 {
+			args, _ := fn["arguments"].(string)
+			last, _ := r.calls[n-1]["function"].(map[string]any)
+			last["arguments"] = last["arguments"].(string) + args
+		}
+	}
+}
+
+func (r *openAIReply) message() map[string]any {
+	msg := map[string]any{"role": "assistant", "content": r.content.String()}
+	if len(r.calls) > 0 {
+		msg["tool_calls"] = r.calls
+	}
+	return msg
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"deepseek2api-go/internal/clients"
+)
+
+func TestOnReplyMatchesReturnedMessage(t *testing.T) {
+	tools := []map[string]any{{"name": "get", "input_schema": map[string]any{"type": "object"}}}
+	for _, streaming := range []bool{false, true} {
+		ds := newUpstream(t,
+			map[string]any{"request_message_id": 1, "response_message_id": 2},
+			map[string]any{"p": "response/content", "v": `{"tool_calls":[{"name":"get","input":{"city":"SF"}}]}`},
+		)
+		var gotID int
+		var reply map[string]any
+		opts := OpenAIOptions{Tools: tools, ToolChoice: DefaultToolChoice(), OnReply: func(id int, r map[string]any) { gotID, reply = id, r }}
+		if streaming {
+			OpenAIStream(context.Background(), httptest.NewRecorder(), ds, map[string]string{}, map[string]any{}, "m", "prompt", "id", 1, false, false, opts)
+		} else {
+			OpenAINonStream(context.Background(), ds, map[string]string{}, map[string]any{}, "m", "prompt", "id", 1, false, false, opts)
+		}
+		if gotID != 2 || reply == nil {
+			t.Fatalf("stream=%v: expected reply for message 2, got %d %v", streaming, gotID, reply)
+		}
+		calls := openAIToolCallsToInternal(reply["tool_calls"])
+		if len(calls) != 1 || calls[0]["name"] != "get" || toJSON(calls[0]["input"]) != `{"city":"SF"}` {
+			t.Fatalf("stream=%v: unexpected reply %v", streaming, reply)
+		}
+	}
+}
+
+func TestFailedResumeReplaysTranscript(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body["parent_message_id"] != nil {
+			http.Error(w, `{"code":40300,"msg":"invalid parent"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: %s\n\n", toJSON(map[string]any{"p": "response/content", "v": "replayed: " + body["prompt"].(string)}))
+		fmt.Fprint(w, "data: {\"p\":\"response/status\",\"v\":\"FINISHED\"}\n\n")
+	}))
+	defer ts.Close()
+	ds := clients.NewDeepSeekClient(ts.Client(), ts.URL, ts.URL, ts.URL)
+
+	replays := 0
+	opts := OpenAIOptions{Replay: func() (map[string]string, map[string]any, bool) {
+		replays++
+		return map[string]string{}, map[string]any{"parent_message_id": nil, "prompt": "full"}, true
+	}}
+	status, out := OpenAINonStream(context.Background(), ds, map[string]string{}, map[string]any{"parent_message_id": 4, "prompt": "tail"}, "m", "full", "id", 1, false, false, opts)
+	if status != http.StatusOK || replays != 1 {
+		t.Fatalf("status %d after %d replays: %v", status, replays, out)
+	}
+	if got := out["choices"].([]map[string]any)[0]["message"].(map[string]any)["content"]; got != "replayed: full" {
+		t.Fatalf("content = %v", got)
+	}
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+type OutputLimits struct {
+	Stop      []string
+	MaxTokens int
+}
+
+func ParseStopSequences(v any) []string {
+	var out []string
+	switch s := v.(type) {
+	case string:
+		if s != "" {
+			out = append(out, s)
+		}
+	case []any:
+		for _, it := range s {
+			if str, ok := it.(string); ok && str != "" {
+				out = append(out, str)
+			}
+		}
+	}
+	return out
+}
+
+func ParseMaxTokens(vals ...any) int {
+	for _, v := range vals {
+		if f, ok := v.(float64); ok && f > 0 {
+			return int(f)
+		}
+	}
+	return 0
+}
+
+const (
+	limitStop      = "stop_sequence"
+	limitMaxTokens = "max_tokens"
+)
+
+type outputLimiter struct {
+	stops    []string
+	maxChars int
+	spent    int
+	held     string
+	done     bool
+	reason   string
+	matched  string
+}
+
+func newOutputLimiter(l OutputLimits) *outputLimiter {
+	return &outputLimiter{stops: l.Stop, maxChars: l.MaxTokens * 4}
+}
+
+func (o *outputLimiter) Done() bool { return o.done }
+
+func (o *outputLimiter) Thinking(s string) string {
+	if o.done {
+		return ""
+	}
+	return o.spend(s)
+}
+
+func (o *outputLimiter) Feed(s string) string {
+	if o.done {
+		return ""
+	}
+	buf := o.held + s
+	o.held = ""
+	cut := -1
+	for _, stop := range o.stops {
+		if i := strings.Index(buf, stop); i >= 0 && (cut < 0 || i < cut) {
+			cut = i
+			o.matched = stop
+		}
+	}
+	if cut >= 0 {
+		out := o.spend(buf[:cut])
+		if !o.done {
+			o.done = true
+			o.reason = limitStop
+		}
+		return out
+	}
+	keep := 0
+	for _, stop := range o.stops {
+		for n := len(stop) - 1; n > keep; n-- {
+			if n <= len(buf) && strings.HasSuffix(buf, stop[:n]) {
+				keep = n
+				break
+			}
+		}
+	}
+	o.held = buf[len(buf)-keep:]
+	return o.spend(buf[:len(buf)-keep])
+}
+
+func (o *outputLimiter) Flush() string {
+	if o.done {
+		return ""
+	}
+	held := o.held
+	o.held = ""
+	return o.spend(held)
+}
+
+func (o *outputLimiter) spend(s string) string {
+	if o.maxChars <= 0 || s == "" {
+		return s
+	}
+	if o.spent >= o.maxChars {
+		o.done = true
+		o.reason = limitMaxTokens
+		return ""
+	}
+	if room := o.maxChars - o.spent; len(s) > room {
+		for room > 0 && !utf8.RuneStart(s[room]) {
+			room--
+		}
+		o.spent = o.maxChars
+		o.done = true
+		o.reason = limitMaxTokens
+		return s[:room]
+	}
+	o.spent += len(s)
+	return s
+}
@@ -0,0 +1,48 @@
+package services
+
+import "testing"
+
+func TestOutputLimiter(t *testing.T) {
+	cases := []struct {
+		name    string
+		limits  OutputLimits
+		chunks  []string
+		out     string
+		reason  string
+		matched string
+	}{
+		{"no limits", OutputLimits{}, []string{"hello ", "world"}, "hello world", "", ""},
+		{"stop in chunk", OutputLimits{Stop: []string{"END"}}, []string{"one END two"}, "one ", limitStop, "END"},
+		{"stop across chunks", OutputLimits{Stop: []string{"</answer>"}}, []string{"42</an", "swer> ignored"}, "42", limitStop, "</answer>"},
+		{"partial stop released", OutputLimits{Stop: []string{"STOP"}}, []string{"ST", "ART"}, "START", "", ""},
+		{"earliest stop wins", OutputLimits{Stop: []string{"b", "a"}}, []string{"xxab"}, "xx", limitStop, "a"},
+		{"stop at start", OutputLimits{Stop: []string{"\n"}}, []string{"\nrest"}, "", limitStop, "\n"},
+		{"max tokens", OutputLimits{MaxTokens: 2}, []string{"abcdef", "ghijkl"}, "abcdefgh", limitMaxTokens, ""},
+		{"max tokens exact", OutputLimits{MaxTokens: 1}, []string{"abcd"}, "abcd", "", ""},
+		{"max tokens rune boundary", OutputLimits{MaxTokens: 1}, []string{"ab你好"}, "ab", limitMaxTokens, ""},
+		{"stop before budget", OutputLimits{Stop: []string{"."}, MaxTokens: 10}, []string{"Hi. There"}, "Hi", limitStop, "."},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			l := newOutputLimiter(c.limits)
+			out := ""
+			for _, chunk := range c.chunks {
+				out += l.Feed(chunk)
+			}
+			out += l.Flush()
+			if out != c.out || l.reason != c.reason || l.matched != c.matched {
+				t.Fatalf("got (%q, %q, %q), want (%q, %q, %q)", out, l.reason, l.matched, c.out, c.reason, c.matched)
+			}
+		})
+	}
+}
+
+func TestOutputLimiterThinkingSpendsBudget(t *testing.T) {
+	l := newOutputLimiter(OutputLimits{MaxTokens: 2})
+	if got := l.Thinking("123456"); got != "123456" {
+		t.Fatalf("unexpected thinking %q", got)
+	}
+	if got := l.Feed("abcd"); got != "ab" || !l.Done() || l.reason != limitMaxTokens {
+		t.Fatalf("unexpected text %q (done=%v reason=%q)", got, l.Done(), l.reason)
+	}
+}
@@ -49,9 +49,32 @@ func ResolveModelFlags(model string) (bool, bool, bool) {
 
 func MessagesPrepare(messages []map[string]any) string {
 	processed := make([]map[string]string, 0, len(messages))
+	toolNames := map[string]string{}
 	for _, m := range messages {
 		role, _ := m["role"].(string)
 		text := extractText(m["content"])
+		switch role {
+		case "assistant":
+			if calls := openAIToolCallsToInternal(m["tool_calls"]); len(calls) > 0 {
+				for _, c := range calls {
+					if id, _ := c["id"].(string); id != "" {
+						toolNames[id], _ = c["name"].(string)
+					}
+				}
+				if strings.TrimSpace(text) != "" {
+					text += "\n\n"
+				}
+				text += FormatToolCalls(calls)
+			}
+		case "tool", "function":
+			id, _ := m["tool_call_id"].(string)
+			name, _ := m["name"].(string)
+			if name == "" {
+				name = toolNames[id]
+			}
+			role = "user"
+			text = FormatToolResult(id, name, text, false)
+		}
 		processed = append(processed, map[string]string{"role": role, "text": text})
 	}
 	if len(processed) == 0 {
@@ -90,6 +113,8 @@ func MessagesPrepare(messages []map[string]any) string {
 
 func extractText(v any) string {
 	switch vv := v.(type) {
+	case nil:
+		return ""
 	case string:
 		return vv
 	case []any:
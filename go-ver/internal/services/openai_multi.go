@@ -0,0 +1,210 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"deepseek2api-go/internal/clients"
+)
+
+type OpenAIBranch struct {
+	Headers map[string]string
+	Payload map[string]any
+	Repair  ToolRepair
+}
+
+type choiceUsage struct {
+	completion int
+	reasoning  int
+}
+
+func (u *choiceUsage) add(v any) {
+	m, _ := v.(map[string]any)
+	u.completion += intValue(m["completion_tokens"])
+	details, _ := m["completion_tokens_details"].(map[string]any)
+	u.reasoning += intValue(details["reasoning_tokens"])
+}
+
+func (u choiceUsage) toMap(finalPrompt string) map[string]any {
+	promptTokens := len(finalPrompt) / 4
+	return map[string]any{"prompt_tokens": promptTokens, "completion_tokens": u.completion, "total_tokens": promptTokens + u.completion, "completion_tokens_details": map[string]any{"reasoning_tokens": u.reasoning}}
+}
+
+func intValue(v any) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case float64:
+		return int(n)
+	}
+	return 0
+}
+
+func OpenAINonStreamN(ctx context.Context, ds *clients.DeepSeekClient, branches []OpenAIBranch, model, finalPrompt, completionID string, created int64, thinkingEnabled bool, searchEnabled bool, opts OpenAIOptions, minSuccess int) (int, map[string]any) {
+	type result struct {
+		status int
+		out    map[string]any
+	}
+	results := make([]result, len(branches))
+	var wg sync.WaitGroup
+	for i, b := range branches {
+		wg.Add(1)
+		go func(i int, b OpenAIBranch) {
+			defer wg.Done()
+			o := opts
+			o.Repair = b.Repair
+			results[i].status, results[i].out = OpenAINonStream(ctx, ds, b.Headers, b.Payload, model, finalPrompt, completionID, created, thinkingEnabled, searchEnabled, o)
+		}(i, b)
+	}
+	wg.Wait()
+
+	choices := make([]map[string]any, 0, len(branches))
+	usage := choiceUsage{}
+	lastErr := "Upstream DeepSeek completion failed."
+	for _, r := range results {
+		if r.status != http.StatusOK {
+			if msg, ok := r.out["error"].(string); ok {
+				lastErr = msg
+			}
+			continue
+		}
+		chs, _ := r.out["choices"].([]map[string]any)
+		for _, c := range chs {
+			c["index"] = len(choices)
+			choices = append(choices, c)
+		}
+		usage.add(r.out["usage"])
+	}
+	if len(choices) < minSuccess {
+		return http.StatusBadGateway, map[string]any{"error": fmt.Sprintf("Only %d of %d choices succeeded: %s", len(choices), len(branches), lastErr)}
+	}
+	return http.StatusOK, map[string]any{
+		"id":      completionID,
+		"object":  "chat.completion",
+		"created": created,
+		"model":   model,
+		"choices": choices,
+		"usage":   usage.toMap(finalPrompt),
+	}
+}
+
+type streamMux struct {
+	mu      sync.Mutex
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func (m *streamMux) send(data string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, _ = fmt.Fprintf(m.w, "data: %s\n\n", data)
+	if m.flusher != nil {
+		m.flusher.Flush()
+	}
+}
+
+type branchWriter struct {
+	mux      *streamMux
+	index    int
+	header   http.Header
+	buf      []byte
+	usage    any
+	finished bool
+	err      string
+	rewrite  func(map[string]any) map[string]any
+}
+
+func (b *branchWriter) Header() http.Header { return b.header }
+func (b *branchWriter) WriteHeader(int)     {}
+func (b *branchWriter) Flush()              {}
+
+func (b *branchWriter) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	for {
+		i := bytes.Index(b.buf, []byte("\n\n"))
+		if i < 0 {
+			break
+		}
+		b.event(string(b.buf[:i]))
+		b.buf = b.buf[i+2:]
+	}
+	return len(p), nil
+}
+
+func (b *branchWriter) event(ev string) {
+	data := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(ev), "data:"))
+	if data == "" || data == "[DONE]" {
+		return
+	}
+	var chunk map[string]any
+	if json.Unmarshal([]byte(data), &chunk) != nil {
+		return
+	}
+	if e, ok := chunk["error"]; ok {
+		b.err = fmt.Sprintf("%v", e)
+		return
+	}
+	choices, _ := chunk["choices"].([]any)
+	for _, c := range choices {
+		if cm, ok := c.(map[string]any); ok {
+			cm["index"] = b.index
+			if cm["finish_reason"] != nil {
+				b.finished = true
+			}
+		}
+	}
+	if u, ok := chunk["usage"]; ok {
+		b.usage = u
+		delete(chunk, "usage")
+	}
+	if b.rewrite != nil {
+		if chunk = b.rewrite(chunk); chunk == nil {
+			return
+		}
+	}
+	b.mux.send(toJSON(chunk))
+}
+
+func OpenAIStreamN(ctx context.Context, w http.ResponseWriter, ds *clients.DeepSeekClient, branches []OpenAIBranch, model, finalPrompt, completionID string, created int64, thinkingEnabled bool, searchEnabled bool, opts OpenAIOptions, minSuccess int) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	mux := &streamMux{w: w, flusher: flusher}
+
+	writers := make([]*branchWriter, len(branches))
+	var wg sync.WaitGroup
+	for i, b := range branches {
+		writers[i] = &branchWriter{mux: mux, index: i, header: http.Header{}}
+		wg.Add(1)
+		go func(bw *branchWriter, b OpenAIBranch) {
+			defer wg.Done()
+			o := opts
+			o.Repair = b.Repair
+			OpenAIStream(ctx, bw, ds, b.Headers, b.Payload, model, finalPrompt, completionID, created, thinkingEnabled, searchEnabled, o)
+		}(writers[i], b)
+	}
+	wg.Wait()
+
+	usage := choiceUsage{}
+	succeeded := 0
+	lastErr := "Upstream DeepSeek completion failed."
+	for _, bw := range writers {
+		if bw.err != "" || !bw.finished {
+			lastErr = firstNonEmpty(bw.err, lastErr)
+			continue
+		}
+		succeeded++
+		usage.add(bw.usage)
+	}
+	if succeeded < minSuccess {
+		mux.send(toJSON(map[string]any{"error": fmt.Sprintf("Only %d of %d choices succeeded: %s", succeeded, len(branches), lastErr)}))
+	}
+	mux.send(toJSON(map[string]any{"id": completionID, "object": "chat.completion.chunk", "created": created, "model": model, "choices": []any{}, "usage": usage.toMap(finalPrompt)}))
+	mux.send("[DONE]")
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// helloWorld is a reply whose text arrives in two chunks.
+var helloWorld = []map[string]any{{"p": "response/content", "v": "Hello "}, {"p": "response/content", "v": "world"}}
+
+func TestOpenAINonStreamN(t *testing.T) {
+	ds := newUpstream(t, helloWorld...)
+	branches := make([]OpenAIBranch, 3)
+	for i := range branches {
+		branches[i] = OpenAIBranch{Headers: map[string]string{}, Payload: map[string]any{}}
+	}
+	status, out := OpenAINonStreamN(context.Background(), ds, branches, "m", "prompt", "id", 1, false, false, OpenAIOptions{}, 3)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status %d: %v", status, out)
+	}
+	choices := out["choices"].([]map[string]any)
+	if len(choices) != 3 {
+		t.Fatalf("expected 3 choices, got %d", len(choices))
+	}
+	for i, c := range choices {
+		if c["index"] != i {
+			t.Fatalf("choice %d has index %v", i, c["index"])
+		}
+	}
+	if got := out["usage"].(map[string]any)["completion_tokens"]; got != 3*(len("Hello world")/4) {
+		t.Fatalf("unexpected completion tokens %v", got)
+	}
+}
+
+func TestOpenAIStreamN(t *testing.T) {
+	ds := newUpstream(t, helloWorld...)
+	branches := []OpenAIBranch{{Headers: map[string]string{}, Payload: map[string]any{}}, {Headers: map[string]string{}, Payload: map[string]any{}}}
+	rec := httptest.NewRecorder()
+	OpenAIStreamN(context.Background(), rec, ds, branches, "m", "prompt", "id", 1, false, false, OpenAIOptions{}, 2)
+	body := rec.Body.String()
+	for _, want := range []string{`"finish_reason":"stop","index":0`, `"finish_reason":"stop","index":1`, `"choices":[]`, "data: [DONE]"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("stream missing %s:\n%s", want, body)
+		}
+	}
+	if strings.Count(body, "[DONE]") != 1 || strings.Contains(body, `"error"`) {
+		t.Fatalf("unexpected stream:\n%s", body)
+	}
+}
@@ -16,6 +16,16 @@ const (
 	retryDelaySeconds = 800 * time.Millisecond
 )
 
+type OpenAIOptions struct {
+	Tools          []map[string]any
+	ToolChoice     ToolChoice
+	Repair         ToolRepair
+	ResponseFormat ResponseFormat
+	Limits         OutputLimits
+	OnReply        func(messageID int, reply map[string]any)
+	Replay         Replay
+}
+
 func extractCompletionFromJSON(body map[string]any) (string, string, bool) {
 	if code, ok := body["code"].(float64); ok && int(code) != 0 {
 		return "", "", false
@@ -43,33 +53,28 @@ func extractCompletionFromJSON(body map[string]any) (string, string, bool) {
 	return reasoning, content, true
 }
 
-func OpenAINonStream(ctx context.Context, ds *clients.DeepSeekClient, headers map[string]string, payload map[string]any, model, finalPrompt, completionID string, created int64, thinkingEnabled bool, searchEnabled bool) (int, map[string]any) {
+func OpenAINonStream(ctx context.Context, ds *clients.DeepSeekClient, headers map[string]string, payload map[string]any, model, finalPrompt, completionID string, created int64, thinkingEnabled bool, searchEnabled bool, opts OpenAIOptions) (int, map[string]any) {
 	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			headers, payload = replayOnRetry(headers, payload, &opts.Replay)
+		}
 		finalText := ""
 		finalThinking := ""
+		limiter := newOutputLimiter(opts.Limits)
 
-		resp, err := ds.CompletionRawStreamRequest(ctx, headers, payload)
+		streamCtx, cancel := context.WithCancel(ctx)
+		resp, err := ds.CompletionRawStreamRequest(streamCtx, headers, payload)
 		if err != nil {
+			cancel()
 			if body, jerr := ds.CompletionJSONRequest(ctx, headers, payload); jerr == nil {
 				jThinking, jText, ok := extractCompletionFromJSON(body)
 				if ok {
-					finalText = jText
-					finalThinking = jThinking
+					finalThinking = limiter.Thinking(jThinking)
+					finalText = limiter.Feed(jText) + limiter.Flush()
 				}
 			}
-			if finalText != "" || finalThinking != "" {
-				promptTokens := len(finalPrompt) / 4
-				reasoningTokens := len(finalThinking) / 4
-				completionTokens := len(finalText) / 4
-				result := map[string]any{
-					"id":      completionID,
-					"object":  "chat.completion",
-					"created": created,
-					"model":   model,
-					"choices": []map[string]any{{"index": 0, "message": map[string]any{"role": "assistant", "content": finalText, "reasoning_content": finalThinking}, "finish_reason": "stop"}},
-					"usage":   map[string]any{"prompt_tokens": promptTokens, "completion_tokens": reasoningTokens + completionTokens, "total_tokens": promptTokens + reasoningTokens + completionTokens, "completion_tokens_details": map[string]any{"reasoning_tokens": reasoningTokens}},
-				}
-				return http.StatusOK, result
+			if finalText != "" || finalThinking != "" || limiter.Done() {
+				return openAIFinish(ctx, ds, headers, payload, model, finalPrompt, completionID, created, finalText, finalThinking, opts, 0, limiter.reason)
 			}
 			if attempt < maxRetries {
 				time.Sleep(retryDelaySeconds * time.Duration(attempt+1))
@@ -80,7 +85,9 @@ func OpenAINonStream(ctx context.Context, ds *clients.DeepSeekClient, headers ma
 
 		sawSSEData := false
 		retryNow := false
+		messageID := 0
 		func() {
+			defer cancel()
 			defer resp.Body.Close()
 			scanner := bufio.NewScanner(resp.Body)
 			scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
@@ -92,6 +99,9 @@ func OpenAINonStream(ctx context.Context, ds *clients.DeepSeekClient, headers ma
 				if json.Unmarshal([]byte(data), &chunk) != nil {
 					return true
 				}
+				if id, ok := responseMessageID(chunk); ok {
+					messageID = id
+				}
 				var segs []segment
 				ptype, segs, finished = parseChunk(chunk, ptype)
 				for _, seg := range segs {
@@ -101,12 +111,15 @@ func OpenAINonStream(ctx context.Context, ds *clients.DeepSeekClient, headers ma
 					}
 					if seg.Type == "thinking" {
 						if thinkingEnabled {
-							finalThinking += s
+							finalThinking += limiter.Thinking(s)
 						}
 					} else {
-						finalText += s
+						finalText += limiter.Feed(s)
 					}
 				}
+				if limiter.Done() {
+					return false
+				}
 				if finished && finalText == "" && finalThinking == "" && attempt < maxRetries {
 					retryNow = true
 					return false
@@ -114,6 +127,7 @@ func OpenAINonStream(ctx context.Context, ds *clients.DeepSeekClient, headers ma
 				return !finished
 			})
 		}()
+		finalText += limiter.Flush()
 
 		if retryNow {
 			time.Sleep(retryDelaySeconds * time.Duration(attempt+1))
@@ -123,11 +137,11 @@ func OpenAINonStream(ctx context.Context, ds *clients.DeepSeekClient, headers ma
 			if body, jerr := ds.CompletionJSONRequest(ctx, headers, payload); jerr == nil {
 				jThinking, jText, ok := extractCompletionFromJSON(body)
 				if ok {
-					finalText = jText
-					finalThinking = jThinking
+					finalThinking = limiter.Thinking(jThinking)
+					finalText = limiter.Feed(jText) + limiter.Flush()
 				}
 			}
-			if finalText == "" && finalThinking == "" {
+			if finalText == "" && finalThinking == "" && !limiter.Done() {
 				if attempt < maxRetries {
 					time.Sleep(retryDelaySeconds * time.Duration(attempt+1))
 					continue
@@ -135,23 +149,59 @@ func OpenAINonStream(ctx context.Context, ds *clients.DeepSeekClient, headers ma
 				return http.StatusBadGateway, map[string]any{"error": "Upstream DeepSeek returned an invalid completion stream."}
 			}
 		}
-		if finalText == "" && finalThinking == "" && attempt < maxRetries {
+		if finalText == "" && finalThinking == "" && !limiter.Done() && attempt < maxRetries {
 			time.Sleep(retryDelaySeconds * time.Duration(attempt+1))
 			continue
 		}
 
-		promptTokens := len(finalPrompt) / 4
-		reasoningTokens := len(finalThinking) / 4
-		completionTokens := len(finalText) / 4
-		result := map[string]any{
-			"id":      completionID,
-			"object":  "chat.completion",
-			"created": created,
-			"model":   model,
-			"choices": []map[string]any{{"index": 0, "message": map[string]any{"role": "assistant", "content": finalText, "reasoning_content": finalThinking}, "finish_reason": "stop"}},
-			"usage":   map[string]any{"prompt_tokens": promptTokens, "completion_tokens": reasoningTokens + completionTokens, "total_tokens": promptTokens + reasoningTokens + completionTokens, "completion_tokens_details": map[string]any{"reasoning_tokens": reasoningTokens}},
-		}
-		return http.StatusOK, result
+		return openAIFinish(ctx, ds, headers, payload, model, finalPrompt, completionID, created, finalText, finalThinking, opts, messageID, limiter.reason)
 	}
 	return http.StatusBadGateway, map[string]any{"error": "Upstream DeepSeek completion failed after retries."}
 }
+
+func openAIFinish(ctx context.Context, ds *clients.DeepSeekClient, headers map[string]string, payload map[string]any, model, finalPrompt, completionID string, created int64, finalText, finalThinking string, opts OpenAIOptions, messageID int, limitReason string) (int, map[string]any) {
+	var followed *bool
+	opts.Repair, followed = trackFollowups(opts.Repair)
+	toolCalls, prose := resolveToolCalls(ctx, ds, headers, payload, finalText, opts.Tools, opts.Repair, messageID)
+	if len(toolCalls) == 0 && opts.ResponseFormat.Enabled() {
+		out, errs, err := enforceResponseFormat(ctx, ds, headers, payload, opts.ResponseFormat, opts.Repair, messageID, finalText)
+		if err != nil {
+			return http.StatusBadGateway, map[string]any{"error": responseFormatError(errs)}
+		}
+		finalText, prose = out, out
+	}
+	out := openAIResult(model, finalPrompt, completionID, created, finalText, prose, finalThinking, toolCalls, opts, limitReason)
+	reportReply(opts.OnReply, messageID, *followed, out["choices"].([]map[string]any)[0]["message"].(map[string]any))
+	return http.StatusOK, out
+}
+
+func openAIResult(model, finalPrompt, completionID string, created int64, finalText, prose, finalThinking string, toolCalls []map[string]any, opts OpenAIOptions, limitReason string) map[string]any {
+	promptTokens := len(finalPrompt) / 4
+	reasoningTokens := len(finalThinking) / 4
+	completionTokens := len(finalText) / 4
+	message := map[string]any{"role": "assistant", "content": finalText, "reasoning_content": finalThinking}
+	finishReason := openAIFinishReason(limitReason)
+	if len(toolCalls) > 0 {
+		message["content"] = nil
+		if prose != "" {
+			message["content"] = prose
+		}
+		message["tool_calls"] = OpenAIToolCalls(toolCalls, opts.ToolChoice)
+		finishReason = "tool_calls"
+	}
+	return map[string]any{
+		"id":      completionID,
+		"object":  "chat.completion",
+		"created": created,
+		"model":   model,
+		"choices": []map[string]any{{"index": 0, "message": message, "finish_reason": finishReason}},
+		"usage":   map[string]any{"prompt_tokens": promptTokens, "completion_tokens": reasoningTokens + completionTokens, "total_tokens": promptTokens + reasoningTokens + completionTokens, "completion_tokens_details": map[string]any{"reasoning_tokens": reasoningTokens}},
+	}
+}
+
+func openAIFinishReason(limitReason string) string {
+	if limitReason == limitMaxTokens {
+		return "length"
+	}
+	return "stop"
+}
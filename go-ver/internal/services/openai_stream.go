@@ -12,14 +12,21 @@ import (
 	"deepseek2api-go/internal/clients"
 )
 
-func OpenAIStream(ctx context.Context, w http.ResponseWriter, ds *clients.DeepSeekClient, headers map[string]string, payload map[string]any, model, finalPrompt, completionID string, created int64, thinkingEnabled bool, searchEnabled bool) {
+func OpenAIStream(ctx context.Context, w http.ResponseWriter, ds *clients.DeepSeekClient, headers map[string]string, payload map[string]any, model, finalPrompt, completionID string, created int64, thinkingEnabled bool, searchEnabled bool, opts OpenAIOptions) {
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.WriteHeader(http.StatusOK)
 	flusher, _ := w.(http.Flusher)
+	var followed *bool
+	opts.Repair, followed = trackFollowups(opts.Repair)
 
 	for attempt := 0; attempt <= maxRetries; attempt++ {
-		resp, err := ds.CompletionRawStreamRequest(ctx, headers, payload)
+		if attempt > 0 {
+			headers, payload = replayOnRetry(headers, payload, &opts.Replay)
+		}
+		streamCtx, cancel := context.WithCancel(ctx)
+		resp, err := ds.CompletionRawStreamRequest(streamCtx, headers, payload)
 		if err != nil {
+			cancel()
 			if attempt < maxRetries {
 				time.Sleep(retryDelaySeconds * time.Duration(attempt+1))
 				continue
@@ -37,8 +44,53 @@ func OpenAIStream(ctx context.Context, w http.ResponseWriter, ds *clients.DeepSe
 		firstChunk := false
 		sawSSEData := false
 		retryNow := false
+		limiter := newOutputLimiter(opts.Limits)
+		var parser *ToolCallStreamParser
+		if len(opts.Tools) > 0 {
+			maxCalls := 0
+			if !opts.ToolChoice.Parallel {
+				maxCalls = 1
+			}
+			parser = NewToolCallStreamParser(opts.Tools, maxCalls)
+		}
+		gate := newToolGate(opts.Tools, opts.Repair)
+		hold := &textHold{on: opts.ResponseFormat.Enabled()}
+		messageID := 0
+		toolCalls := 0
+		reply := &openAIReply{}
+		writeDelta := func(delta map[string]any) {
+			reply.add(delta)
+			if !firstChunk {
+				delta["role"] = "assistant"
+				firstChunk = true
+			}
+			out := map[string]any{"id": completionID, "object": "chat.completion.chunk", "created": created, "model": model, "choices": []map[string]any{{"delta": delta, "index": 0}}}
+			b, _ := json.Marshal(out)
+			_, _ = fmt.Fprintf(w, "data: %s\n\n", string(b))
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		writeEvents := func(events []ToolStreamEvent) {
+			for _, ev := range events {
+				writeToolEvent(writeDelta, ev)
+				if ev.Kind == "tool_stop" {
+					toolCalls++
+				}
+			}
+		}
+		writeText := func(text string) {
+			if parser == nil {
+				if text != "" {
+					writeEvents(hold.filter([]ToolStreamEvent{{Kind: "text", Text: text}}))
+				}
+				return
+			}
+			writeEvents(hold.filter(gate.filter(parser.Feed(text))))
+		}
 
 		func() {
+			defer cancel()
 			defer resp.Body.Close()
 			scanner := bufio.NewScanner(resp.Body)
 			scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
@@ -50,6 +102,9 @@ func OpenAIStream(ctx context.Context, w http.ResponseWriter, ds *clients.DeepSe
 				if json.Unmarshal([]byte(data), &chunk) != nil {
 					return true
 				}
+				if id, ok := responseMessageID(chunk); ok {
+					messageID = id
+				}
 				var segs []segment
 				ptype, segs, finished = parseChunk(chunk, ptype)
 				for _, seg := range segs {
@@ -57,28 +112,21 @@ func OpenAIStream(ctx context.Context, w http.ResponseWriter, ds *clients.DeepSe
 					if searchEnabled && strings.HasPrefix(v, "[citation:") {
 						continue
 					}
-					delta := map[string]any{}
-					if !firstChunk {
-						delta["role"] = "assistant"
-						firstChunk = true
-					}
 					if seg.Type == "thinking" {
 						if thinkingEnabled {
-							finalThinking += v
-							delta["reasoning_content"] = v
-						}
-					} else {
-						finalText += v
-						delta["content"] = v
-					}
-					if len(delta) > 0 {
-						out := map[string]any{"id": completionID, "object": "chat.completion.chunk", "created": created, "model": model, "choices": []map[string]any{{"delta": delta, "index": 0}}}
-						b, _ := json.Marshal(out)
-						_, _ = fmt.Fprintf(w, "data: %s\n\n", string(b))
-						if flusher != nil {
-							flusher.Flush()
+							if v = limiter.Thinking(v); v != "" {
+								finalThinking += v
+								writeDelta(map[string]any{"reasoning_content": v})
+							}
 						}
+						continue
 					}
+					v = limiter.Feed(v)
+					finalText += v
+					writeText(v)
+				}
+				if limiter.Done() {
+					return false
 				}
 				if finished && !firstChunk && finalText == "" && finalThinking == "" && attempt < maxRetries {
 					retryNow = true
@@ -92,33 +140,27 @@ func OpenAIStream(ctx context.Context, w http.ResponseWriter, ds *clients.DeepSe
 			time.Sleep(retryDelaySeconds * time.Duration(attempt+1))
 			continue
 		}
+		if tail := limiter.Flush(); tail != "" {
+			finalText += tail
+			writeText(tail)
+		}
 		if !sawSSEData {
 			if body, jerr := ds.CompletionJSONRequest(ctx, headers, payload); jerr == nil {
 				jThinking, jText, ok := extractCompletionFromJSON(body)
 				if ok {
-					finalText = jText
 					if thinkingEnabled {
-						finalThinking = jThinking
+						finalThinking = limiter.Thinking(jThinking)
 					}
+					finalText = limiter.Feed(jText) + limiter.Flush()
 					if !firstChunk {
-						delta := map[string]any{"role": "assistant"}
-						if finalText != "" {
-							delta["content"] = finalText
-						}
 						if finalThinking != "" {
-							delta["reasoning_content"] = finalThinking
+							writeDelta(map[string]any{"reasoning_content": finalThinking})
 						}
-						out := map[string]any{"id": completionID, "object": "chat.completion.chunk", "created": created, "model": model, "choices": []map[string]any{{"delta": delta, "index": 0}}}
-						b, _ := json.Marshal(out)
-						_, _ = fmt.Fprintf(w, "data: %s\n\n", string(b))
-						if flusher != nil {
-							flusher.Flush()
-						}
-						firstChunk = true
+						writeText(finalText)
 					}
 				}
 			}
-			if !firstChunk && finalText == "" && finalThinking == "" {
+			if !firstChunk && finalText == "" && finalThinking == "" && !limiter.Done() {
 				if attempt < maxRetries {
 					time.Sleep(retryDelaySeconds * time.Duration(attempt+1))
 					continue
@@ -131,12 +173,12 @@ func OpenAIStream(ctx context.Context, w http.ResponseWriter, ds *clients.DeepSe
 				return
 			}
 		}
-		if !firstChunk && finalText == "" && finalThinking == "" && attempt < maxRetries {
+		if !firstChunk && finalText == "" && finalThinking == "" && !limiter.Done() && attempt < maxRetries {
 			time.Sleep(retryDelaySeconds * time.Duration(attempt+1))
 			continue
 		}
 
-		if !sawSSEData && !firstChunk {
+		if !sawSSEData && !firstChunk && !limiter.Done() {
 			_, _ = fmt.Fprintf(w, "data: %s\n\n", `{"error":"Invalid upstream stream"}`)
 			_, _ = fmt.Fprintf(w, "data: [DONE]\n\n")
 			if flusher != nil {
@@ -145,16 +187,54 @@ func OpenAIStream(ctx context.Context, w http.ResponseWriter, ds *clients.DeepSe
 			return
 		}
 
+		finishReason := openAIFinishReason(limiter.reason)
+		if parser != nil {
+			writeEvents(hold.filter(gate.filter(parser.Finish())))
+			writeEvents(hold.filter(gate.resolve(func(calls []map[string]any, errs []string) ([]map[string]any, bool) {
+				return repairToolCalls(ctx, ds, headers, payload, opts.Tools, opts.Repair, messageID, FormatToolCalls(calls), errs)
+			})))
+			if toolCalls > 0 {
+				finishReason = "tool_calls"
+			}
+		}
+		if hold.on {
+			out, errs, err := enforceResponseFormat(ctx, ds, headers, payload, opts.ResponseFormat, opts.Repair, messageID, hold.take())
+			if err != nil {
+				_, _ = fmt.Fprintf(w, "data: %s\n\n", toJSON(map[string]any{"error": responseFormatError(errs)}))
+				_, _ = fmt.Fprintf(w, "data: [DONE]\n\n")
+				if flusher != nil {
+					flusher.Flush()
+				}
+				return
+			}
+			finalText = out
+			if out != "" {
+				writeDelta(map[string]any{"content": out})
+			}
+		}
+
 		promptTokens := len(finalPrompt) / 4
 		reasoningTokens := len(finalThinking) / 4
 		completionTokens := len(finalText) / 4
-		finish := map[string]any{"id": completionID, "object": "chat.completion.chunk", "created": created, "model": model, "choices": []map[string]any{{"delta": map[string]any{}, "index": 0, "finish_reason": "stop"}}, "usage": map[string]any{"prompt_tokens": promptTokens, "completion_tokens": reasoningTokens + completionTokens, "total_tokens": promptTokens + reasoningTokens + completionTokens, "completion_tokens_details": map[string]any{"reasoning_tokens": reasoningTokens}}}
+		finish := map[string]any{"id": completionID, "object": "chat.completion.chunk", "created": created, "model": model, "choices": []map[string]any{{"delta": map[string]any{}, "index": 0, "finish_reason": finishReason}}, "usage": map[string]any{"prompt_tokens": promptTokens, "completion_tokens": reasoningTokens + completionTokens, "total_tokens": promptTokens + reasoningTokens + completionTokens, "completion_tokens_details": map[string]any{"reasoning_tokens": reasoningTokens}}}
 		b, _ := json.Marshal(finish)
 		_, _ = fmt.Fprintf(w, "data: %s\n\n", string(b))
 		_, _ = fmt.Fprintf(w, "data: [DONE]\n\n")
 		if flusher != nil {
 			flusher.Flush()
 		}
+		reportReply(opts.OnReply, messageID, *followed, reply.message())
 		return
 	}
 }
+
+func writeToolEvent(writeDelta func(map[string]any), ev ToolStreamEvent) {
+	switch ev.Kind {
+	case "text":
+		writeDelta(map[string]any{"content": ev.Text})
+	case "tool_start":
+		writeDelta(map[string]any{"tool_calls": []map[string]any{{"index": ev.Index, "id": newToolCallID(), "type": "function", "function": map[string]any{"name": ev.Name, "arguments": ""}}}})
+	case "tool_delta":
+		writeDelta(map[string]any{"tool_calls": []map[string]any{{"index": ev.Index, "function": map[string]any{"arguments": ev.PartialJSON}}}})
+	}
+}
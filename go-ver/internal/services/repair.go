@@ -0,0 +1,288 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"deepseek2api-go/internal/clients"
+)
+
+type ToolRepair struct {
+	Retries int
+	PoW     func(ctx context.Context) (string, error)
+}
+
+func (r ToolRepair) enabled() bool { return r.Retries > 0 && r.PoW != nil }
+
+func ValidateToolCalls(calls []map[string]any, tools []map[string]any) []string {
+	schemas := map[string]map[string]any{}
+	for _, t := range tools {
+		name, _ := t["name"].(string)
+		if schema, ok := t["input_schema"].(map[string]any); ok && len(schema) > 0 {
+			schemas[name] = schema
+		}
+	}
+	var errs []string
+	for _, c := range calls {
+		name, _ := c["name"].(string)
+		schema, ok := schemas[name]
+		if !ok {
+			continue
+		}
+		input := c["input"]
+		if input == nil {
+			input = map[string]any{}
+		}
+		for _, e := range ValidateSchema(schema, input) {
+			errs = append(errs, name+": "+strings.Replace(e, "$", "input", 1))
+		}
+	}
+	return errs
+}
+
+func hasToolSchemas(tools []map[string]any) bool {
+	for _, t := range tools {
+		if schema, ok := t["input_schema"].(map[string]any); ok && len(schema) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func responseMessageID(chunk map[string]any) (int, bool) {
+	if id, ok := chunk["response_message_id"].(float64); ok {
+		return int(id), true
+	}
+	v, _ := chunk["v"].(map[string]any)
+	resp, _ := v["response"].(map[string]any)
+	if id, ok := resp["message_id"].(float64); ok {
+		return int(id), true
+	}
+	return 0, false
+}
+
+type followupResult struct {
+	Text      string
+	Thinking  string
+	MessageID int
+}
+
+func runFollowup(ctx context.Context, ds *clients.DeepSeekClient, headers map[string]string, payload map[string]any, pow func(ctx context.Context) (string, error), parentID int, prompt string) (followupResult, error) {
+	res := followupResult{}
+	powResp, err := pow(ctx)
+	if err != nil || powResp == "" {
+		return res, errors.New("failed to get PoW for follow-up")
+	}
+	h := make(map[string]string, len(headers))
+	for k, v := range headers {
+		h[k] = v
+	}
+	h["x-ds-pow-response"] = powResp
+	p := make(map[string]any, len(payload))
+	for k, v := range payload {
+		p[k] = v
+	}
+	p["client_stream_id"] = NewClientStreamID()
+	p["prompt"] = prompt
+	if parentID > 0 {
+		p["parent_message_id"] = parentID
+	} else {
+		p["parent_message_id"] = nil
+	}
+	resp, err := ds.CompletionRawStreamRequest(ctx, h, p)
+	if err != nil {
+		return res, err
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	ptype := "text"
+	finished := false
+	ReadSSELines(scanner, func(data string) bool {
+		var chunk map[string]any
+		if json.Unmarshal([]byte(data), &chunk) != nil {
+			return true
+		}
+		if id, ok := responseMessageID(chunk); ok {
+			res.MessageID = id
+		}
+		var segs []segment
+		ptype, segs, finished = parseChunk(chunk, ptype)
+		for _, seg := range segs {
+			if seg.Type == "thinking" {
+				res.Thinking += seg.Text
+			} else {
+				res.Text += seg.Text
+			}
+		}
+		return !finished
+	})
+	if res.Text == "" && res.Thinking == "" {
+		return res, errors.New("empty follow-up completion")
+	}
+	return res, nil
+}
+
+func repairToolCalls(ctx context.Context, ds *clients.DeepSeekClient, headers map[string]string, payload map[string]any, tools []map[string]any, repair ToolRepair, parentID int, lastText string, errs []string) ([]map[string]any, bool) {
+	for i := 0; i < repair.Retries; i++ {
+		instruction := "Your previous tool call did not match the tool's input schema:\n- " + strings.Join(errs, "\n- ") + "\n\nRespond again with ONLY the corrected JSON in the {\"tool_calls\": [...]} format, with inputs that satisfy the schema."
+		prompt := "<｜User｜>" + instruction
+		if parentID <= 0 {
+			base, _ := payload["prompt"].(string)
+			prompt = base + "<｜Assistant｜>" + lastText + "<｜end▁of▁sentence｜>" + prompt
+		}
+		res, err := runFollowup(ctx, ds, headers, payload, repair.PoW, parentID, prompt)
+		if err != nil {
+			return nil, false
+		}
+		parentID = res.MessageID
+		lastText = res.Text
+		calls := DetectToolCalls(res.Text, tools)
+		if len(calls) == 0 {
+			errs = []string{"the response did not contain a tool_calls JSON object"}
+			continue
+		}
+		if errs = ValidateToolCalls(calls, tools); len(errs) == 0 {
+			return calls, true
+		}
+	}
+	return nil, false
+}
+
+func resolveToolCalls(ctx context.Context, ds *clients.DeepSeekClient, headers map[string]string, payload map[string]any, text string, tools []map[string]any, repair ToolRepair, messageID int) ([]map[string]any, string) {
+	ext := ExtractToolCalls(text, tools)
+	if len(ext.Calls) == 0 {
+		return nil, text
+	}
+	errs := ValidateToolCalls(ext.Calls, tools)
+	if len(errs) == 0 {
+		return ext.Calls, ext.Text
+	}
+	if !repair.enabled() {
+		return nil, text
+	}
+	fixed, ok := repairToolCalls(ctx, ds, headers, payload, tools, repair, messageID, text, errs)
+	if !ok {
+		return nil, text
+	}
+	return fixed, ext.Text
+}
+
+// toolGate holds streamed tool calls back until they can be checked against
+// the tool schemas, the same check the non-stream replies make. Calls that
+// fail it are repaired when repair is on and fall back to text otherwise.
+type toolGate struct {
+	tools   []map[string]any
+	enabled bool
+	repair  bool
+	holding bool
+	held    []ToolStreamEvent
+}
+
+func newToolGate(tools []map[string]any, repair ToolRepair) *toolGate {
+	return &toolGate{tools: tools, enabled: hasToolSchemas(tools), repair: repair.enabled()}
+}
+
+func (g *toolGate) filter(events []ToolStreamEvent) []ToolStreamEvent {
+	if !g.enabled {
+		return events
+	}
+	out := make([]ToolStreamEvent, 0, len(events))
+	for _, ev := range events {
+		if !g.holding && ev.Kind == "text" {
+			out = append(out, ev)
+			continue
+		}
+		g.holding = true
+		g.held = append(g.held, ev)
+	}
+	return out
+}
+
+func (g *toolGate) resolve(fix func(calls []map[string]any, errs []string) ([]map[string]any, bool)) []ToolStreamEvent {
+	if !g.enabled || len(g.held) == 0 {
+		return nil
+	}
+	calls := make([]map[string]any, 0)
+	for _, ev := range g.held {
+		if ev.Kind == "tool_stop" {
+			calls = append(calls, map[string]any{"name": ev.Name, "input": ev.Input})
+		}
+	}
+	errs := ValidateToolCalls(calls, g.tools)
+	if len(errs) == 0 {
+		return g.held
+	}
+	if g.repair {
+		if fixed, ok := fix(calls, errs); ok {
+			return toolCallEvents(fixed)
+		}
+	}
+	out := make([]ToolStreamEvent, 0, len(g.held))
+	for _, ev := range g.held {
+		if ev.Kind == "text" || ev.Kind == "envelope" {
+			out = append(out, ToolStreamEvent{Kind: "text", Text: ev.Text})
+		}
+	}
+	return out
+}
+
+// toolCallEvents streams complete calls, each as a single input delta.
+func toolCallEvents(calls []map[string]any) []ToolStreamEvent {
+	out := make([]ToolStreamEvent, 0, len(calls)*3)
+	for i, c := range calls {
+		name, _ := c["name"].(string)
+		input := c["input"]
+		if input == nil {
+			input = map[string]any{}
+		}
+		out = append(out,
+			ToolStreamEvent{Kind: "tool_start", Index: i, Name: name},
+			ToolStreamEvent{Kind: "tool_delta", Index: i, Name: name, PartialJSON: toJSON(input)},
+			ToolStreamEvent{Kind: "tool_stop", Index: i, Name: name, Input: input},
+		)
+	}
+	return out
+}
+
+// textHold keeps streamed text back while the reply still has to be checked
+// as a whole: for response_format, or for a forced tool call. The first tool
+// call releases the held text ahead of it and ends the hold.
+type textHold struct {
+	on   bool
+	text strings.Builder
+}
+
+func (h *textHold) filter(events []ToolStreamEvent) []ToolStreamEvent {
+	if !h.on {
+		return events
+	}
+	out := make([]ToolStreamEvent, 0, len(events))
+	for i, ev := range events {
+		switch ev.Kind {
+		case "text":
+			h.text.WriteString(ev.Text)
+		case "tool_start":
+			h.on = false
+			if h.text.Len() > 0 {
+				out = append(out, ToolStreamEvent{Kind: "text", Text: h.text.String()})
+				h.text.Reset()
+			}
+			return append(out, events[i:]...)
+		default:
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+// take ends the hold and returns the text held back.
+func (h *textHold) take() string {
+	h.on = false
+	s := h.text.String()
+	h.text.Reset()
+	return s
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"deepseek2api-go/internal/clients"
+)
+
+type ResponseFormat struct {
+	Type   string
+	Name   string
+	Schema map[string]any
+}
+
+func ParseResponseFormat(v any) ResponseFormat {
+	m, _ := v.(map[string]any)
+	typ, _ := m["type"].(string)
+	rf := ResponseFormat{Type: strings.ToLower(strings.TrimSpace(typ))}
+	switch rf.Type {
+	case "json_object":
+	case "json_schema":
+		js, _ := m["json_schema"].(map[string]any)
+		rf.Name, _ = js["name"].(string)
+		rf.Schema, _ = js["schema"].(map[string]any)
+	default:
+		return ResponseFormat{}
+	}
+	return rf
+}
+
+func (f ResponseFormat) Enabled() bool { return f.Type != "" }
+
+func BuildResponseFormatPrompt(f ResponseFormat) string {
+	var sb strings.Builder
+	sb.WriteString("Respond with a single valid JSON object and nothing else: no prose, no explanations and no markdown code fences.")
+	if len(f.Schema) > 0 {
+		sb.WriteString("\nThe JSON object MUST conform to this JSON Schema")
+		if f.Name != "" {
+			sb.WriteString(" (\"" + f.Name + "\")")
+		}
+		sb.WriteString(":\n" + toJSON(f.Schema))
+		sb.WriteString("\nInclude every required property and do not add properties the schema does not allow.")
+	}
+	return sb.String()
+}
+
+func StripCodeFences(text string) string {
+	s := strings.TrimSpace(text)
+	if m := toolFenceRe.FindStringSubmatch(s); m != nil && strings.HasPrefix(s, "```") {
+		return strings.TrimSpace(m[1])
+	}
+	return s
+}
+
+func (f ResponseFormat) Validate(text string) (string, []string) {
+	s := StripCodeFences(text)
+	if start := strings.IndexAny(s, "{["); start > 0 {
+		if end := scanJSONEnd(s, start); end > 0 {
+			s = s[start:end]
+		}
+	}
+	var v any
+	if json.Unmarshal([]byte(s), &v) != nil {
+		parsed, ok := parseLenientJSON(s)
+		if !ok {
+			return s, []string{"the response is not valid JSON"}
+		}
+		v = parsed
+		s = toJSON(v)
+	}
+	if _, ok := v.(map[string]any); !ok && len(f.Schema) == 0 {
+		return s, []string{"the response must be a JSON object, got " + jsonTypeName(v)}
+	}
+	if len(f.Schema) == 0 {
+		return s, nil
+	}
+	errs := ValidateSchema(f.Schema, v)
+	for i, e := range errs {
+		errs[i] = strings.Replace(e, "$", "response", 1)
+	}
+	return s, errs
+}
+
+var errResponseFormat = errors.New("model output did not match response_format")
+
+func responseFormatError(errs []string) string {
+	msg := "Model output did not match response_format"
+	if len(errs) > 0 {
+		msg += ": " + strings.Join(errs, "; ")
+	}
+	return msg
+}
+
+func enforceResponseFormat(ctx context.Context, ds *clients.DeepSeekClient, headers map[string]string, payload map[string]any, f ResponseFormat, repair ToolRepair, parentID int, text string) (string, []string, error) {
+	out, errs := f.Validate(text)
+	if len(errs) == 0 {
+		return out, nil, nil
+	}
+	if !repair.enabled() {
+		return "", errs, errResponseFormat
+	}
+	for i := 0; i < repair.Retries; i++ {
+		instruction := "Your previous answer was not valid for the required JSON format:\n- " + strings.Join(errs, "\n- ") + "\n\nRespond again with ONLY the corrected JSON object."
+		prompt := "<｜User｜>" + instruction
+		if parentID <= 0 {
+			base, _ := payload["prompt"].(string)
+			prompt = base + "<｜Assistant｜>" + text + "<｜end▁of▁sentence｜>" + prompt
+		}
+		res, err := runFollowup(ctx, ds, headers, payload, repair.PoW, parentID, prompt)
+		if err != nil {
+			return "", errs, err
+		}
+		parentID = res.MessageID
+		text = res.Text
+		if out, errs = f.Validate(text); len(errs) == 0 {
+			return out, nil, nil
+		}
+	}
+	return "", errs, errResponseFormat
+}
+
+func forcedToolCallError(errs []string) string {
+	msg := "Model did not produce the required tool call"
+	if len(errs) > 0 {
+		msg += ": " + strings.Join(errs, "; ")
+	}
+	return msg
+}
+
+func forceToolCall(ctx context.Context, ds *clients.DeepSeekClient, headers map[string]string, payload map[string]any, tools []map[string]any, opts ClaudeOptions, parentID int, text string) ([]map[string]any, []string, error) {
+	if len(tools) == 1 {
+		name, _ := tools[0]["name"].(string)
+		schema, _ := tools[0]["input_schema"].(map[string]any)
+		out, errs := ResponseFormat{Type: "json_schema", Name: name, Schema: schema}.Validate(text)
+		var input map[string]any
+		if len(errs) == 0 && json.Unmarshal([]byte(out), &input) == nil {
+			return []map[string]any{{"name": name, "input": input}}, nil, nil
+		}
+	}
+	errs := []string{"the response did not contain a tool_calls JSON object"}
+	if !opts.Repair.enabled() {
+		return nil, errs, errResponseFormat
+	}
+	if calls, ok := repairToolCalls(ctx, ds, headers, payload, tools, opts.Repair, parentID, text, errs); ok {
+		return calls, nil, nil
+	}
+	return nil, errs, errResponseFormat
+}
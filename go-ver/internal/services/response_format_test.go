@@ -0,0 +1,193 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"deepseek2api-go/internal/clients"
+)
+
+func TestResponseFormatValidate(t *testing.T) {
+	schema := ParseResponseFormat(map[string]any{"type": "json_schema", "json_schema": map[string]any{
+		"name":   "person",
+		"strict": true,
+		"schema": map[string]any{
+			"type":       "object",
+			"required":   []any{"name", "age"},
+			"properties": map[string]any{"name": map[string]any{"type": "string"}, "age": map[string]any{"type": "integer"}},
+		},
+	}})
+	object := ParseResponseFormat(map[string]any{"type": "json_object"})
+	if ParseResponseFormat(map[string]any{"type": "text"}).Enabled() {
+		t.Fatal("text format should not be enabled")
+	}
+
+	cases := []struct {
+		format ResponseFormat
+		input  string
+		out    string
+		errs   int
+	}{
+		{schema, `{"name": "Ann", "age": 3}`, `{"name": "Ann", "age": 3}`, 0},
+		{schema, "```json\n{\"name\": \"Ann\", \"age\": 3}\n```", `{"name": "Ann", "age": 3}`, 0},
+		{schema, "Sure! Here it is:\n{\"name\": \"Ann\", \"age\": 3}", `{"name": "Ann", "age": 3}`, 0},
+		{schema, `{'name': 'Ann', 'age': 3,}`, `{"age":3,"name":"Ann"}`, 0},
+		{schema, `{"name": "Ann"}`, `{"name": "Ann"}`, 1},
+		{schema, `{"name": "Ann", "age": "3"}`, `{"name": "Ann", "age": "3"}`, 1},
+		{schema, `I cannot do that.`, `I cannot do that.`, 1},
+		{object, `{"anything": [1, 2]}`, `{"anything": [1, 2]}`, 0},
+		{object, `[1, 2]`, `[1, 2]`, 1},
+	}
+	for _, c := range cases {
+		out, errs := c.format.Validate(c.input)
+		if len(errs) != c.errs {
+			t.Fatalf("input %q: expected %d errors, got %v", c.input, c.errs, errs)
+		}
+		if out != c.out {
+			t.Fatalf("input %q: expected output %q, got %q", c.input, c.out, out)
+		}
+	}
+}
+
+func TestParseClaudeToolChoice(t *testing.T) {
+	tc := ParseClaudeToolChoice(map[string]any{"type": "tool", "name": "extract", "disable_parallel_tool_use": true})
+	if tc.Mode != "required" || tc.Name != "extract" || tc.Parallel {
+		t.Fatalf("unexpected tool choice %+v", tc)
+	}
+	if tc := ParseClaudeToolChoice(map[string]any{"type": "any"}); tc.Mode != "required" || tc.Name != "" {
+		t.Fatalf("unexpected tool choice %+v", tc)
+	}
+	if tc := ParseClaudeToolChoice(nil); tc != DefaultToolChoice() {
+		t.Fatalf("unexpected tool choice %+v", tc)
+	}
+}
+
+// newUpstream streams events as the completion, then finishes it.
+func newUpstream(t *testing.T, events ...map[string]any) *clients.DeepSeekClient {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, ev := range events {
+			fmt.Fprintf(w, "data: %s\n\n", toJSON(ev))
+		}
+		fmt.Fprint(w, "data: {\"p\":\"response/status\",\"v\":\"FINISHED\"}\n\n")
+	}))
+	t.Cleanup(ts.Close)
+	return clients.NewDeepSeekClient(ts.Client(), ts.URL, ts.URL, ts.URL)
+}
+
+// newReplyUpstream streams text as the completion, a few bytes per chunk.
+func newReplyUpstream(t *testing.T, text string) *clients.DeepSeekClient {
+	events := []map[string]any{{"request_message_id": 1, "response_message_id": 2}}
+	for i := 0; i < len(text); i += 3 {
+		events = append(events, map[string]any{"p": "response/content", "v": text[i:min(i+3, len(text))]})
+	}
+	return newUpstream(t, events...)
+}
+
+func sseEvents(t *testing.T, body string) []map[string]any {
+	t.Helper()
+	var out []map[string]any
+	sc := bufio.NewScanner(strings.NewReader(body))
+	for sc.Scan() {
+		data, ok := strings.CutPrefix(sc.Text(), "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var ev map[string]any
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			t.Fatalf("bad event %q", data)
+		}
+		out = append(out, ev)
+	}
+	return out
+}
+
+func TestOpenAIStreamAppliesResponseFormat(t *testing.T) {
+	cases := []struct {
+		upstream string
+		content  string
+		err      bool
+	}{
+		{"```json\n{\"a\": 1}\n```", `{"a": 1}`, false},
+		{"Sure: {\"a\": 1}", `{"a": 1}`, false},
+		{"I cannot do that.", "", true},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		opts := OpenAIOptions{ResponseFormat: ParseResponseFormat(map[string]any{"type": "json_object"})}
+		OpenAIStream(context.Background(), rec, newReplyUpstream(t, c.upstream), map[string]string{}, map[string]any{}, "m", "p", "id", 1, false, false, opts)
+		content, failed := "", false
+		for _, ev := range sseEvents(t, rec.Body.String()) {
+			if _, ok := ev["error"]; ok {
+				failed = true
+				continue
+			}
+			delta, _ := ev["choices"].([]any)[0].(map[string]any)["delta"].(map[string]any)
+			if s, ok := delta["content"].(string); ok {
+				content += s
+			}
+		}
+		if content != c.content || failed != c.err {
+			t.Fatalf("upstream %q: content %q, error %v", c.upstream, content, failed)
+		}
+	}
+}
+
+func TestClaudeStreamHonoursToolChoice(t *testing.T) {
+	tools := []map[string]any{{"name": "get", "input_schema": map[string]any{
+		"type": "object", "required": []any{"city"}, "properties": map[string]any{"city": map[string]any{"type": "string"}},
+	}}}
+	forced := ToolChoice{Mode: "required", Parallel: true}
+	single := ToolChoice{Mode: "auto", Parallel: false}
+	cases := []struct {
+		name     string
+		choice   ToolChoice
+		upstream string
+		calls    []string
+		text     string
+		err      bool
+	}{
+		{"forced from bare input", forced, `{"city": "SF"}`, []string{`{"city":"SF"}`}, "", false},
+		{"forced keeps prose before call", forced, `Checking. {"tool_calls": [{"name": "get", "input": {"city": "SF"}}]}`, []string{`{"city": "SF"}`}, "Checking. ", false},
+		{"forced without call", forced, "No tool needed.", nil, "", true},
+		{"parallel disabled", single, `{"tool_calls": [{"name": "get", "input": {"city": "A"}}, {"name": "get", "input": {"city": "B"}}]}`, []string{`{"city": "A"}`}, "", false},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		ClaudeStream(context.Background(), rec, newReplyUpstream(t, c.upstream), map[string]string{}, map[string]any{}, "m", nil, tools, ClaudeOptions{ToolChoice: c.choice})
+		var calls []string
+		text, failed, stop := "", false, ""
+		for _, ev := range sseEvents(t, rec.Body.String()) {
+			switch ev["type"] {
+			case "error":
+				failed = true
+			case "content_block_start":
+				if ev["content_block"].(map[string]any)["type"] == "tool_use" {
+					calls = append(calls, "")
+				}
+			case "content_block_delta":
+				delta := ev["delta"].(map[string]any)
+				switch delta["type"] {
+				case "input_json_delta":
+					calls[len(calls)-1] += delta["partial_json"].(string)
+				case "text_delta":
+					text += delta["text"].(string)
+				}
+			case "message_delta":
+				stop, _ = ev["delta"].(map[string]any)["stop_reason"].(string)
+			}
+		}
+		if failed != c.err || text != c.text || strings.Join(calls, "|") != strings.Join(c.calls, "|") {
+			t.Fatalf("%s: calls %q, text %q, error %v", c.name, calls, text, failed)
+		}
+		if !c.err && stop != "tool_use" {
+			t.Fatalf("%s: stop_reason %q", c.name, stop)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"deepseek2api-go/internal/clients"
+)
+
+func NewResponseID() string { return "resp_" + randomHex16() + randomHex16() }
+
+func newItemID(prefix string) string { return prefix + "_" + randomHex16() + randomHex16() }
+
+func ResponsesInputToMessages(input any) []map[string]any {
+	switch v := input.(type) {
+	case string:
+		return []map[string]any{{"role": "user", "content": v}}
+	case []map[string]any:
+		items := make([]any, 0, len(v))
+		for _, it := range v {
+			items = append(items, it)
+		}
+		return ResponsesInputToMessages(items)
+	case []any:
+		out := make([]map[string]any, 0, len(v))
+		for _, it := range v {
+			item, ok := it.(map[string]any)
+			if !ok {
+				continue
+			}
+			typ, _ := item["type"].(string)
+			switch {
+			case typ == "function_call":
+				call := map[string]any{"id": item["call_id"], "type": "function", "function": map[string]any{"name": item["name"], "arguments": item["arguments"]}}
+				if n := len(out); n > 0 && out[n-1]["role"] == "assistant" {
+					calls, _ := out[n-1]["tool_calls"].([]any)
+					out[n-1]["tool_calls"] = append(calls, call)
+					continue
+				}
+				out = append(out, map[string]any{"role": "assistant", "content": nil, "tool_calls": []any{call}})
+			case typ == "function_call_output":
+				out = append(out, map[string]any{"role": "tool", "tool_call_id": item["call_id"], "content": responsesText(item["output"])})
+			case typ == "message" || (typ == "" && item["role"] != nil):
+				role, _ := item["role"].(string)
+				if role == "developer" {
+					role = "system"
+				}
+				out = append(out, map[string]any{"role": role, "content": responsesText(item["content"])})
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func responsesText(v any) string {
+	switch c := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.ToValidUTF8(c, "")
+	case []any:
+		parts := make([]string, 0, len(c))
+		for _, it := range c {
+			p, ok := it.(map[string]any)
+			if !ok {
+				continue
+			}
+			switch typ, _ := p["type"].(string); typ {
+			case "input_text", "output_text", "text":
+				t, _ := p["text"].(string)
+				parts = append(parts, strings.ToValidUTF8(t, ""))
+			case "input_image":
+				parts = append(parts, "[image]")
+			case "input_file":
+				parts = append(parts, "[file]")
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return toJSON(v)
+}
+
+func ParseResponsesToolChoice(choice any, parallel any) ToolChoice {
+	if m, ok := choice.(map[string]any); ok {
+		if name, _ := m["name"].(string); name != "" {
+			choice = map[string]any{"type": "function", "function": map[string]any{"name": name}}
+		}
+	}
+	return ParseOpenAIToolChoice(choice, parallel)
+}
+
+func ParseResponsesTextFormat(text any) ResponseFormat {
+	m, _ := text.(map[string]any)
+	format, _ := m["format"].(map[string]any)
+	if typ, _ := format["type"].(string); typ == "json_schema" {
+		return ParseResponseFormat(map[string]any{"type": typ, "json_schema": format})
+	}
+	return ParseResponseFormat(format)
+}
+
+func reasoningItem(id, text string) map[string]any {
+	return map[string]any{"type": "reasoning", "id": id, "summary": []any{}, "content": []map[string]any{{"type": "reasoning_text", "text": text}}}
+}
+
+func messageItem(id, text, status string) map[string]any {
+	return map[string]any{"type": "message", "id": id, "status": status, "role": "assistant", "content": []map[string]any{outputTextPart(text)}}
+}
+
+func outputTextPart(text string) map[string]any {
+	return map[string]any{"type": "output_text", "text": text, "annotations": []any{}}
+}
+
+func functionCallItem(id string, callID, name, arguments any, status string) map[string]any {
+	return map[string]any{"type": "function_call", "id": id, "call_id": callID, "name": name, "arguments": arguments, "status": status}
+}
+
+func responsesUsage(v any) map[string]any {
+	m, _ := v.(map[string]any)
+	details, _ := m["completion_tokens_details"].(map[string]any)
+	in, out := intValue(m["prompt_tokens"]), intValue(m["completion_tokens"])
+	return map[string]any{"input_tokens": in, "output_tokens": out, "total_tokens": in + out, "output_tokens_details": map[string]any{"reasoning_tokens": intValue(details["reasoning_tokens"])}}
+}
+
+func finishResponse(resp map[string]any, output []map[string]any, finishReason any, usage any) map[string]any {
+	resp["status"] = "completed"
+	if finishReason == "length" {
+		resp["status"] = "incomplete"
+		resp["incomplete_details"] = map[string]any{"reason": "max_output_tokens"}
+	}
+	text := ""
+	for _, item := range output {
+		if item["type"] != "message" {
+			continue
+		}
+		for _, part := range item["content"].([]map[string]any) {
+			t, _ := part["text"].(string)
+			text += t
+		}
+	}
+	resp["output"] = output
+	resp["output_text"] = text
+	resp["usage"] = responsesUsage(usage)
+	return resp
+}
+
+func copyResponse(base map[string]any) map[string]any {
+	resp := make(map[string]any, len(base)+4)
+	for k, v := range base {
+		resp[k] = v
+	}
+	return resp
+}
+
+func ResponsesNonStream(ctx context.Context, ds *clients.DeepSeekClient, headers map[string]string, payload map[string]any, model, finalPrompt string, thinkingEnabled bool, searchEnabled bool, opts OpenAIOptions, base map[string]any) (int, map[string]any) {
+	id, _ := base["id"].(string)
+	created := intValue(base["created_at"])
+	status, chat := OpenAINonStream(ctx, ds, headers, payload, model, finalPrompt, id, int64(created), thinkingEnabled, searchEnabled, opts)
+	if status != http.StatusOK {
+		return status, chat
+	}
+	choice := chat["choices"].([]map[string]any)[0]
+	msg, _ := choice["message"].(map[string]any)
+	output := make([]map[string]any, 0, 2)
+	if r, _ := msg["reasoning_content"].(string); r != "" {
+		output = append(output, reasoningItem(newItemID("rs"), r))
+	}
+	if c, _ := msg["content"].(string); c != "" {
+		output = append(output, messageItem(newItemID("msg"), c, "completed"))
+	}
+	calls, _ := msg["tool_calls"].([]map[string]any)
+	for _, tc := range calls {
+		fn, _ := tc["function"].(map[string]any)
+		output = append(output, functionCallItem(newItemID("fc"), tc["id"], fn["name"], fn["arguments"], "completed"))
+	}
+	return http.StatusOK, finishResponse(copyResponse(base), output, choice["finish_reason"], chat["usage"])
+}
+
+type responsesEmitter struct {
+	w            http.ResponseWriter
+	flusher      http.Flusher
+	seq          int
+	output       []map[string]any
+	open         string
+	text         strings.Builder
+	finishReason any
+}
+
+func (e *responsesEmitter) event(typ string, payload map[string]any) {
+	payload["type"] = typ
+	payload["sequence_number"] = e.seq
+	e.seq++
+	_, _ = fmt.Fprintf(e.w, "event: %s\ndata: %s\n\n", typ, toJSON(payload))
+	if e.flusher != nil {
+		e.flusher.Flush()
+	}
+}
+
+func (e *responsesEmitter) current() (int, map[string]any) {
+	i := len(e.output) - 1
+	return i, e.output[i]
+}
+
+func (e *responsesEmitter) start(kind string, item map[string]any) {
+	e.closeOpen()
+	e.open = kind
+	e.text.Reset()
+	e.output = append(e.output, item)
+	i, _ := e.current()
+	e.event("response.output_item.added", map[string]any{"output_index": i, "item": item})
+	if kind == "message" {
+		e.event("response.content_part.added", map[string]any{"item_id": item["id"], "output_index": i, "content_index": 0, "part": outputTextPart("")})
+	}
+}
+
+func (e *responsesEmitter) closeOpen() {
+	if e.open == "" {
+		return
+	}
+	i, item := e.current()
+	text := e.text.String()
+	switch e.open {
+	case "reasoning":
+		item["content"] = []map[string]any{{"type": "reasoning_text", "text": text}}
+		e.event("response.reasoning_text.done", map[string]any{"item_id": item["id"], "output_index": i, "content_index": 0, "text": text})
+	case "message":
+		item["status"] = "completed"
+		item["content"] = []map[string]any{outputTextPart(text)}
+		e.event("response.output_text.done", map[string]any{"item_id": item["id"], "output_index": i, "content_index": 0, "text": text})
+		e.event("response.content_part.done", map[string]any{"item_id": item["id"], "output_index": i, "content_index": 0, "part": outputTextPart(text)})
+	case "function_call":
+		item["status"] = "completed"
+		item["arguments"] = text
+		e.event("response.function_call_arguments.done", map[string]any{"item_id": item["id"], "output_index": i, "arguments": text})
+	}
+	e.event("response.output_item.done", map[string]any{"output_index": i, "item": item})
+	e.open = ""
+}
+
+func (e *responsesEmitter) chunk(c map[string]any) {
+	choices, _ := c["choices"].([]any)
+	if len(choices) == 0 {
+		return
+	}
+	first, _ := choices[0].(map[string]any)
+	if fr := first["finish_reason"]; fr != nil {
+		e.finishReason = fr
+	}
+	delta, _ := first["delta"].(map[string]any)
+	if r, _ := delta["reasoning_content"].(string); r != "" {
+		if e.open != "reasoning" {
+			e.start("reasoning", map[string]any{"type": "reasoning", "id": newItemID("rs"), "summary": []any{}, "content": []any{}})
+		}
+		i, item := e.current()
+		e.text.WriteString(r)
+		e.event("response.reasoning_text.delta", map[string]any{"item_id": item["id"], "output_index": i, "content_index": 0, "delta": r})
+	}
+	if t, _ := delta["content"].(string); t != "" {
+		if e.open != "message" {
+			e.start("message", map[string]any{"type": "message", "id": newItemID("msg"), "status": "in_progress", "role": "assistant", "content": []any{}})
+		}
+		i, item := e.current()
+		e.text.WriteString(t)
+		e.event("response.output_text.delta", map[string]any{"item_id": item["id"], "output_index": i, "content_index": 0, "delta": t})
+	}
+	calls, _ := delta["tool_calls"].([]any)
+	for _, it := range calls {
+		tc, _ := it.(map[string]any)
+		fn, _ := tc["function"].(map[string]any)
+		if callID, ok := tc["id"].(string); ok {
+			e.start("function_call", functionCallItem(newItemID("fc"), callID, fn["name"], "", "in_progress"))
+		}
+		if args, _ := fn["arguments"].(string); args != "" && e.open == "function_call" {
+			i, item := e.current()
+			e.text.WriteString(args)
+			e.event("response.function_call_arguments.delta", map[string]any{"item_id": item["id"], "output_index": i, "delta": args})
+		}
+	}
+}
+
+func ResponsesStream(ctx context.Context, w http.ResponseWriter, ds *clients.DeepSeekClient, headers map[string]string, payload map[string]any, model, finalPrompt string, thinkingEnabled bool, searchEnabled bool, opts OpenAIOptions, base map[string]any) map[string]any {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	em := &responsesEmitter{w: w, flusher: flusher}
+	em.event("response.created", map[string]any{"response": base})
+	em.event("response.in_progress", map[string]any{"response": base})
+
+	id, _ := base["id"].(string)
+	bw := &branchWriter{mux: &streamMux{w: w, flusher: flusher}, header: http.Header{}, rewrite: func(c map[string]any) map[string]any {
+		em.chunk(c)
+		return nil
+	}}
+	OpenAIStream(ctx, bw, ds, headers, payload, model, finalPrompt, id, int64(intValue(base["created_at"])), thinkingEnabled, searchEnabled, opts)
+	em.closeOpen()
+
+	resp := copyResponse(base)
+	if bw.err != "" || !bw.finished {
+		resp["status"] = "failed"
+		resp["output"] = em.output
+		resp["error"] = map[string]any{"code": "server_error", "message": firstNonEmpty(bw.err, "Upstream stream ended before completion.")}
+		em.event("response.failed", map[string]any{"response": resp})
+		return resp
+	}
+	resp = finishResponse(resp, em.output, em.finishReason, bw.usage)
+	if resp["status"] == "incomplete" {
+		em.event("response.incomplete", map[string]any{"response": resp})
+	} else {
+		em.event("response.completed", map[string]any{"response": resp})
+	}
+	return resp
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResponsesInputToMessages(t *testing.T) {
+	input := []any{
+		map[string]any{"type": "message", "role": "developer", "content": "be brief"},
+		map[string]any{"role": "user", "content": []any{map[string]any{"type": "input_text", "text": "weather?"}}},
+		map[string]any{"type": "reasoning", "id": "rs_1"},
+		map[string]any{"type": "function_call", "call_id": "call_1", "name": "get", "arguments": `{"city":"SF"}`},
+		map[string]any{"type": "function_call", "call_id": "call_2", "name": "get", "arguments": `{"city":"LA"}`},
+		map[string]any{"type": "function_call_output", "call_id": "call_1", "output": "sunny"},
+	}
+	got := ResponsesInputToMessages(input)
+	if len(got) != 4 {
+		t.Fatalf("expected 4 messages, got %v", got)
+	}
+	if got[0]["role"] != "system" || got[1]["content"] != "weather?" {
+		t.Fatalf("unexpected messages %v", got)
+	}
+	if calls := got[2]["tool_calls"].([]any); got[2]["role"] != "assistant" || len(calls) != 2 {
+		t.Fatalf("expected merged assistant tool calls, got %v", got[2])
+	}
+	if got[3]["role"] != "tool" || got[3]["tool_call_id"] != "call_1" || got[3]["content"] != "sunny" {
+		t.Fatalf("unexpected tool output %v", got[3])
+	}
+	if msgs := ResponsesInputToMessages("hi"); len(msgs) != 1 || msgs[0]["role"] != "user" {
+		t.Fatalf("unexpected string input %v", msgs)
+	}
+}
+
+// thinkingReply is a reply with reasoning ahead of its text.
+var thinkingReply = []map[string]any{
+	{"p": "response/thinking_content", "v": "Let me think."},
+	{"p": "response/content", "v": "Hello"},
+	{"v": " there"},
+}
+
+func TestResponsesNonStream(t *testing.T) {
+	ds := newUpstream(t, thinkingReply...)
+	base := map[string]any{"id": "resp_1", "object": "response", "created_at": int64(1), "status": "in_progress"}
+	status, out := ResponsesNonStream(context.Background(), ds, map[string]string{}, map[string]any{}, "m", "prompt", true, false, OpenAIOptions{}, base)
+	if status != http.StatusOK || out["status"] != "completed" || out["output_text"] != "Hello there" {
+		t.Fatalf("unexpected response %d %v", status, out)
+	}
+	output := out["output"].([]map[string]any)
+	if len(output) != 2 || output[0]["type"] != "reasoning" || output[1]["type"] != "message" {
+		t.Fatalf("unexpected output %v", output)
+	}
+	if base["status"] != "in_progress" {
+		t.Fatal("base response was modified")
+	}
+}
+
+func TestResponsesStream(t *testing.T) {
+	ds := newUpstream(t, thinkingReply...)
+	rec := httptest.NewRecorder()
+	base := map[string]any{"id": "resp_1", "object": "response", "created_at": int64(1), "status": "in_progress"}
+	out := ResponsesStream(context.Background(), rec, ds, map[string]string{}, map[string]any{}, "m", "prompt", true, false, OpenAIOptions{}, base)
+	if out["status"] != "completed" || out["output_text"] != "Hello there" {
+		t.Fatalf("unexpected final response %v", out)
+	}
+	var events []string
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if strings.HasPrefix(line, "event: ") {
+			events = append(events, strings.TrimPrefix(line, "event: "))
+		}
+	}
+	want := []string{
+		"response.created", "response.in_progress",
+		"response.output_item.added", "response.reasoning_text.delta", "response.reasoning_text.done", "response.output_item.done",
+		"response.output_item.added", "response.content_part.added", "response.output_text.delta", "response.output_text.delta",
+		"response.output_text.done", "response.content_part.done", "response.output_item.done",
+		"response.completed",
+	}
+	if strings.Join(events, ",") != strings.Join(want, ",") {
+		t.Fatalf("unexpected events:\n%v", events)
+	}
+}
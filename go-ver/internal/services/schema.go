@@ -0,0 +1,244 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+func ValidateSchema(schema map[string]any, v any) []string {
+	var errs []string
+	validateSchemaAt(schema, schema, v, "$", &errs)
+	return errs
+}
+
+func validateSchemaAt(root, schema map[string]any, v any, path string, errs *[]string) {
+	if len(schema) == 0 {
+		return
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		if target := resolveSchemaRef(root, ref); target != nil {
+			validateSchemaAt(root, target, v, path, errs)
+		}
+		return
+	}
+	if v == nil {
+		if nullable, _ := schema["nullable"].(bool); nullable {
+			return
+		}
+	}
+	if types := schemaTypes(schema["type"]); len(types) > 0 {
+		matched := false
+		for _, t := range types {
+			if jsonTypeMatches(t, v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			*errs = append(*errs, fmt.Sprintf("%s: expected %s, got %s", path, strings.Join(types, " or "), jsonTypeName(v)))
+			return
+		}
+	}
+	if enum, ok := schema["enum"].([]any); ok && len(enum) > 0 {
+		found := false
+		for _, e := range enum {
+			if jsonEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			*errs = append(*errs, fmt.Sprintf("%s: must be one of %s", path, toJSON(enum)))
+		}
+	}
+	if c, ok := schema["const"]; ok && !jsonEqual(c, v) {
+		*errs = append(*errs, fmt.Sprintf("%s: must equal %s", path, toJSON(c)))
+	}
+	for _, sub := range schemaList(schema["allOf"]) {
+		validateSchemaAt(root, sub, v, path, errs)
+	}
+	if subs := schemaList(schema["anyOf"]); len(subs) > 0 && countSchemaMatches(root, subs, v, path) == 0 {
+		*errs = append(*errs, fmt.Sprintf("%s: does not match any allowed schema", path))
+	}
+	if subs := schemaList(schema["oneOf"]); len(subs) > 0 && countSchemaMatches(root, subs, v, path) != 1 {
+		*errs = append(*errs, fmt.Sprintf("%s: must match exactly one allowed schema", path))
+	}
+
+	switch vv := v.(type) {
+	case map[string]any:
+		validateObject(root, schema, vv, path, errs)
+	case []any:
+		if minItems, ok := schema["minItems"].(float64); ok && float64(len(vv)) < minItems {
+			*errs = append(*errs, fmt.Sprintf("%s: must have at least %d items", path, int(minItems)))
+		}
+		if maxItems, ok := schema["maxItems"].(float64); ok && float64(len(vv)) > maxItems {
+			*errs = append(*errs, fmt.Sprintf("%s: must have at most %d items", path, int(maxItems)))
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, it := range vv {
+				validateSchemaAt(root, items, it, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	case string:
+		n := float64(len([]rune(vv)))
+		if minLen, ok := schema["minLength"].(float64); ok && n < minLen {
+			*errs = append(*errs, fmt.Sprintf("%s: must be at least %d characters", path, int(minLen)))
+		}
+		if maxLen, ok := schema["maxLength"].(float64); ok && n > maxLen {
+			*errs = append(*errs, fmt.Sprintf("%s: must be at most %d characters", path, int(maxLen)))
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(vv) {
+				*errs = append(*errs, fmt.Sprintf("%s: must match pattern %q", path, pattern))
+			}
+		}
+	case float64:
+		if lo, ok := schema["minimum"].(float64); ok && vv < lo {
+			*errs = append(*errs, fmt.Sprintf("%s: must be >= %v", path, lo))
+		}
+		if hi, ok := schema["maximum"].(float64); ok && vv > hi {
+			*errs = append(*errs, fmt.Sprintf("%s: must be <= %v", path, hi))
+		}
+		if lo, ok := schema["exclusiveMinimum"].(float64); ok && vv <= lo {
+			*errs = append(*errs, fmt.Sprintf("%s: must be > %v", path, lo))
+		}
+		if hi, ok := schema["exclusiveMaximum"].(float64); ok && vv >= hi {
+			*errs = append(*errs, fmt.Sprintf("%s: must be < %v", path, hi))
+		}
+	}
+}
+
+func validateObject(root, schema map[string]any, obj map[string]any, path string, errs *[]string) {
+	props, _ := schema["properties"].(map[string]any)
+	if required, ok := schema["required"].([]any); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, present := obj[name]; name != "" && !present {
+				*errs = append(*errs, fmt.Sprintf("%s: missing required property %q", path, name))
+			}
+		}
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if sub, ok := props[k].(map[string]any); ok {
+			validateSchemaAt(root, sub, obj[k], path+"."+k, errs)
+			continue
+		}
+		switch extra := schema["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				*errs = append(*errs, fmt.Sprintf("%s: unexpected property %q", path, k))
+			}
+		case map[string]any:
+			validateSchemaAt(root, extra, obj[k], path+"."+k, errs)
+		}
+	}
+}
+
+func countSchemaMatches(root map[string]any, subs []map[string]any, v any, path string) int {
+	n := 0
+	for _, sub := range subs {
+		var subErrs []string
+		validateSchemaAt(root, sub, v, path, &subErrs)
+		if len(subErrs) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func resolveSchemaRef(root map[string]any, ref string) map[string]any {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil
+	}
+	var cur any = root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil
+		}
+		cur = m[strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")]
+	}
+	out, _ := cur.(map[string]any)
+	return out
+}
+
+func schemaTypes(v any) []string {
+	switch t := v.(type) {
+	case string:
+		return []string{t}
+	case []any:
+		out := make([]string, 0, len(t))
+		for _, it := range t {
+			if s, ok := it.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func schemaList(v any) []map[string]any {
+	arr, _ := v.([]any)
+	out := make([]map[string]any, 0, len(arr))
+	for _, it := range arr {
+		if m, ok := it.(map[string]any); ok {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func jsonTypeMatches(t string, v any) bool {
+	switch t {
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "null":
+		return v == nil
+	}
+	return true
+}
+
+func jsonTypeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func jsonEqual(a, b any) bool { return toJSON(a) == toJSON(b) }
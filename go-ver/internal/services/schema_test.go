@@ -0,0 +1,46 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestValidateSchema(t *testing.T) {
+	var schema map[string]any
+	_ = json.Unmarshal([]byte(`{
+		"type": "object",
+		"required": ["city", "unit"],
+		"additionalProperties": false,
+		"properties": {
+			"city": {"type": "string", "minLength": 1},
+			"unit": {"enum": ["c", "f"]},
+			"days": {"type": "integer", "minimum": 1, "maximum": 7},
+			"tags": {"type": "array", "items": {"$ref": "#/$defs/tag"}}
+		},
+		"$defs": {"tag": {"type": "string"}}
+	}`), &schema)
+
+	cases := []struct {
+		input string
+		errs  int
+	}{
+		{`{"city": "SF", "unit": "c"}`, 0},
+		{`{"city": "SF", "unit": "c", "days": 3, "tags": ["a", "b"]}`, 0},
+		{`{"city": "SF"}`, 1},
+		{`{"city": "", "unit": "k"}`, 2},
+		{`{"city": "SF", "unit": "c", "days": 2.5}`, 1},
+		{`{"city": "SF", "unit": "c", "days": 9}`, 1},
+		{`{"city": "SF", "unit": "c", "tags": [1]}`, 1},
+		{`{"city": "SF", "unit": "c", "extra": true}`, 1},
+		{`[]`, 1},
+	}
+	for _, c := range cases {
+		var v any
+		if err := json.Unmarshal([]byte(c.input), &v); err != nil {
+			t.Fatalf("bad input %s: %v", c.input, err)
+		}
+		if errs := ValidateSchema(schema, v); len(errs) != c.errs {
+			t.Fatalf("input %s: expected %d errors, got %v", c.input, c.errs, errs)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package services
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type ToolExtraction struct {
+	Calls []map[string]any
+	Text  string
+}
+
+type textSpan struct {
+	start int
+	end   int
+}
+
+var (
+	toolTagRe      = regexp.MustCompile(`(?s)<(tool_calls?|function_calls?)>(.*?)</(?:tool_calls?|function_calls?)>`)
+	toolFenceRe    = regexp.MustCompile("(?s)```[A-Za-z0-9_-]*[ \t]*\r?\n?(.*?)```")
+	toolEnvelopeRe = regexp.MustCompile(`^\{\s*["']?tool_calls["']?\s*:`)
+)
+
+// bareCall is a fenced or bare JSON block holding tool calls without the
+// tool_calls envelope. Such blocks are easily example JSON in an answer, so
+// they only count when nothing but other such blocks follows them.
+type bareCall struct {
+	span  textSpan
+	calls []map[string]any
+}
+
+// ExtractToolCalls finds the calls to tools in text: tagged blocks and
+// tool_calls envelopes anywhere, and other call-shaped JSON, fenced or bare,
+// when it ends the text.
+func ExtractToolCalls(text string, tools []map[string]any) ToolExtraction {
+	allowed := toolNameSet(tools)
+	if len(allowed) == 0 || strings.TrimSpace(text) == "" {
+		return ToolExtraction{Text: text}
+	}
+	var calls []map[string]any
+	var consumed, scanned []textSpan
+	var bare []bareCall
+	accept := func(start, end int, body string) bool {
+		found := parseToolPayload(body, allowed)
+		if len(found) == 0 {
+			return false
+		}
+		if toolEnvelopeRe.MatchString(strings.TrimSpace(body)) {
+			calls = append(calls, found...)
+			consumed = append(consumed, textSpan{start, end})
+		} else {
+			bare = append(bare, bareCall{textSpan{start, end}, found})
+		}
+		return true
+	}
+
+	for _, m := range toolTagRe.FindAllStringSubmatchIndex(text, -1) {
+		scanned = append(scanned, textSpan{m[0], m[1]})
+		if found := parseToolPayload(text[m[4]:m[5]], allowed); len(found) > 0 {
+			calls = append(calls, found...)
+			consumed = append(consumed, textSpan{m[0], m[1]})
+		}
+	}
+	for _, m := range toolFenceRe.FindAllStringSubmatchIndex(text, -1) {
+		if spanOverlaps(scanned, m[0], m[1]) {
+			continue
+		}
+		scanned = append(scanned, textSpan{m[0], m[1]})
+		accept(m[0], m[1], text[m[2]:m[3]])
+	}
+	taken := -1
+	for _, b := range jsonBlocks(text, scanned) {
+		if b.start < taken {
+			continue
+		}
+		body := text[b.start:b.end]
+		if !strings.Contains(body, "name") && !strings.Contains(body, "tool_calls") {
+			continue
+		}
+		if accept(b.start, b.end, body) {
+			taken = b.end
+		}
+	}
+	calls = append(calls, trailingCalls(text, bare, &consumed)...)
+	if len(calls) == 0 {
+		return ToolExtraction{Text: text}
+	}
+	return ToolExtraction{Calls: calls, Text: strings.TrimSpace(removeSpans(text, consumed))}
+}
+
+// trailingCalls returns the calls of the bare blocks that end text, in text
+// order, and marks their spans consumed.
+func trailingCalls(text string, bare []bareCall, consumed *[]textSpan) []map[string]any {
+	sort.Slice(bare, func(i, j int) bool { return bare[i].span.start < bare[j].span.start })
+	end, first := len(text), len(bare)
+	for first > 0 && strings.TrimSpace(text[bare[first-1].span.end:end]) == "" {
+		first--
+		end = bare[first].span.start
+	}
+	var out []map[string]any
+	for _, b := range bare[first:] {
+		out = append(out, b.calls...)
+		*consumed = append(*consumed, b.span)
+	}
+	return out
+}
+
+// jsonBlocks finds the balanced {...} and [...] blocks of text in one pass,
+// outside the skipped spans, ordered by start. Quotes only count inside a
+// block, and a single quote only opens a string where a key or value can
+// start, so an apostrophe does not hide the blocks after it.
+func jsonBlocks(text string, skip []textSpan) []textSpan {
+	skip = append([]textSpan(nil), skip...)
+	sort.Slice(skip, func(i, j int) bool { return skip[i].start < skip[j].start })
+	var blocks []textSpan
+	var open []int
+	var quote, prev byte
+	escape := false
+	for i, k := 0, 0; i < len(text); i++ {
+		for k < len(skip) && skip[k].end <= i {
+			k++
+		}
+		if k < len(skip) && i >= skip[k].start {
+			i = skip[k].end - 1
+			open, quote, escape, prev = open[:0], 0, false, 0
+			continue
+		}
+		c := text[i]
+		if quote != 0 {
+			if escape {
+				escape = false
+			} else if c == '\\' {
+				escape = true
+			} else if c == quote {
+				quote, prev = 0, c
+			}
+			continue
+		}
+		switch {
+		case c == '{' || c == '[':
+			open = append(open, i)
+		case c == '}' || c == ']':
+			if n := len(open); n > 0 {
+				blocks = append(blocks, textSpan{open[n-1], i + 1})
+				open = open[:n-1]
+			}
+		case len(open) > 0 && (c == '"' || c == '\'' && strings.IndexByte("{[,:", prev) >= 0):
+			quote = c
+		}
+		if !isJSONSpace(c) {
+			prev = c
+		}
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].start < blocks[j].start })
+	return blocks
+}
+
+func toolNameSet(tools []map[string]any) map[string]bool {
+	allowed := map[string]bool{}
+	for _, t := range tools {
+		if n, ok := t["name"].(string); ok && n != "" {
+			allowed[n] = true
+		}
+	}
+	return allowed
+}
+
+func spanOverlaps(spans []textSpan, start, end int) bool {
+	for _, s := range spans {
+		if start < s.end && end > s.start {
+			return true
+		}
+	}
+	return false
+}
+
+func removeSpans(text string, spans []textSpan) string {
+	if len(spans) == 0 {
+		return text
+	}
+	keep := make([]bool, len(text))
+	for i := range keep {
+		keep[i] = true
+	}
+	for _, s := range spans {
+		for i := s.start; i < s.end && i < len(text); i++ {
+			keep[i] = false
+		}
+	}
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		if keep[i] {
+			b.WriteByte(text[i])
+		}
+	}
+	return b.String()
+}
+
+func parseToolPayload(body string, allowed map[string]bool) []map[string]any {
+	v, ok := parseLenientJSON(strings.TrimSpace(body))
+	if !ok {
+		return nil
+	}
+	var out []map[string]any
+	collectToolCalls(v, allowed, &out)
+	return out
+}
+
+func collectToolCalls(v any, allowed map[string]bool, out *[]map[string]any) {
+	switch vv := v.(type) {
+	case []any:
+		for _, it := range vv {
+			collectToolCalls(it, allowed, out)
+		}
+	case map[string]any:
+		for _, key := range []string{"tool_calls", "tool_call", "function_call", "function_calls"} {
+			if inner, ok := vv[key]; ok {
+				collectToolCalls(inner, allowed, out)
+				return
+			}
+		}
+		if fn, ok := vv["function"].(map[string]any); ok {
+			if _, named := fn["name"]; named {
+				collectToolCalls(fn, allowed, out)
+				return
+			}
+		}
+		name, _ := vv["name"].(string)
+		if !allowed[name] {
+			return
+		}
+		*out = append(*out, map[string]any{"name": name, "input": toolCallInput(vv)})
+	}
+}
+
+func toolCallInput(call map[string]any) map[string]any {
+	for _, key := range []string{"input", "arguments", "parameters", "args"} {
+		switch a := call[key].(type) {
+		case map[string]any:
+			return a
+		case string:
+			if parsed, ok := parseLenientJSON(a); ok {
+				if m, ok := parsed.(map[string]any); ok {
+					return m
+				}
+			}
+			return map[string]any{}
+		}
+	}
+	return map[string]any{}
+}
+
+func parseLenientJSON(s string) (any, bool) {
+	if s == "" {
+		return nil, false
+	}
+	var v any
+	if json.Unmarshal([]byte(s), &v) == nil {
+		return v, true
+	}
+	if json.Unmarshal([]byte(repairJSON(s)), &v) == nil {
+		return v, true
+	}
+	return nil, false
+}
+
+func repairJSON(s string) string {
+	var b strings.Builder
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if quote != 0 {
+			switch {
+			case c == '\\' && i+1 < len(s):
+				if quote == '\'' && s[i+1] == '\'' {
+					b.WriteByte('\'')
+				} else {
+					b.WriteByte(c)
+					b.WriteByte(s[i+1])
+				}
+				i++
+			case c == quote:
+				b.WriteByte('"')
+				quote = 0
+			case c == '"':
+				b.WriteString(`\"`)
+			case c == '\n':
+				b.WriteString(`\n`)
+			case c == '\r':
+				b.WriteString(`\r`)
+			case c == '\t':
+				b.WriteString(`\t`)
+			default:
+				b.WriteByte(c)
+			}
+			continue
+		}
+		switch {
+		case c == '"' || c == '\'':
+			quote = c
+			b.WriteByte('"')
+		case c == ',':
+			j := i + 1
+			for j < len(s) && isJSONSpace(s[j]) {
+				j++
+			}
+			if j < len(s) && (s[j] == '}' || s[j] == ']') {
+				continue
+			}
+			b.WriteByte(c)
+		case isIdentStart(c):
+			j := i
+			for j < len(s) && isIdentPart(s[j]) {
+				j++
+			}
+			word := s[i:j]
+			k := j
+			for k < len(s) && isJSONSpace(s[k]) {
+				k++
+			}
+			switch {
+			case k < len(s) && s[k] == ':':
+				b.WriteString(`"` + word + `"`)
+			case word == "True" || word == "true":
+				b.WriteString("true")
+			case word == "False" || word == "false":
+				b.WriteString("false")
+			case word == "None" || word == "null" || word == "undefined":
+				b.WriteString("null")
+			default:
+				b.WriteString(word)
+			}
+			i = j - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func scanJSONEnd(s string, start int) int {
+	depth := 0
+	var quote byte
+	for i := start; i < len(s); i++ {
+		c := s[i]
+		if quote != 0 {
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '"', '\'':
+			quote = c
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+func isJSONSpace(c byte) bool { return c == ' ' || c == '\n' || c == '\r' || c == '\t' }
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+func isIdentPart(c byte) bool { return isIdentStart(c) || (c >= '0' && c <= '9') }
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+var extractTools = []map[string]any{{"name": "get_weather"}, {"name": "search"}}
+
+var extractCorpus = []struct {
+	name  string
+	input string
+	calls []string
+	text  string
+}{
+	{"plain", `{"tool_calls": [{"name": "get_weather", "input": {"city": "SF"}}]}`, []string{`get_weather{"city":"SF"}`}, ""},
+	{"fenced", "```json\n{\"tool_calls\": [{\"name\": \"get_weather\", \"input\": {\"city\": \"SF\"}}]}\n```", []string{`get_weather{"city":"SF"}`}, ""},
+	{"prose around", "Let me check that.\n{\"tool_calls\": [{\"name\": \"search\", \"input\": {\"q\": \"go\"}}]}\nOne moment.", []string{`search{"q":"go"}`}, "Let me check that.\n\nOne moment."},
+	{"xml tag", "<tool_call>{\"name\": \"search\", \"arguments\": {\"q\": \"x\"}}</tool_call>", []string{`search{"q":"x"}`}, ""},
+	{"openai shape", `{"name": "get_weather", "arguments": "{\"city\": \"Paris\"}"}`, []string{`get_weather{"city":"Paris"}`}, ""},
+	{"function wrapper", `{"type": "function", "function": {"name": "search", "arguments": "{\"q\": \"y\"}"}}`, []string{`search{"q":"y"}`}, ""},
+	{"multiple objects", `{"name": "search", "input": {"q": "a"}} {"name": "get_weather", "input": {"city": "b"}}`, []string{`search{"q":"a"}`, `get_weather{"city":"b"}`}, ""},
+	{"trailing commas", `{"tool_calls": [{"name": "search", "input": {"q": "a",},},]}`, []string{`search{"q":"a"}`}, ""},
+	{"single quotes", `{'tool_calls': [{'name': 'search', 'input': {'q': 'it\'s', 'n': True}}]}`, []string{`search{"n":true,"q":"it's"}`}, ""},
+	{"bare keys", `{tool_calls: [{name: "search", input: {q: "a"}}]}`, []string{`search{"q":"a"}`}, ""},
+	{"unknown tool", `{"tool_calls": [{"name": "rm_rf", "input": {}}]}`, nil, `{"tool_calls": [{"name": "rm_rf", "input": {}}]}`},
+	{"plain json answer", "Here is the data: {\"city\": \"SF\"}", nil, "Here is the data: {\"city\": \"SF\"}"},
+	{"no tools", "Just an answer.", nil, "Just an answer."},
+	{"example in prose", "Call it as {\"name\": \"search\", \"arguments\": {\"q\": \"go\"}} from your code.", nil, "Call it as {\"name\": \"search\", \"arguments\": {\"q\": \"go\"}} from your code."},
+	{"fenced example in prose", "For example:\n```json\n{\"name\": \"search\", \"input\": {\"q\": \"go\"}}\n```\nreturns results.", nil, "For example:\n```json\n{\"name\": \"search\", \"input\": {\"q\": \"go\"}}\n```\nreturns results."},
+	{"example then call", "Like {\"name\": \"search\", \"input\": {\"q\": \"a\"}} so:\n{\"name\": \"search\", \"input\": {\"q\": \"b\"}}", []string{`search{"q":"b"}`}, "Like {\"name\": \"search\", \"input\": {\"q\": \"a\"}} so:"},
+	{"example before envelope", "Like {\"name\": \"search\", \"input\": {\"q\": \"a\"}}:\n{\"tool_calls\": [{\"name\": \"search\", \"input\": {\"q\": \"b\"}}]}", []string{`search{"q":"b"}`}, "Like {\"name\": \"search\", \"input\": {\"q\": \"a\"}}:"},
+	{"truncated", `{"tool_calls": [{"name": "search", "input": {"q"`, nil, `{"tool_calls": [{"name": "search", "input": {"q"`},
+}
+
+func TestExtractToolCallsCorpus(t *testing.T) {
+	for _, c := range extractCorpus {
+		t.Run(c.name, func(t *testing.T) {
+			got := ExtractToolCalls(c.input, extractTools)
+			if len(got.Calls) != len(c.calls) {
+				t.Fatalf("expected %d calls, got %v", len(c.calls), got.Calls)
+			}
+			for i, call := range got.Calls {
+				if s := call["name"].(string) + toJSON(call["input"]); s != c.calls[i] {
+					t.Fatalf("call %d: expected %s, got %s", i, c.calls[i], s)
+				}
+			}
+			if got.Text != c.text {
+				t.Fatalf("expected text %q, got %q", c.text, got.Text)
+			}
+		})
+	}
+}
+
+func TestExtractToolCallsLargeReply(t *testing.T) {
+	prose := strings.Repeat("{it's [a {\"name\" ", 40000)
+	got := ExtractToolCalls(prose+"\n{\"name\": \"search\", \"input\": {\"q\": \"go\"}}", extractTools)
+	if len(got.Calls) != 1 || got.Text != strings.TrimSpace(prose) {
+		t.Fatalf("calls = %v, text length %d", got.Calls, len(got.Text))
+	}
+}
+
+func FuzzExtractToolCalls(f *testing.F) {
+	for _, c := range extractCorpus {
+		f.Add(c.input)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		got := ExtractToolCalls(input, extractTools)
+		if len(got.Calls) == 0 && got.Text != input {
+			t.Fatalf("text changed without calls: %q -> %q", input, got.Text)
+		}
+		for _, call := range got.Calls {
+			if name, _ := call["name"].(string); name != "get_weather" && name != "search" {
+				t.Fatalf("unexpected tool %q", name)
+			}
+			if _, ok := call["input"].(map[string]any); !ok {
+				t.Fatalf("input is not an object: %#v", call["input"])
+			}
+			if _, err := json.Marshal(call["input"]); err != nil {
+				t.Fatalf("input does not marshal: %v", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,638 @@
+package services
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// toolMarker is the kind of tool call block that starts at a marker.
+type toolMarker int
+
+const (
+	// markerEnvelope is a strict {"tool_calls": envelope, streamed as it
+	// arrives.
+	markerEnvelope toolMarker = iota + 1
+	// markerLenient is a tool_calls envelope with single-quoted or bare keys.
+	markerLenient
+	// markerObject is a bare {"name": ...} call object.
+	markerObject
+	// markerTag is a <tool_call> or <function_call> tagged block.
+	markerTag
+)
+
+var (
+	toolOpenTags = []string{"<tool_call>", "<tool_calls>", "<function_call>", "<function_calls>"}
+	toolCloseRe  = regexp.MustCompile(`</(?:tool_calls?|function_calls?)>`)
+)
+
+type ToolStreamEvent struct {
+	Kind        string
+	Text        string
+	Index       int
+	Name        string
+	PartialJSON string
+	Input       any
+}
+
+type streamToolCall struct {
+	name      string
+	nameKnown bool
+	started   bool
+	inputSeen bool
+	isString  bool
+	pending   strings.Builder
+	raw       strings.Builder
+	comma     strings.Builder
+}
+
+// toolBlock buffers a tool call block that is parsed once it is complete
+// rather than streamed.
+type toolBlock struct {
+	kind toolMarker
+	raw  strings.Builder
+	span jsonSpan
+}
+
+// jsonSpan finds the end of a JSON value fed to it in pieces. Single quotes
+// only delimit strings when single is set, i.e. the block's own marker was
+// single-quoted; otherwise an apostrophe in a bare value would swallow the
+// rest of the reply.
+type jsonSpan struct {
+	single bool
+	depth  int
+	quote  byte
+	escape bool
+}
+
+// feed returns the index in s just past the end of the value, or -1.
+func (j *jsonSpan) feed(s string) int {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case j.quote != 0:
+			if j.escape {
+				j.escape = false
+			} else if c == '\\' {
+				j.escape = true
+			} else if c == j.quote {
+				j.quote = 0
+			}
+		case c == '"' || c == '\'' && j.single:
+			j.quote = c
+		case c == '{' || c == '[':
+			j.depth++
+		case c == '}' || c == ']':
+			j.depth--
+			if j.depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+type ToolCallStreamParser struct {
+	allowed  map[string]bool
+	maxCalls int
+
+	buf        string
+	inEnvelope bool
+	envelope   strings.Builder
+	envStart   int
+	emitted    int
+	block      *toolBlock
+	lineStart  bool
+
+	// trail holds bare call objects, and the whitespace between them, until
+	// it is clear they end the reply; any other text after them turns them
+	// back into text.
+	trail      strings.Builder
+	trailCalls []map[string]any
+
+	stack     []byte
+	keys      []string
+	expectKey bool
+	inString  bool
+	escape    bool
+	str       []byte
+
+	capturing    bool
+	captureDelim byte
+	call         *streamToolCall
+	delta        strings.Builder
+	events       []ToolStreamEvent
+}
+
+func NewToolCallStreamParser(tools []map[string]any, maxCalls int) *ToolCallStreamParser {
+	allowed := map[string]bool{}
+	for _, t := range tools {
+		if n, ok := t["name"].(string); ok {
+			allowed[n] = true
+		}
+	}
+	return &ToolCallStreamParser{allowed: allowed, maxCalls: maxCalls, lineStart: true}
+}
+
+func (p *ToolCallStreamParser) ToolCalls() int { return p.emitted }
+
+func (p *ToolCallStreamParser) Feed(s string) []ToolStreamEvent {
+	p.events = nil
+	p.buf += s
+	for p.buf != "" {
+		if p.inEnvelope {
+			rest, done := p.scanEnvelope(p.buf)
+			p.flushDelta()
+			p.buf = rest
+			if !done {
+				break
+			}
+			continue
+		}
+		if p.block != nil {
+			rest, done := p.scanBlock(p.buf)
+			p.buf = rest
+			if !done {
+				break
+			}
+			continue
+		}
+		start, kind, quote, complete := findToolMarker(p.buf, p.lineStart)
+		if start < 0 {
+			p.text(p.buf)
+			p.buf = ""
+			break
+		}
+		p.text(p.buf[:start])
+		p.buf = p.buf[start:]
+		if !complete {
+			break
+		}
+		if kind != markerObject {
+			p.flushTrail()
+		}
+		if kind == markerEnvelope {
+			p.beginEnvelope()
+		} else {
+			p.block = &toolBlock{kind: kind, span: jsonSpan{single: quote == '\''}}
+		}
+	}
+	return p.events
+}
+
+func (p *ToolCallStreamParser) Finish() []ToolStreamEvent {
+	p.events = nil
+	if p.inEnvelope {
+		p.flushDelta()
+		if p.call != nil && p.call.started {
+			p.finishCall()
+		}
+		p.endEnvelope()
+	}
+	if p.block != nil {
+		p.text(p.block.raw.String())
+		p.block = nil
+	}
+	p.text(p.buf)
+	p.buf = ""
+	if p.trail.Len() > 0 {
+		raw, calls := p.trail.String(), p.trailCalls
+		p.trail.Reset()
+		p.trailCalls = nil
+		p.emitCalls(calls, raw)
+	}
+	return p.events
+}
+
+// text emits plain text, first turning any held bare calls back into text
+// unless s is only whitespace.
+func (p *ToolCallStreamParser) text(s string) {
+	line := s
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		line = s[i+1:]
+		p.lineStart = true
+	}
+	if strings.Trim(line, " \t") != "" {
+		p.lineStart = false
+	}
+	if p.trail.Len() > 0 {
+		if strings.TrimSpace(s) == "" {
+			p.trail.WriteString(s)
+			return
+		}
+		p.flushTrail()
+	}
+	p.emitText(s)
+}
+
+func (p *ToolCallStreamParser) flushTrail() {
+	p.emitText(p.trail.String())
+	p.trail.Reset()
+	p.trailCalls = nil
+}
+
+func (p *ToolCallStreamParser) emitText(s string) {
+	if s == "" {
+		return
+	}
+	if n := len(p.events); n > 0 && p.events[n-1].Kind == "text" {
+		p.events[n-1].Text += s
+		return
+	}
+	p.events = append(p.events, ToolStreamEvent{Kind: "text", Text: s})
+}
+
+func (p *ToolCallStreamParser) beginEnvelope() {
+	p.inEnvelope = true
+	p.envStart = p.emitted
+	p.envelope.Reset()
+	p.stack = p.stack[:0]
+	p.keys = p.keys[:0]
+	p.expectKey = false
+	p.inString = false
+	p.escape = false
+	p.capturing = false
+	p.call = nil
+}
+
+func (p *ToolCallStreamParser) endEnvelope() {
+	p.inEnvelope = false
+	p.lineStart = false
+	if p.emitted == p.envStart {
+		p.emitText(p.envelope.String())
+		return
+	}
+	p.events = append(p.events, ToolStreamEvent{Kind: "envelope", Text: p.envelope.String()})
+}
+
+func (p *ToolCallStreamParser) scanBlock(s string) (string, bool) {
+	b := p.block
+	if b.kind == markerTag {
+		from := max(b.raw.Len()-len("</function_calls>"), 0)
+		b.raw.WriteString(s)
+		raw := b.raw.String()
+		loc := toolCloseRe.FindStringIndex(raw[from:])
+		if loc == nil {
+			return "", false
+		}
+		p.block = nil
+		end := from + loc[1]
+		p.endBlock(b.kind, raw[:end], raw[strings.IndexByte(raw, '>')+1:from+loc[0]])
+		return raw[end:], true
+	}
+	end := b.span.feed(s)
+	if end < 0 {
+		b.raw.WriteString(s)
+		return "", false
+	}
+	b.raw.WriteString(s[:end])
+	p.block = nil
+	p.endBlock(b.kind, b.raw.String(), b.raw.String())
+	return s[end:], true
+}
+
+// endBlock parses a complete buffered block. Bare call objects are held in
+// the trail; tagged blocks and envelopes are emitted at once.
+func (p *ToolCallStreamParser) endBlock(kind toolMarker, raw, body string) {
+	calls := parseToolPayload(body, p.allowed)
+	p.lineStart = false
+	switch {
+	case len(calls) == 0:
+		p.text(raw)
+	case kind == markerObject:
+		p.trail.WriteString(raw)
+		p.trailCalls = append(p.trailCalls, calls...)
+	default:
+		p.emitCalls(calls, raw)
+	}
+}
+
+func (p *ToolCallStreamParser) emitCalls(calls []map[string]any, raw string) {
+	start := p.emitted
+	for _, c := range calls {
+		if p.maxCalls > 0 && p.emitted >= p.maxCalls {
+			break
+		}
+		for _, ev := range toolCallEvents([]map[string]any{c}) {
+			ev.Index = p.emitted
+			p.events = append(p.events, ev)
+		}
+		p.emitted++
+	}
+	if p.emitted == start {
+		p.emitText(raw)
+		return
+	}
+	p.events = append(p.events, ToolStreamEvent{Kind: "envelope", Text: raw})
+}
+
+func (p *ToolCallStreamParser) scanEnvelope(s string) (string, bool) {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		p.envelope.WriteByte(c)
+		if p.scanByte(c) {
+			p.endEnvelope()
+			return s[i+1:], true
+		}
+	}
+	return "", false
+}
+
+func (p *ToolCallStreamParser) scanByte(c byte) bool {
+	depth := len(p.stack)
+	if p.inString {
+		p.str = append(p.str, c)
+		p.capture(c)
+		if p.escape {
+			p.escape = false
+		} else if c == '\\' {
+			p.escape = true
+		} else if c == '"' {
+			p.inString = false
+			p.onStringEnd(depth)
+		}
+		return false
+	}
+	if p.capturing && p.captureDelim == 0 && depth == 3 && (c == ',' || c == '}') {
+		p.capturing = false
+	}
+	switch c {
+	case ' ', '\n', '\r', '\t':
+		p.capture(c)
+	case '"':
+		p.onValueStart(depth, c)
+		p.inString = true
+		p.str = append(p.str[:0], c)
+		p.capture(c)
+	case '{', '[':
+		p.onValueStart(depth, c)
+		p.capture(c)
+		p.stack = append(p.stack, c)
+		p.keys = append(p.keys, "")
+		p.expectKey = c == '{'
+		if c == '{' && len(p.stack) == 3 {
+			p.call = &streamToolCall{}
+		}
+	case '}', ']':
+		p.capture(c)
+		if depth == 0 {
+			return true
+		}
+		p.stack = p.stack[:depth-1]
+		p.keys = p.keys[:depth-1]
+		p.expectKey = false
+		if p.capturing && len(p.stack) == 3 && p.captureDelim != 0 {
+			p.capturing = false
+		}
+		if depth == 3 && c == '}' {
+			p.finishCall()
+		}
+		if len(p.stack) == 0 {
+			return true
+		}
+	case ':':
+		p.capture(c)
+		p.expectKey = false
+	case ',':
+		p.capture(c)
+		p.expectKey = depth > 0 && p.stack[depth-1] == '{'
+	default:
+		p.onValueStart(depth, c)
+		p.capture(c)
+	}
+	return false
+}
+
+func (p *ToolCallStreamParser) onValueStart(depth int, c byte) {
+	if p.expectKey || p.capturing || depth != 3 || p.call == nil {
+		return
+	}
+	switch p.keys[depth-1] {
+	case "input", "arguments", "parameters":
+		if p.call.inputSeen {
+			return
+		}
+		p.call.inputSeen = true
+		p.call.isString = c == '"'
+		p.capturing = true
+		p.captureDelim = 0
+		if c == '{' || c == '[' || c == '"' {
+			p.captureDelim = c
+		}
+	}
+}
+
+func (p *ToolCallStreamParser) onStringEnd(depth int) {
+	if depth == 0 {
+		return
+	}
+	var v string
+	_ = json.Unmarshal(p.str, &v)
+	if p.expectKey && p.stack[depth-1] == '{' {
+		p.keys[depth-1] = v
+		p.expectKey = false
+		return
+	}
+	if p.capturing && p.captureDelim == '"' && depth == 3 {
+		p.capturing = false
+	}
+	if depth == 3 && p.call != nil && p.keys[depth-1] == "name" && !p.call.nameKnown {
+		p.call.name = v
+		p.call.nameKnown = true
+		p.startCall()
+	}
+}
+
+func (p *ToolCallStreamParser) capture(c byte) {
+	if !p.capturing || p.call == nil {
+		return
+	}
+	// A comma outside strings is held until the next token shows whether
+	// it is a trailing comma, which is dropped.
+	if !p.call.isString {
+		held := &p.call.comma
+		switch {
+		case c == ',' && !p.inString:
+			held.WriteByte(c)
+			return
+		case held.Len() > 0 && isJSONSpace(c) && !p.inString:
+			held.WriteByte(c)
+			return
+		case held.Len() > 0:
+			s := held.String()
+			held.Reset()
+			if c == '}' || c == ']' {
+				s = s[1:]
+			}
+			p.captureString(s)
+		}
+	}
+	p.captureString(string(c))
+}
+
+func (p *ToolCallStreamParser) captureString(s string) {
+	p.call.raw.WriteString(s)
+	if p.call.started && !p.call.isString {
+		p.delta.WriteString(s)
+		return
+	}
+	p.call.pending.WriteString(s)
+}
+
+func (p *ToolCallStreamParser) startCall() {
+	call := p.call
+	if call == nil || call.started || !call.nameKnown || !p.allowed[call.name] {
+		return
+	}
+	if p.maxCalls > 0 && p.emitted >= p.maxCalls {
+		return
+	}
+	call.started = true
+	p.events = append(p.events, ToolStreamEvent{Kind: "tool_start", Index: p.emitted, Name: call.name})
+	if !call.isString && call.pending.Len() > 0 {
+		p.delta.WriteString(call.pending.String())
+		call.pending.Reset()
+	}
+}
+
+func (p *ToolCallStreamParser) flushDelta() {
+	if p.delta.Len() == 0 || p.call == nil {
+		p.delta.Reset()
+		return
+	}
+	p.events = append(p.events, ToolStreamEvent{Kind: "tool_delta", Index: p.emitted, Name: p.call.name, PartialJSON: p.delta.String()})
+	p.delta.Reset()
+}
+
+func (p *ToolCallStreamParser) finishCall() {
+	call := p.call
+	p.capturing = false
+	if call == nil {
+		return
+	}
+	p.startCall()
+	if !call.started {
+		p.call = nil
+		return
+	}
+	p.flushDelta()
+	raw := strings.TrimSpace(call.raw.String())
+	var input any = map[string]any{}
+	if call.isString {
+		var s string
+		if json.Unmarshal([]byte(raw), &s) == nil && strings.TrimSpace(s) != "" {
+			raw = s
+			p.events = append(p.events, ToolStreamEvent{Kind: "tool_delta", Index: p.emitted, Name: call.name, PartialJSON: s})
+		}
+	}
+	var parsed any
+	if raw != "" && json.Unmarshal([]byte(raw), &parsed) == nil && parsed != nil {
+		input = parsed
+	}
+	p.events = append(p.events, ToolStreamEvent{Kind: "tool_stop", Index: p.emitted, Name: call.name, Input: input})
+	p.emitted++
+	p.call = nil
+}
+
+// findToolMarker returns the start of the first tool call marker in s, or
+// of a trailing prefix that may still become one once more text arrives,
+// with the quote its key uses. lineStart tells whether s starts a line.
+// Call objects with an unquoted or single-quoted name key are only taken at
+// the start of a line, as in prose they are far more likely to be examples.
+func findToolMarker(s string, lineStart bool) (int, toolMarker, byte, bool) {
+	for i := 0; i < len(s); i++ {
+		var kind toolMarker
+		var quote byte
+		var matched, complete bool
+		switch s[i] {
+		case '{':
+			kind, quote, matched, complete = matchToolMarker(s[i:])
+			if matched && kind == markerObject && quote != '"' && !lineStart {
+				matched = false
+			}
+		case '<':
+			kind = markerTag
+			matched, complete = matchToolTag(s[i:])
+		}
+		if matched {
+			return i, kind, quote, complete
+		}
+		if s[i] == '\n' {
+			lineStart = true
+		} else if s[i] != ' ' && s[i] != '\t' {
+			lineStart = false
+		}
+	}
+	return -1, 0, 0, false
+}
+
+// matchToolMarker matches an object opening with a tool_calls or name key,
+// quoted either way or bare, and returns the quote.
+func matchToolMarker(s string) (toolMarker, byte, bool, bool) {
+	i := 1
+	skip := func() {
+		for i < len(s) && isJSONSpace(s[i]) {
+			i++
+		}
+	}
+	skip()
+	if i >= len(s) {
+		return 0, 0, true, false
+	}
+	var quote byte
+	if s[i] == '"' || s[i] == '\'' {
+		quote = s[i]
+		i++
+	}
+	var kind toolMarker
+	for _, key := range []string{"tool_calls", "name"} {
+		n := min(len(s)-i, len(key))
+		if s[i:i+n] != key[:n] {
+			continue
+		}
+		if n < len(key) {
+			return 0, 0, true, false
+		}
+		kind = markerObject
+		if key == "tool_calls" {
+			kind = markerLenient
+			if quote == '"' {
+				kind = markerEnvelope
+			}
+		}
+		i += n
+		break
+	}
+	if kind == 0 {
+		return 0, 0, false, false
+	}
+	if quote != 0 {
+		if i >= len(s) {
+			return 0, 0, true, false
+		}
+		if s[i] != quote {
+			return 0, 0, false, false
+		}
+		i++
+	}
+	skip()
+	if i >= len(s) {
+		return 0, 0, true, false
+	}
+	return kind, quote, s[i] == ':', s[i] == ':'
+}
+
+func matchToolTag(s string) (bool, bool) {
+	partial := false
+	for _, tag := range toolOpenTags {
+		n := min(len(s), len(tag))
+		if s[:n] != tag[:n] {
+			continue
+		}
+		if n == len(tag) {
+			return true, true
+		}
+		partial = true
+	}
+	return partial, false
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type streamResult struct {
+	text      string
+	calls     []string
+	envelopes []string
+}
+
+// runToolStream feeds chunks through a parser and checks the event stream is
+// well formed: every call starts before its deltas, and the deltas of a call
+// with a complete input add up to that input.
+func runToolStream(t *testing.T, maxCalls int, chunks []string) streamResult {
+	t.Helper()
+	p := NewToolCallStreamParser(extractTools, maxCalls)
+	var res streamResult
+	started := map[int]bool{}
+	deltas := map[int]string{}
+	handle := func(events []ToolStreamEvent) {
+		for _, ev := range events {
+			switch ev.Kind {
+			case "text":
+				res.text += ev.Text
+			case "envelope":
+				res.envelopes = append(res.envelopes, ev.Text)
+			case "tool_start":
+				started[ev.Index] = true
+			case "tool_delta":
+				if !started[ev.Index] {
+					t.Fatalf("delta for call %d before its start", ev.Index)
+				}
+				deltas[ev.Index] += ev.PartialJSON
+			case "tool_stop":
+				if !started[ev.Index] {
+					t.Fatalf("stop for call %d before its start", ev.Index)
+				}
+				in := toJSON(ev.Input)
+				if in != "{}" {
+					var got any
+					if err := json.Unmarshal([]byte(deltas[ev.Index]), &got); err != nil || toJSON(got) != in {
+						t.Fatalf("call %d: deltas %q do not add up to %s", ev.Index, deltas[ev.Index], in)
+					}
+				}
+				res.calls = append(res.calls, ev.Name+in)
+			}
+		}
+	}
+	for _, c := range chunks {
+		handle(p.Feed(c))
+	}
+	handle(p.Finish())
+	if p.ToolCalls() != len(res.calls) {
+		t.Fatalf("ToolCalls() = %d, emitted %d", p.ToolCalls(), len(res.calls))
+	}
+	return res
+}
+
+func TestToolCallStreamParser(t *testing.T) {
+	const env = `{"tool_calls": [{"name": "get_weather", "input": {"city": "SF"}}]}`
+	cases := []struct {
+		name      string
+		input     string
+		maxCalls  int
+		text      string
+		calls     []string
+		envelopes []string
+	}{
+		{name: "envelope", input: env, calls: []string{`get_weather{"city":"SF"}`}, envelopes: []string{env}},
+		{name: "preamble", input: "Let me check.\n" + env + "\nDone.", text: "Let me check.\n\nDone.", calls: []string{`get_weather{"city":"SF"}`}, envelopes: []string{env}},
+		{name: "false marker", input: `Use {"tool": 1} or {"tool_calls_x": [2]} here.`, text: `Use {"tool": 1} or {"tool_calls_x": [2]} here.`},
+		{name: "disallowed tool", input: `{"tool_calls": [{"name": "rm_rf", "input": {}}]}`, text: `{"tool_calls": [{"name": "rm_rf", "input": {}}]}`},
+		{
+			name:      "disallowed among allowed",
+			input:     `{"tool_calls": [{"name": "rm_rf", "input": {"p": "/"}}, {"name": "search", "input": {"q": "go"}}]}`,
+			calls:     []string{`search{"q":"go"}`},
+			envelopes: []string{`{"tool_calls": [{"name": "rm_rf", "input": {"p": "/"}}, {"name": "search", "input": {"q": "go"}}]}`},
+		},
+		{
+			name:      "string input",
+			input:     `{"tool_calls": [{"name": "search", "input": "{\"q\": \"go\"}"}]}`,
+			calls:     []string{`search{"q":"go"}`},
+			envelopes: []string{`{"tool_calls": [{"name": "search", "input": "{\"q\": \"go\"}"}]}`},
+		},
+		{
+			name:      "input before name",
+			input:     `{"tool_calls": [{"input": {"q": [1, {"a": 2}]}, "name": "search"}]}`,
+			calls:     []string{`search{"q":[1,{"a":2}]}`},
+			envelopes: []string{`{"tool_calls": [{"input": {"q": [1, {"a": 2}]}, "name": "search"}]}`},
+		},
+		{
+			name:      "escapes and braces in strings",
+			input:     `{"tool_calls": [{"name": "search", "input": {"q": "a \"}]\" {b \\ c"}}]} after`,
+			text:      " after",
+			calls:     []string{`search{"q":"a \"}]\" {b \\ c"}`},
+			envelopes: []string{`{"tool_calls": [{"name": "search", "input": {"q": "a \"}]\" {b \\ c"}}]}`},
+		},
+		{
+			name:      "truncated after name",
+			input:     `ok {"tool_calls": [{"name": "search", "input": {"q": "go"`,
+			text:      "ok ",
+			calls:     []string{`search{}`},
+			envelopes: []string{`{"tool_calls": [{"name": "search", "input": {"q": "go"`},
+		},
+		{name: "truncated before name", input: `ok {"tool_calls": [{"na`, text: `ok {"tool_calls": [{"na`},
+		{name: "truncated marker", input: `ok {"tool_ca`, text: `ok {"tool_ca`},
+		{
+			name:      "max calls",
+			input:     `{"tool_calls": [{"name": "search", "input": {"q": "a"}}, {"name": "search", "input": {"q": "b"}}]}`,
+			maxCalls:  1,
+			calls:     []string{`search{"q":"a"}`},
+			envelopes: []string{`{"tool_calls": [{"name": "search", "input": {"q": "a"}}, {"name": "search", "input": {"q": "b"}}]}`},
+		},
+		{
+			name:      "xml tag",
+			input:     "Sure. <tool_call>{\"name\": \"search\", \"arguments\": {\"q\": \"x\"}}</tool_call> ok",
+			text:      "Sure.  ok",
+			calls:     []string{`search{"q":"x"}`},
+			envelopes: []string{`<tool_call>{"name": "search", "arguments": {"q": "x"}}</tool_call>`},
+		},
+		{name: "unclosed tag", input: `<tool_call>{"name": "search"}`, text: `<tool_call>{"name": "search"}`},
+		{name: "false tag", input: "a <tool> b <b>", text: "a <tool> b <b>"},
+		{
+			name:      "name and arguments",
+			input:     "Searching.\n{\"name\": \"search\", \"arguments\": \"{\\\"q\\\": \\\"go\\\"}\"}\n",
+			text:      "Searching.\n",
+			calls:     []string{`search{"q":"go"}`},
+			envelopes: []string{"{\"name\": \"search\", \"arguments\": \"{\\\"q\\\": \\\"go\\\"}\"}\n"},
+		},
+		{
+			name:      "consecutive objects",
+			input:     `{"name": "search", "input": {"q": "a"}} {"name": "get_weather", "input": {"city": "b"}}`,
+			calls:     []string{`search{"q":"a"}`, `get_weather{"city":"b"}`},
+			envelopes: []string{`{"name": "search", "input": {"q": "a"}} {"name": "get_weather", "input": {"city": "b"}}`},
+		},
+		{
+			name:  "example object in prose",
+			input: `Call it as {"name": "search", "arguments": {"q": "go"}} from code.`,
+			text:  `Call it as {"name": "search", "arguments": {"q": "go"}} from code.`,
+		},
+		{
+			name:      "example before envelope",
+			input:     `Like {"name": "search", "input": {"q": "a"}}: ` + env,
+			text:      `Like {"name": "search", "input": {"q": "a"}}: `,
+			calls:     []string{`get_weather{"city":"SF"}`},
+			envelopes: []string{env},
+		},
+		{name: "bare name mid-line", input: `See {name: 'x', it's: 1} then more.`, text: `See {name: 'x', it's: 1} then more.`},
+		{
+			name:      "bare name at line start",
+			input:     "Calling:\n  {name: \"search\", arguments: {q: \"it's\"}}",
+			text:      "Calling:\n  ",
+			calls:     []string{`search{"q":"it's"}`},
+			envelopes: []string{`{name: "search", arguments: {q: "it's"}}`},
+		},
+		{name: "json answer", input: `{"name": "Ada", "age": 36}`, text: `{"name": "Ada", "age": 36}`},
+		{
+			name:      "single quotes",
+			input:     `{'tool_calls': [{'name': 'search', 'input': {'q': 'it\'s'}}]} done`,
+			text:      " done",
+			calls:     []string{`search{"q":"it's"}`},
+			envelopes: []string{`{'tool_calls': [{'name': 'search', 'input': {'q': 'it\'s'}}]}`},
+		},
+		{
+			name:      "bare keys",
+			input:     `{tool_calls: [{name: "search", input: {q: "a"}}]}`,
+			calls:     []string{`search{"q":"a"}`},
+			envelopes: []string{`{tool_calls: [{name: "search", input: {q: "a"}}]}`},
+		},
+		{
+			name:      "trailing commas",
+			input:     `{"tool_calls": [{"name": "search", "input": {"q": ["a", "b",], "n": 1, },},]}`,
+			calls:     []string{`search{"n":1,"q":["a","b"]}`},
+			envelopes: []string{`{"tool_calls": [{"name": "search", "input": {"q": ["a", "b",], "n": 1, },},]}`},
+		},
+		{
+			name:      "arguments key",
+			input:     `{"tool_calls": [{"name": "search", "arguments": {"q": "a, b"}}]}`,
+			calls:     []string{`search{"q":"a, b"}`},
+			envelopes: []string{`{"tool_calls": [{"name": "search", "arguments": {"q": "a, b"}}]}`},
+		},
+		{
+			name:      "max calls across envelopes",
+			input:     env + " then " + env,
+			maxCalls:  1,
+			text:      " then " + env,
+			calls:     []string{`get_weather{"city":"SF"}`},
+			envelopes: []string{env},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			want := streamResult{text: tc.text, calls: tc.calls, envelopes: tc.envelopes}
+			check := func(how string, chunks []string) {
+				if got := runToolStream(t, tc.maxCalls, chunks); !reflect.DeepEqual(got, want) {
+					t.Fatalf("%s: got %+v, want %+v", how, got, want)
+				}
+			}
+			check("whole", []string{tc.input})
+			for i := 1; i < len(tc.input); i++ {
+				check("split at "+tc.input[:i], []string{tc.input[:i], tc.input[i:]})
+			}
+			check("bytewise", strings.Split(tc.input, ""))
+		})
+	}
+}
+
+func TestToolCallStreamParserKeepsStreamingAfterApostrophe(t *testing.T) {
+	p := NewToolCallStreamParser(extractTools, 0)
+	p.Feed("Example: {name: 'x', it's: 1}")
+	for _, chunk := range []string{" and", " then", " more."} {
+		events := p.Feed(chunk)
+		if len(events) != 1 || events[0].Kind != "text" {
+			t.Fatalf("chunk %q: events %+v", chunk, events)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const ToolTranscriptInstructions = "Earlier tool calls appear in the conversation in the same JSON format, with an \"id\" for each call. The output of each call is given back to you as:\n<tool_result id=\"call_id\" name=\"tool_name\">\n...output...\n</tool_result>\nA tool_result with is_error=\"true\" means the call failed; read the error and decide whether to retry with corrected input."
+
+type ToolChoice struct {
+	Mode     string
+	Name     string
+	Parallel bool
+}
+
+func DefaultToolChoice() ToolChoice { return ToolChoice{Mode: "auto", Parallel: true} }
+
+func NormalizeOpenAITools(v any) []map[string]any {
+	arr, _ := v.([]any)
+	out := make([]map[string]any, 0, len(arr))
+	for _, it := range arr {
+		t, ok := it.(map[string]any)
+		if !ok {
+			continue
+		}
+		fn, _ := t["function"].(map[string]any)
+		if fn == nil {
+			if typ, _ := t["type"].(string); typ != "" && typ != "function" {
+				continue
+			}
+			fn = t
+		}
+		name, _ := fn["name"].(string)
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		tool := map[string]any{"name": name}
+		if desc, ok := fn["description"].(string); ok {
+			tool["description"] = desc
+		}
+		if params, ok := fn["parameters"].(map[string]any); ok {
+			tool["input_schema"] = params
+		}
+		out = append(out, tool)
+	}
+	return out
+}
+
+func ParseOpenAIToolChoice(choice any, parallel any) ToolChoice {
+	tc := DefaultToolChoice()
+	if p, ok := parallel.(bool); ok {
+		tc.Parallel = p
+	}
+	switch v := choice.(type) {
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "none":
+			tc.Mode = "none"
+		case "required":
+			tc.Mode = "required"
+		}
+	case map[string]any:
+		fn, _ := v["function"].(map[string]any)
+		if name, _ := fn["name"].(string); strings.TrimSpace(name) != "" {
+			tc.Mode = "required"
+			tc.Name = name
+		}
+	}
+	return tc
+}
+
+func ParseClaudeToolChoice(v any) ToolChoice {
+	tc := DefaultToolChoice()
+	m, _ := v.(map[string]any)
+	if disable, _ := m["disable_parallel_tool_use"].(bool); disable {
+		tc.Parallel = false
+	}
+	switch typ, _ := m["type"].(string); typ {
+	case "none":
+		tc.Mode = "none"
+	case "any":
+		tc.Mode = "required"
+	case "tool":
+		if name, _ := m["name"].(string); strings.TrimSpace(name) != "" {
+			tc.Mode = "required"
+			tc.Name = name
+		}
+	}
+	return tc
+}
+
+func FilterTools(tools []map[string]any, choice ToolChoice) []map[string]any {
+	if choice.Mode == "none" {
+		return nil
+	}
+	if choice.Name == "" {
+		return tools
+	}
+	out := make([]map[string]any, 0, 1)
+	for _, t := range tools {
+		if n, _ := t["name"].(string); n == choice.Name {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func BuildToolPrompt(tools []map[string]any, choice ToolChoice) string {
+	infos := make([]string, 0, len(tools))
+	for _, t := range tools {
+		name, _ := t["name"].(string)
+		desc, _ := t["description"].(string)
+		if strings.TrimSpace(desc) == "" {
+			desc = "No description available"
+		}
+		info := "Tool: " + name + "\nDescription: " + desc
+		if schema, ok := t["input_schema"].(map[string]any); ok && len(schema) > 0 {
+			info += "\nParameters (JSON Schema): " + toJSON(schema)
+		}
+		infos = append(infos, info)
+	}
+	var sb strings.Builder
+	sb.WriteString("You have access to these tools:\n\n")
+	sb.WriteString(strings.Join(infos, "\n\n"))
+	sb.WriteString("\n\nWhen you need to use tools, output ONLY valid JSON in this format:\n{\"tool_calls\": [{\"name\": \"tool_name\", \"input\": {\"param\": \"value\"}}]}\n\n")
+	if choice.Parallel {
+		sb.WriteString("You can call multiple tools in ONE response by including them in the same tool_calls array.\n")
+	} else {
+		sb.WriteString("Call at most one tool per response.\n")
+	}
+	switch {
+	case choice.Name != "":
+		sb.WriteString("You MUST call the tool \"" + choice.Name + "\" in this response.\n")
+	case choice.Mode == "required":
+		sb.WriteString("You MUST call at least one tool in this response.\n")
+	}
+	sb.WriteString("Do not include any text outside the JSON structure when calling tools.\n\n")
+	sb.WriteString(ToolTranscriptInstructions)
+	return sb.String()
+}
+
+func FormatToolCalls(calls []map[string]any) string {
+	out := make([]map[string]any, 0, len(calls))
+	for _, c := range calls {
+		call := map[string]any{"name": c["name"], "input": c["input"]}
+		if id, _ := c["id"].(string); id != "" {
+			call["id"] = id
+		}
+		if call["input"] == nil {
+			call["input"] = map[string]any{}
+		}
+		out = append(out, call)
+	}
+	return toJSON(map[string]any{"tool_calls": out})
+}
+
+func FormatToolResult(id, name, content string, isError bool) string {
+	attrs := ""
+	if id != "" {
+		attrs += fmt.Sprintf(" id=%q", id)
+	}
+	if name != "" {
+		attrs += fmt.Sprintf(" name=%q", name)
+	}
+	if isError {
+		attrs += " is_error=\"true\""
+	}
+	return "<tool_result" + attrs + ">\n" + content + "\n</tool_result>"
+}
+
+func openAIToolCallsToInternal(v any) []map[string]any {
+	arr, _ := v.([]any)
+	out := make([]map[string]any, 0, len(arr))
+	for _, it := range arr {
+		tc, ok := it.(map[string]any)
+		if !ok {
+			continue
+		}
+		fn, _ := tc["function"].(map[string]any)
+		name, _ := fn["name"].(string)
+		var input any = map[string]any{}
+		switch args := fn["arguments"].(type) {
+		case string:
+			var parsed any
+			if strings.TrimSpace(args) != "" && json.Unmarshal([]byte(args), &parsed) == nil {
+				input = parsed
+			}
+		case map[string]any:
+			input = args
+		}
+		id, _ := tc["id"].(string)
+		out = append(out, map[string]any{"id": id, "name": name, "input": input})
+	}
+	return out
+}
+
+func newToolCallID() string {
+	return "call_" + randomHex16()
+}
+
+func OpenAIToolCalls(detected []map[string]any, choice ToolChoice) []map[string]any {
+	if !choice.Parallel && len(detected) > 1 {
+		detected = detected[:1]
+	}
+	out := make([]map[string]any, 0, len(detected))
+	for _, t := range detected {
+		input := t["input"]
+		if input == nil {
+			input = map[string]any{}
+		}
+		out = append(out, map[string]any{"id": newToolCallID(), "type": "function", "function": map[string]any{"name": t["name"], "arguments": toJSON(input)}})
+	}
+	return out
+}
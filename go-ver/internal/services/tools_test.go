@@ -0,0 +1,229 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeOpenAITools(t *testing.T) {
+	params := map[string]any{"type": "object", "properties": map[string]any{"q": map[string]any{"type": "string"}}}
+	cases := []struct {
+		name string
+		in   any
+		want []map[string]any
+	}{
+		{"not a list", "tools", []map[string]any{}},
+		{
+			"function wrapper",
+			[]any{map[string]any{"type": "function", "function": map[string]any{"name": "search", "description": "Find things", "parameters": params}}},
+			[]map[string]any{{"name": "search", "description": "Find things", "input_schema": params}},
+		},
+		{"flat function", []any{map[string]any{"name": "search"}}, []map[string]any{{"name": "search"}}},
+		{
+			"skipped entries",
+			[]any{"search", map[string]any{"type": "retrieval", "name": "files"}, map[string]any{"function": map[string]any{"name": " "}}, map[string]any{"function": map[string]any{"name": "ok"}}},
+			[]map[string]any{{"name": "ok"}},
+		},
+	}
+	for _, c := range cases {
+		if got := NormalizeOpenAITools(c.in); !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestParseOpenAIToolChoice(t *testing.T) {
+	cases := []struct {
+		choice   any
+		parallel any
+		want     ToolChoice
+	}{
+		{nil, nil, ToolChoice{Mode: "auto", Parallel: true}},
+		{"auto", nil, ToolChoice{Mode: "auto", Parallel: true}},
+		{"none", nil, ToolChoice{Mode: "none", Parallel: true}},
+		{" Required ", nil, ToolChoice{Mode: "required", Parallel: true}},
+		{map[string]any{"type": "function", "function": map[string]any{"name": "search"}}, nil, ToolChoice{Mode: "required", Name: "search", Parallel: true}},
+		{map[string]any{"type": "function", "function": map[string]any{}}, nil, ToolChoice{Mode: "auto", Parallel: true}},
+		{"auto", false, ToolChoice{Mode: "auto"}},
+		{"required", "false", ToolChoice{Mode: "required", Parallel: true}},
+	}
+	for _, c := range cases {
+		if got := ParseOpenAIToolChoice(c.choice, c.parallel); got != c.want {
+			t.Fatalf("choice %v, parallel %v: got %+v, want %+v", c.choice, c.parallel, got, c.want)
+		}
+	}
+}
+
+func TestMessagesPrepareToolTurns(t *testing.T) {
+	prompt := MessagesPrepare([]map[string]any{
+		{"role": "user", "content": "Weather in SF?"},
+		{"role": "assistant", "content": "Checking.", "tool_calls": []any{map[string]any{
+			"id": "call_1", "type": "function", "function": map[string]any{"name": "get_weather", "arguments": `{"city": "SF"}`},
+		}}},
+		{"role": "tool", "tool_call_id": "call_1", "content": "18C"},
+	})
+	want := "Weather in SF?" +
+		"<｜Assistant｜>Checking.\n\n" + `{"tool_calls":[{"id":"call_1","input":{"city":"SF"},"name":"get_weather"}]}` + "<｜end▁of▁sentence｜>" +
+		"<｜User｜>" + "<tool_result id=\"call_1\" name=\"get_weather\">\n18C\n</tool_result>"
+	if prompt != want {
+		t.Fatalf("prompt =\n%s\nwant\n%s", prompt, want)
+	}
+}
+
+func TestOpenAINonStreamToolCalls(t *testing.T) {
+	opts := OpenAIOptions{Tools: extractTools, ToolChoice: DefaultToolChoice()}
+	ds := newReplyUpstream(t, `{"tool_calls": [{"name": "get_weather", "input": {"city": "SF"}}, {"name": "search", "input": {"q": "go"}}]}`)
+	status, body := OpenAINonStream(context.Background(), ds, map[string]string{}, map[string]any{}, "m", "p", "id", 1, false, false, opts)
+	if status != 200 {
+		t.Fatalf("status = %d: %v", status, body)
+	}
+	var resp struct {
+		Choices []struct {
+			Message struct {
+				ToolCalls []struct {
+					ID       string `json:"id"`
+					Type     string `json:"type"`
+					Function struct {
+						Name      string `json:"name"`
+						Arguments string `json:"arguments"`
+					} `json:"function"`
+				} `json:"tool_calls"`
+			} `json:"message"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal([]byte(toJSON(body)), &resp); err != nil {
+		t.Fatal(err)
+	}
+	choice := resp.Choices[0]
+	if choice.FinishReason != "tool_calls" {
+		t.Fatalf("finish_reason = %q", choice.FinishReason)
+	}
+	var got []string
+	for _, tc := range choice.Message.ToolCalls {
+		if !strings.HasPrefix(tc.ID, "call_") || tc.Type != "function" {
+			t.Fatalf("tool call = %+v", tc)
+		}
+		got = append(got, tc.Function.Name+tc.Function.Arguments)
+	}
+	if want := []string{`get_weather{"city":"SF"}`, `search{"q":"go"}`}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("tool calls = %v, want %v", got, want)
+	}
+}
+
+func TestOpenAIStreamToolCalls(t *testing.T) {
+	cases := []struct {
+		name     string
+		parallel bool
+		want     []string
+	}{
+		{"parallel", true, []string{`get_weather{"city":"SF"}`, `search{"q":"go"}`}},
+		{"parallel_tool_calls false", false, []string{`get_weather{"city":"SF"}`}},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		opts := OpenAIOptions{Tools: extractTools, ToolChoice: ToolChoice{Mode: "auto", Parallel: c.parallel}}
+		ds := newReplyUpstream(t, `On it. {"tool_calls": [{"name": "get_weather", "input": {"city": "SF"}}, {"name": "search", "input": {"q": "go"}}]}`)
+		OpenAIStream(context.Background(), rec, ds, map[string]string{}, map[string]any{}, "m", "p", "id", 1, false, false, opts)
+
+		var names, args []string
+		content, finish := "", ""
+		for _, ev := range sseEvents(t, rec.Body.String()) {
+			choices, _ := ev["choices"].([]any)
+			if len(choices) == 0 {
+				continue
+			}
+			choice := choices[0].(map[string]any)
+			if f, _ := choice["finish_reason"].(string); f != "" {
+				finish = f
+			}
+			delta, _ := choice["delta"].(map[string]any)
+			if s, _ := delta["content"].(string); s != "" {
+				content += s
+			}
+			calls, _ := delta["tool_calls"].([]any)
+			for _, it := range calls {
+				call := it.(map[string]any)
+				i := int(call["index"].(float64))
+				fn := call["function"].(map[string]any)
+				if name, ok := fn["name"].(string); ok {
+					if i != len(names) || !strings.HasPrefix(call["id"].(string), "call_") {
+						t.Fatalf("%s: call start out of order: %v", c.name, call)
+					}
+					names = append(names, name)
+					args = append(args, "")
+				}
+				args[i] += fn["arguments"].(string)
+			}
+		}
+		var got []string
+		for i := range names {
+			var input any
+			if err := json.Unmarshal([]byte(args[i]), &input); err != nil {
+				t.Fatalf("%s: arguments %q: %v", c.name, args[i], err)
+			}
+			got = append(got, names[i]+toJSON(input))
+		}
+		if !reflect.DeepEqual(got, c.want) || finish != "tool_calls" || strings.TrimSpace(content) != "On it." {
+			t.Fatalf("%s: calls %v, finish %q, content %q", c.name, got, finish, content)
+		}
+	}
+}
+
+func TestToolCallsFailingSchemaWithoutRepair(t *testing.T) {
+	tools := []map[string]any{{"name": "get_weather", "input_schema": map[string]any{
+		"type": "object", "required": []any{"city"}, "properties": map[string]any{"city": map[string]any{"type": "string"}},
+	}}}
+	reply := `{"tool_calls": [{"name": "get_weather", "input": {"city": 5}}]}`
+	opts := OpenAIOptions{Tools: tools, ToolChoice: DefaultToolChoice()}
+	claudeOpts := ClaudeOptions{ToolChoice: DefaultToolChoice()}
+
+	_, body := OpenAINonStream(context.Background(), newReplyUpstream(t, reply), map[string]string{}, map[string]any{}, "m", "p", "id", 1, false, false, opts)
+	msg := body["choices"].([]map[string]any)[0]["message"].(map[string]any)
+	if msg["tool_calls"] != nil || msg["content"] != reply {
+		t.Fatalf("openai non-stream message = %v", msg)
+	}
+	_, body = ClaudeNonStream(context.Background(), newReplyUpstream(t, reply), map[string]string{}, map[string]any{}, "m", nil, tools, claudeOpts)
+	if got := toJSON(body["content"]); strings.Contains(got, "tool_use") || !strings.Contains(got, `"text"`) {
+		t.Fatalf("claude non-stream content = %s", got)
+	}
+
+	rec := httptest.NewRecorder()
+	OpenAIStream(context.Background(), rec, newReplyUpstream(t, reply), map[string]string{}, map[string]any{}, "m", "p", "id", 1, false, false, opts)
+	content := ""
+	for _, ev := range sseEvents(t, rec.Body.String()) {
+		choices, _ := ev["choices"].([]any)
+		if len(choices) == 0 {
+			continue
+		}
+		delta, _ := choices[0].(map[string]any)["delta"].(map[string]any)
+		if delta["tool_calls"] != nil {
+			t.Fatalf("openai stream emitted tool calls: %v", delta)
+		}
+		s, _ := delta["content"].(string)
+		content += s
+	}
+	if content != reply {
+		t.Fatalf("openai stream content = %q", content)
+	}
+
+	rec = httptest.NewRecorder()
+	ClaudeStream(context.Background(), rec, newReplyUpstream(t, reply), map[string]string{}, map[string]any{}, "m", nil, tools, claudeOpts)
+	content = ""
+	for _, ev := range sseEvents(t, rec.Body.String()) {
+		if block, ok := ev["content_block"].(map[string]any); ok && block["type"] == "tool_use" {
+			t.Fatalf("claude stream emitted tool_use: %v", ev)
+		}
+		if delta, ok := ev["delta"].(map[string]any); ok {
+			s, _ := delta["text"].(string)
+			content += s
+		}
+	}
+	if content != reply {
+		t.Fatalf("claude stream content = %q", content)
+	}
+}
@@ -0,0 +1,232 @@
+package sessions
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"deepseek2api-go/internal/config"
+)
+
+const (
+	ModeOff       = "off"
+	ModeImmediate = "immediate"
+	ModeDelay     = "delay"
+	ModeKeepLast  = "keep_last"
+)
+
+type Deleter interface {
+	DeleteSession(ctx context.Context, headers map[string]string, sessionID string) error
+}
+
+type session struct {
+	id       string
+	account  string
+	inUse    int
+	lastUsed time.Time
+	attempts int
+	nextTry  time.Time
+}
+
+type accountStats struct {
+	Tracked int    `json:"tracked"`
+	Deleted int    `json:"deleted"`
+	Failed  int    `json:"failed"`
+	Pending int    `json:"pending"`
+	LastErr string `json:"last_error,omitempty"`
+}
+
+// Janitor deletes upstream chat sessions created for pooled accounts according
+// to the configured cleanup policy. Sessions are never deleted while a request
+// is still using them.
+type Janitor struct {
+	mu       sync.Mutex
+	ds       Deleter
+	headers  func(token string) map[string]string
+	policy   config.SessionCleanupConfig
+	sessions map[string]*session
+	tokens   map[string]string
+	stats    map[string]*accountStats
+	wake     chan struct{}
+	onDelete func(sessionID string)
+}
+
+func NewJanitor(ds Deleter, policy config.SessionCleanupConfig, headers func(token string) map[string]string) *Janitor {
+	return &Janitor{ds: ds, headers: headers, policy: policy, sessions: map[string]*session{}, tokens: map[string]string{}, stats: map[string]*accountStats{}, wake: make(chan struct{}, 1)}
+}
+
+func (j *Janitor) OnDelete(fn func(sessionID string)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.onDelete = fn
+}
+
+func (j *Janitor) Enabled() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.policy.Mode != ModeOff && j.policy.Mode != ""
+}
+
+// Acquire marks a session as in use by a request on account. Sessions of
+// callers that bring their own token (empty account) are left alone.
+func (j *Janitor) Acquire(account, token, sessionID string) {
+	if account == "" || sessionID == "" || !j.Enabled() {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	s, ok := j.sessions[sessionID]
+	if !ok {
+		s = &session{id: sessionID, account: account}
+		j.sessions[sessionID] = s
+		j.statsLocked(account).Tracked++
+	}
+	s.inUse++
+	s.lastUsed = time.Now()
+	if token != "" {
+		j.tokens[account] = token
+	}
+}
+
+func (j *Janitor) Release(sessionID string) {
+	j.mu.Lock()
+	s, ok := j.sessions[sessionID]
+	if ok && s.inUse > 0 {
+		s.inUse--
+		s.lastUsed = time.Now()
+	}
+	j.mu.Unlock()
+	if ok {
+		select {
+		case j.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (j *Janitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-j.wake:
+		}
+		j.Sweep(ctx)
+	}
+}
+
+// Sweep deletes every session that is due under the policy and schedules a
+// retry with backoff for the ones that fail.
+func (j *Janitor) Sweep(ctx context.Context) {
+	for _, id := range j.due(time.Now()) {
+		if ctx.Err() != nil {
+			return
+		}
+		headers, ok := j.claim(id)
+		if !ok {
+			continue
+		}
+		j.finish(id, j.ds.DeleteSession(ctx, headers, id))
+	}
+}
+
+func (j *Janitor) due(now time.Time) []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	byAccount := map[string][]*session{}
+	for _, s := range j.sessions {
+		byAccount[s.account] = append(byAccount[s.account], s)
+	}
+	var out []string
+	for _, list := range byAccount {
+		sort.Slice(list, func(a, b int) bool { return list[a].lastUsed.After(list[b].lastUsed) })
+		for i, s := range list {
+			if s.inUse > 0 || now.Before(s.nextTry) {
+				continue
+			}
+			switch j.policy.Mode {
+			case ModeImmediate:
+			case ModeDelay:
+				if now.Sub(s.lastUsed) < time.Duration(j.policy.DelayMinutes)*time.Minute {
+					continue
+				}
+			case ModeKeepLast:
+				if i < j.policy.KeepLast {
+					continue
+				}
+			default:
+				continue
+			}
+			out = append(out, s.id)
+		}
+	}
+	return out
+}
+
+// claim re-checks that the session is idle and tells the owner of any cached
+// reference to forget it before the delete call goes out.
+func (j *Janitor) claim(id string) (map[string]string, bool) {
+	j.mu.Lock()
+	s, ok := j.sessions[id]
+	if !ok || s.inUse > 0 {
+		j.mu.Unlock()
+		return nil, false
+	}
+	headers := j.headers(j.tokens[s.account])
+	onDelete := j.onDelete
+	j.mu.Unlock()
+	if onDelete != nil {
+		onDelete(id)
+	}
+	return headers, true
+}
+
+func (j *Janitor) finish(id string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	s, ok := j.sessions[id]
+	if !ok {
+		return
+	}
+	st := j.statsLocked(s.account)
+	if err == nil {
+		delete(j.sessions, id)
+		st.Deleted++
+		return
+	}
+	st.LastErr = err.Error()
+	s.attempts++
+	s.nextTry = time.Now().Add(time.Duration(1<<s.attempts) * 15 * time.Second)
+	if s.attempts >= j.policy.Retries {
+		delete(j.sessions, id)
+		st.Failed++
+	}
+}
+
+func (j *Janitor) statsLocked(account string) *accountStats {
+	st, ok := j.stats[account]
+	if !ok {
+		st = &accountStats{}
+		j.stats[account] = st
+	}
+	return st
+}
+
+func (j *Janitor) Status() map[string]any {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	accounts := make(map[string]accountStats, len(j.stats))
+	for id, st := range j.stats {
+		accounts[id] = *st
+	}
+	for _, s := range j.sessions {
+		a := accounts[s.account]
+		a.Pending++
+		accounts[s.account] = a
+	}
+	return map[string]any{"mode": j.policy.Mode, "accounts": accounts}
+}
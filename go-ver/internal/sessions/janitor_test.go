@@ -0,0 +1,87 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"deepseek2api-go/internal/config"
+)
+
+type fakeDeleter struct {
+	mu      sync.Mutex
+	deleted []string
+	fail    bool
+}
+
+func (f *fakeDeleter) DeleteSession(ctx context.Context, headers map[string]string, sessionID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fail {
+		return errors.New("boom")
+	}
+	f.deleted = append(f.deleted, headers["authorization"]+":"+sessionID)
+	return nil
+}
+
+func newTestJanitor(ds Deleter, policy config.SessionCleanupConfig) *Janitor {
+	return NewJanitor(ds, policy, func(token string) map[string]string { return map[string]string{"authorization": token} })
+}
+
+func TestJanitorPolicies(t *testing.T) {
+	cases := []struct {
+		name   string
+		policy config.SessionCleanupConfig
+		age    time.Duration
+		want   []string
+	}{
+		{name: "off", policy: config.SessionCleanupConfig{Mode: ModeOff}},
+		{name: "immediate", policy: config.SessionCleanupConfig{Mode: ModeImmediate, Retries: 1}, want: []string{"tok:s1", "tok:s2", "tok:s3"}},
+		{name: "delay not due", policy: config.SessionCleanupConfig{Mode: ModeDelay, DelayMinutes: 10, Retries: 1}, age: time.Minute},
+		{name: "delay due", policy: config.SessionCleanupConfig{Mode: ModeDelay, DelayMinutes: 10, Retries: 1}, age: time.Hour, want: []string{"tok:s1", "tok:s2", "tok:s3"}},
+		{name: "keep last", policy: config.SessionCleanupConfig{Mode: ModeKeepLast, KeepLast: 2, Retries: 1}, want: []string{"tok:s1"}},
+	}
+	for _, tc := range cases {
+		ds := &fakeDeleter{}
+		j := newTestJanitor(ds, tc.policy)
+		for i, id := range []string{"s1", "s2", "s3"} {
+			j.Acquire("a@x", "tok", id)
+			j.Release(id)
+			if s, ok := j.sessions[id]; ok {
+				s.lastUsed = time.Now().Add(-tc.age + time.Duration(i)*time.Second)
+			}
+		}
+		j.Acquire("a@x", "tok", "busy")
+		j.Sweep(context.Background())
+		sort.Strings(ds.deleted)
+		if strings.Join(ds.deleted, ",") != strings.Join(tc.want, ",") {
+			t.Fatalf("%s: deleted %v, want %v", tc.name, ds.deleted, tc.want)
+		}
+	}
+}
+
+func TestJanitorRetriesThenGivesUp(t *testing.T) {
+	ds := &fakeDeleter{fail: true}
+	j := newTestJanitor(ds, config.SessionCleanupConfig{Mode: ModeImmediate, Retries: 2})
+	var dropped []string
+	j.OnDelete(func(id string) { dropped = append(dropped, id) })
+	j.Acquire("a@x", "tok", "s1")
+	j.Release("s1")
+	for i := 0; i < 2; i++ {
+		j.Sweep(context.Background())
+		if s, ok := j.sessions["s1"]; ok {
+			s.nextTry = time.Time{}
+		}
+	}
+	status := j.Status()["accounts"].(map[string]accountStats)["a@x"]
+	if status.Failed != 1 || status.Pending != 0 || status.LastErr != "boom" {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if len(dropped) != 2 {
+		t.Fatalf("expected cached references to be dropped before each attempt, got %v", dropped)
+	}
+}
@@ -0,0 +1,196 @@
+package sessions
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type Creator interface {
+	CreateSession(ctx context.Context, headers map[string]string, maxAttempts int) (string, error)
+}
+
+type warmSession struct {
+	id      string
+	created time.Time
+}
+
+type warmPool struct {
+	ready    []warmSession
+	token    string
+	lastTake time.Time
+	filling  bool
+}
+
+// Warmer keeps a few pre-created chat sessions per pooled account so the
+// request path can skip CreateSession. Pools are refilled in the background
+// after every take and sessions older than ttl are handed to onExpire.
+type Warmer struct {
+	mu       sync.Mutex
+	ds       Creator
+	headers  func(token string) map[string]string
+	size     int
+	ttl      time.Duration
+	pools    map[string]*warmPool
+	hits     int64
+	misses   int64
+	onExpire func(account, token, sessionID string)
+}
+
+func NewWarmer(ds Creator, size int, ttl time.Duration, headers func(token string) map[string]string) *Warmer {
+	return &Warmer{ds: ds, headers: headers, size: size, ttl: ttl, pools: map[string]*warmPool{}}
+}
+
+func (w *Warmer) OnExpire(fn func(account, token, sessionID string)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onExpire = fn
+}
+
+func (w *Warmer) Enabled() bool { return w.size > 0 }
+
+func (w *Warmer) Take(account, token string) (string, bool) {
+	if !w.Enabled() || account == "" || token == "" {
+		return "", false
+	}
+	now := time.Now()
+	w.mu.Lock()
+	p, ok := w.pools[account]
+	if !ok {
+		p = &warmPool{}
+		w.pools[account] = p
+	}
+	p.token = token
+	p.lastTake = now
+	expired := p.expireLocked(now, w.ttl)
+	id := ""
+	if len(p.ready) > 0 {
+		id = p.ready[0].id
+		p.ready = p.ready[1:]
+		w.hits++
+	} else {
+		w.misses++
+	}
+	refill := w.startFillLocked(p)
+	onExpire := w.onExpire
+	w.mu.Unlock()
+
+	w.expire(onExpire, account, token, expired)
+	if refill {
+		go w.fill(account)
+	}
+	return id, id != ""
+}
+
+func (p *warmPool) expireLocked(now time.Time, ttl time.Duration) []warmSession {
+	var expired []warmSession
+	kept := p.ready[:0]
+	for _, s := range p.ready {
+		if now.Sub(s.created) >= ttl {
+			expired = append(expired, s)
+			continue
+		}
+		kept = append(kept, s)
+	}
+	p.ready = kept
+	return expired
+}
+
+func (w *Warmer) startFillLocked(p *warmPool) bool {
+	if p.filling || len(p.ready) >= w.size {
+		return false
+	}
+	p.filling = true
+	return true
+}
+
+func (w *Warmer) expire(onExpire func(account, token, sessionID string), account, token string, expired []warmSession) {
+	if onExpire == nil {
+		return
+	}
+	for _, s := range expired {
+		onExpire(account, token, s.id)
+	}
+}
+
+func (w *Warmer) fill(account string) {
+	for {
+		w.mu.Lock()
+		p := w.pools[account]
+		if len(p.ready) >= w.size {
+			p.filling = false
+			w.mu.Unlock()
+			return
+		}
+		token := p.token
+		w.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		id, err := w.ds.CreateSession(ctx, w.headers(token), 1)
+		cancel()
+
+		w.mu.Lock()
+		if err != nil || id == "" {
+			p.filling = false
+			w.mu.Unlock()
+			return
+		}
+		p.ready = append(p.ready, warmSession{id: id, created: time.Now()})
+		w.mu.Unlock()
+	}
+}
+
+// Run periodically expires stale sessions and tops up the pools of accounts
+// that were used within the last ttl.
+func (w *Warmer) Run(ctx context.Context) {
+	interval := w.ttl / 2
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.sweep(time.Now())
+		}
+	}
+}
+
+func (w *Warmer) sweep(now time.Time) {
+	type expiry struct {
+		account, token string
+		sessions       []warmSession
+	}
+	var expired []expiry
+	var refill []string
+	w.mu.Lock()
+	for account, p := range w.pools {
+		if gone := p.expireLocked(now, w.ttl); len(gone) > 0 {
+			expired = append(expired, expiry{account, p.token, gone})
+		}
+		if now.Sub(p.lastTake) < w.ttl && w.startFillLocked(p) {
+			refill = append(refill, account)
+		}
+	}
+	onExpire := w.onExpire
+	w.mu.Unlock()
+	for _, e := range expired {
+		w.expire(onExpire, e.account, e.token, e.sessions)
+	}
+	for _, account := range refill {
+		go w.fill(account)
+	}
+}
+
+func (w *Warmer) Status() map[string]any {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ready := make(map[string]int, len(w.pools))
+	for account, p := range w.pools {
+		ready[account] = len(p.ready)
+	}
+	return map[string]any{"size": w.size, "ttl_seconds": int(w.ttl / time.Second), "hits": w.hits, "misses": w.misses, "ready": ready}
+}
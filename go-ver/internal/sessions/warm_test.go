@@ -0,0 +1,75 @@
+package sessions
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeCreator struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (f *fakeCreator) CreateSession(ctx context.Context, headers map[string]string, maxAttempts int) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.n++
+	return fmt.Sprintf("%s-%d", headers["authorization"], f.n), nil
+}
+
+func waitReady(t *testing.T, w *Warmer, account string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		w.mu.Lock()
+		p := w.pools[account]
+		ready, filling := len(p.ready), p.filling
+		w.mu.Unlock()
+		if ready == n && !filling {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("pool for %s never reached %d sessions", account, n)
+}
+
+func TestWarmerTakeAndRefill(t *testing.T) {
+	w := NewWarmer(&fakeCreator{}, 2, time.Hour, func(token string) map[string]string { return map[string]string{"authorization": token} })
+	if _, ok := w.Take("a@x", "tok"); ok {
+		t.Fatal("expected a miss on an empty pool")
+	}
+	waitReady(t, w, "a@x", 2)
+	id, ok := w.Take("a@x", "tok")
+	if !ok || id != "tok-1" {
+		t.Fatalf("expected warm session tok-1, got %q %v", id, ok)
+	}
+	waitReady(t, w, "a@x", 2)
+	status := w.Status()
+	if status["hits"] != int64(1) || status["misses"] != int64(1) {
+		t.Fatalf("unexpected status %v", status)
+	}
+	if _, ok := w.Take("", "tok"); ok {
+		t.Fatal("sessions must not be handed to callers without a pooled account")
+	}
+}
+
+func TestWarmerExpiresStaleSessions(t *testing.T) {
+	w := NewWarmer(&fakeCreator{}, 1, time.Hour, func(token string) map[string]string { return map[string]string{"authorization": token} })
+	var expired []string
+	w.OnExpire(func(account, token, sessionID string) { expired = append(expired, account+"/"+sessionID) })
+	w.Take("a@x", "tok")
+	waitReady(t, w, "a@x", 1)
+	w.mu.Lock()
+	w.pools["a@x"].ready[0].created = time.Now().Add(-2 * time.Hour)
+	w.mu.Unlock()
+	if _, ok := w.Take("a@x", "tok"); ok {
+		t.Fatal("expected stale session to be skipped")
+	}
+	if len(expired) != 1 || expired[0] != "a@x/tok-1" {
+		t.Fatalf("unexpected expired sessions %v", expired)
+	}
+	waitReady(t, w, "a@x", 1)
+}
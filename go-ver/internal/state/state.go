@@ -1,15 +1,22 @@
 package state
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"slices"
 	"sync"
 	"time"
 
 	"deepseek2api-go/internal/accounts"
 	"deepseek2api-go/internal/clients"
 	"deepseek2api-go/internal/config"
+	"deepseek2api-go/internal/conversation"
 	"deepseek2api-go/internal/logging"
 	"deepseek2api-go/internal/pow"
+	"deepseek2api-go/internal/responses"
+	"deepseek2api-go/internal/sessions"
 )
 
 type SyncStatus struct {
@@ -22,35 +29,69 @@ type SyncStatus struct {
 }
 
 type AppState struct {
-	mu sync.RWMutex
+	mu       sync.RWMutex
+	updateMu sync.Mutex
 
 	cfg config.Config
 
-	Logger    *logging.Logger
-	HTTP      *http.Client
-	Pool      *accounts.Pool
-	PowSolver pow.Solver
-	PowCache  *pow.Cache
-	DeepSeek  *clients.DeepSeekClient
+	Logger        *logging.Logger
+	HTTP          *http.Client
+	Pool          *accounts.Pool
+	PowSolver     pow.Solver
+	PowCache      *pow.Cache
+	DeepSeek      *clients.DeepSeekClient
+	Responses     *responses.Store
+	Conversations *conversation.Cache
+	Sessions      *sessions.Janitor
+	WarmSessions  *sessions.Warmer
+	PowPrefetch   *pow.Prefetcher
+	TokenCheck    *accounts.TokenChecker
 
 	Sync any
 
 	syncStatus SyncStatus
+	tokenSaves chan struct{}
 }
 
 func NewAppState(cfg config.Config, logger *logging.Logger, httpClient *http.Client, pool *accounts.Pool, solver pow.Solver, cache *pow.Cache, ds *clients.DeepSeekClient) *AppState {
-	return &AppState{
-		cfg:       cfg,
-		Logger:    logger,
-		HTTP:      httpClient,
-		Pool:      pool,
-		PowSolver: solver,
-		PowCache:  cache,
-		DeepSeek:  ds,
+	st := &AppState{
+		cfg:           cfg,
+		Logger:        logger,
+		HTTP:          httpClient,
+		Pool:          pool,
+		PowSolver:     solver,
+		PowCache:      cache,
+		DeepSeek:      ds,
+		Responses:     responses.NewStore(cfg.Responses.MaxEntries, time.Duration(cfg.Responses.TTLHours)*time.Hour),
+		Conversations: conversation.NewCache(2000, 6*time.Hour),
 		syncStatus: SyncStatus{
 			Enabled: cfg.CloudSync.Enabled,
 		},
+		tokenSaves: make(chan struct{}, 1),
+		TokenCheck: accounts.NewTokenChecker(pool, time.Duration(cfg.TokenCheck.IntervalSeconds)*time.Second, cfg.TokenCheck.Workers, time.Duration(cfg.TokenCheck.RefreshAheadMinutes)*time.Minute),
 	}
+	pool.OnTokenRefresh(func() {
+		select {
+		case st.tokenSaves <- struct{}{}:
+		default:
+		}
+	})
+	headers := func(token string) map[string]string {
+		h := cfg.BaseHeaders()
+		h["authorization"] = "Bearer " + token
+		return h
+	}
+	st.Sessions = sessions.NewJanitor(ds, cfg.SessionCleanup, headers)
+	st.Sessions.OnDelete(st.Conversations.DropSession)
+	st.WarmSessions = sessions.NewWarmer(ds, cfg.SessionPool.Size, time.Duration(cfg.SessionPool.TTLSeconds)*time.Second, headers)
+	st.WarmSessions.OnExpire(func(account, token, sessionID string) {
+		st.Sessions.Acquire(account, token, sessionID)
+		st.Sessions.Release(sessionID)
+	})
+	st.PowPrefetch = pow.NewPrefetcher(func(ctx context.Context, token string) (string, int64, error) {
+		return ds.PoWAnswer(ctx, headers(token), solver, cache)
+	}, cfg.PowPrefetch.Depth, cfg.PowPrefetch.Workers)
+	return st
 }
 
 func (s *AppState) GetConfig() config.Config {
@@ -61,6 +102,84 @@ func (s *AppState) GetConfig() config.Config {
 	return cfg
 }
 
+// ErrNotPersisted is returned by UpdateConfig when the change was applied but
+// there is no config file to write it to.
+var ErrNotPersisted = errors.New("no config file: the change is lost on restart")
+
+// UpdateConfig applies change to a copy of the runtime config and makes the
+// result current: the pool is reloaded if its settings changed, the config
+// file is rewritten and cloud sync is asked to publish it. Nothing changes if
+// change fails; ErrNotPersisted means it was applied but not saved.
+func (s *AppState) UpdateConfig(change func(*config.Config) error) error {
+	s.updateMu.Lock()
+	defer s.updateMu.Unlock()
+	prev := s.GetConfig()
+	cfg := prev
+	cfg.Keys = append([]string(nil), cfg.Keys...)
+	cfg.Accounts = append([]config.AccountConfig(nil), cfg.Accounts...)
+	if err := change(&cfg); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.cfg = cfg
+	s.mu.Unlock()
+	if !slices.Equal(cfg.Accounts, prev.Accounts) || cfg.Refresh != prev.Refresh || cfg.MaxActiveAccounts != prev.MaxActiveAccounts {
+		s.Pool.Reload(cfg.Accounts, cfg.Refresh, cfg.MaxActiveAccounts)
+	}
+	if n, ok := s.Sync.(interface{ Notify() }); ok {
+		n.Notify()
+	}
+	if cfg.Path == "" {
+		return ErrNotPersisted
+	}
+	err := config.SaveFields(cfg.Path, map[string]any{
+		"keys":                 cfg.Keys,
+		"accounts":             s.withPoolTokens(cfg.Accounts),
+		"claude_model_mapping": cfg.ClaudeModelMapping,
+		"max_active_accounts":  cfg.MaxActiveAccounts,
+	})
+	if err != nil {
+		return fmt.Errorf("saving %s: %w", cfg.Path, err)
+	}
+	return nil
+}
+
+// ConfiguredAccounts returns every configured account, including those left
+// out of the pool by max_active_accounts, with the tokens the pool holds.
+func (s *AppState) ConfiguredAccounts() []config.AccountConfig {
+	return s.withPoolTokens(s.GetConfig().Accounts)
+}
+
+// withPoolTokens returns accounts with the tokens the pool has logged in for
+// since they were configured.
+func (s *AppState) withPoolTokens(accounts []config.AccountConfig) []config.AccountConfig {
+	pooled := map[string]config.AccountConfig{}
+	for _, a := range s.Pool.SnapshotConfigAccounts() {
+		pooled[a.ID()] = a
+	}
+	out := append([]config.AccountConfig(nil), accounts...)
+	for i, a := range out {
+		if p, ok := pooled[a.ID()]; ok && p.Token != "" && p.TokenIssuedAt > a.TokenIssuedAt {
+			out[i].Token, out[i].TokenIssuedAt = p.Token, p.TokenIssuedAt
+		}
+	}
+	return out
+}
+
+// SetAccounts records the configured account list after it was replaced from
+// outside, so later updates start from it.
+func (s *AppState) SetAccounts(accounts []config.AccountConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg.Accounts = append([]config.AccountConfig(nil), accounts...)
+}
+
+func (s *AppState) SetKeys(keys []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg.Keys = append([]string(nil), keys...)
+}
+
 func (s *AppState) UpdateSyncRuntime(refresh bool, maxActiveAccounts int, mapping map[string]string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -69,6 +188,38 @@ func (s *AppState) UpdateSyncRuntime(refresh bool, maxActiveAccounts int, mappin
 	s.cfg.ClaudeModelMapping = copyStringMap(mapping)
 }
 
+// SetAccountStrategy switches the pool's account selection strategy at
+// runtime and records it in the config snapshot.
+func (s *AppState) SetAccountStrategy(name string) error {
+	if err := s.Pool.SetStrategy(name); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg.AccountStrategy = s.Pool.StrategyName()
+	return nil
+}
+
+// RunTokenPersistence writes tokens refreshed by the pool back to the config
+// file and publishes them through cloud sync, coalescing bursts of logins.
+func (s *AppState) RunTokenPersistence(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.tokenSaves:
+		}
+		if path := s.GetConfig().Path; path != "" {
+			if err := config.SaveAccountTokens(path, s.Pool.SnapshotConfigAccounts()); err != nil {
+				s.Logger.Warnf("saving account tokens to %s: %v", path, err)
+			}
+		}
+		if n, ok := s.Sync.(interface{ Notify() }); ok {
+			n.Notify()
+		}
+	}
+}
+
 func (s *AppState) MarkSyncSuccess(version, cursor int64) {
 	s.mu.Lock()
 	defer s.mu.Unlock()